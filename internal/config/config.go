@@ -18,8 +18,44 @@ type Config struct {
 
 // ServerConfig holds the configuration settings for the HTTP server.
 type ServerConfig struct {
-	Host string `yaml:"host"`
-	Port int    `yaml:"port"`
+	Host   string      `yaml:"host"`
+	Port   int         `yaml:"port"`
+	MaxLag int64       `yaml:"max_lag"` //readyz允许存储高度落后节点的区块数
+	Auth   *AuthConfig `yaml:"auth"`
+	CORS   *CORSConfig `yaml:"cors"`
+	TLS    *ServerTLS  `yaml:"tls"`
+}
+
+// ServerTLS HTTP服务TLS配置 配置client_ca_file后校验客户端证书
+type ServerTLS struct {
+	CertFile          string `yaml:"cert_file"`
+	KeyFile           string `yaml:"key_file"`
+	ClientCAFile      string `yaml:"client_ca_file"`
+	RequireClientCert bool   `yaml:"require_client_cert"` //强制mTLS
+}
+
+// AuthConfig API key认证及限流 key可配置在此处或通过admin接口存储在数据库中
+type AuthConfig struct {
+	Enabled  bool            `yaml:"enabled"`
+	AdminKey string          `yaml:"admin_key"` //为空时禁用admin接口
+	Rate     float64         `yaml:"rate"`      //默认每秒请求数
+	Burst    int             `yaml:"burst"`     //默认突发请求数
+	Quota    int64           `yaml:"quota"`     //默认每日请求配额 0不限制
+	KeyTTL   int             `yaml:"key_ttl"`   //数据库中key的缓存秒数，过期后重新读取(多实例间同步吊销) 默认30
+	Keys     []*APIKeyConfig `yaml:"keys"`
+}
+
+type APIKeyConfig struct {
+	Key   string  `yaml:"key"`
+	Name  string  `yaml:"name"`
+	Rate  float64 `yaml:"rate"` //0使用默认值
+	Burst int     `yaml:"burst"`
+	Quota int64   `yaml:"quota"`
+}
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	AllowOrigins []string `yaml:"allow_origins"` //为空时允许所有来源
 }
 
 // BadgerDBConfig holds the configuration settings for BadgerDB.
@@ -28,20 +64,76 @@ type BadgerDBConfig struct {
 }
 
 type DBConfig struct {
-	Dir    string `yaml:"dir"`
-	DBType string `yaml:"db_type"`
+	Dir        string `yaml:"dir"`
+	DBType     string `yaml:"db_type"`
+	ReadOnly   bool   `yaml:"read_only"`   //只读打开 api-only模式
+	Mode       string `yaml:"mode"`        //archive(默认)保留全部已花费记录 prune删除超过prune_depth的已花费记录
+	PruneDepth int64  `yaml:"prune_depth"` //prune模式保留已花费记录的区块数 默认288
+
+	StoreDirs map[string]string          `yaml:"store_dirs"` //按存储(utxo、balance、address_utxo)指定目录 未指定的使用dir
+	Options   map[string]*BackendOptions `yaml:"options"`    //按存储类型(goleveldb、pebbledb、rocksdb)的调优参数 使用db_type对应的一项
+}
+
+// BackendOptions 存储引擎调优参数 三个存储分别生效，0或空使用后端默认值
+type BackendOptions struct {
+	BlockCacheMB  int    `yaml:"block_cache_mb"`  //数据块读缓存
+	WriteBufferMB int    `yaml:"write_buffer_mb"` //memtable大小
+	Compression   string `yaml:"compression"`     //none、snappy，pebbledb及rocksdb支持zstd，rocksdb支持lz4
+	BloomBits     int    `yaml:"bloom_bits"`      //bloom过滤器每个key的位数
+	MaxOpenFiles  int    `yaml:"max_open_files"`
 }
 
 // BitcoinRPCConfig holds the configuration settings for Bitcoin JSON-RPC.
+// url等字段为主节点，endpoints为备用节点，按配置顺序优先使用健康的节点
 type BitcoinRPCConfig struct {
-	URL      string `yaml:"url"`
-	User     string `yaml:"user"`
-	Password string `yaml:"password"`
+	URL            string         `yaml:"url"`
+	User           string         `yaml:"user"`
+	Password       string         `yaml:"password"`
+	CookieFile     string         `yaml:"cookie_file"` //user/password为空时使用节点.cookie文件认证
+	TLS            *RPCTLS        `yaml:"tls"`
+	Endpoints      []*RPCEndpoint `yaml:"endpoints"`
+	HealthInterval int            `yaml:"health_interval"` //健康检查间隔(秒) 默认10
+	MaxLag         int64          `yaml:"max_lag"`         //落后最高节点超过该区块数视为不健康 默认3
+}
+
+// RPCEndpoint 单个节点RPC配置
+type RPCEndpoint struct {
+	URL        string  `yaml:"url"`
+	User       string  `yaml:"user"`
+	Password   string  `yaml:"password"`
+	CookieFile string  `yaml:"cookie_file"`
+	TLS        *RPCTLS `yaml:"tls"`
+}
+
+// EndpointList 返回主节点及备用节点
+func (c *BitcoinRPCConfig) EndpointList() []*RPCEndpoint {
+	list := make([]*RPCEndpoint, 0, len(c.Endpoints)+1)
+	if len(c.URL) > 0 {
+		list = append(list, &RPCEndpoint{
+			URL:        c.URL,
+			User:       c.User,
+			Password:   c.Password,
+			CookieFile: c.CookieFile,
+			TLS:        c.TLS,
+		})
+	}
+	return append(list, c.Endpoints...)
+}
+
+// RPCTLS 节点RPC连接TLS配置 cert_file/key_file用于mTLS
+type RPCTLS struct {
+	Enabled    bool   `yaml:"enabled"`
+	CAFile     string `yaml:"ca_file"` //为空时使用系统根证书
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
 }
 
 type IndexerConfig struct {
-	BatchSize    int `yaml:"batch_size"`     //阈值 累计达到该值进行存储 len(vin)+len(vout)
-	BlockChanBuf int `yaml:"block_chan_buf"` //在存储过程中还可以查询该缓冲区大小个块
+	BatchSize        int   `yaml:"batch_size"`         //阈值 累计达到该值进行存储 len(vin)+len(vout)
+	BlockChanBuf     int   `yaml:"block_chan_buf"`     //在存储过程中还可以查询该缓冲区大小个块
+	CacheSize        int   `yaml:"cache_size"`         //写回缓存的记录数上限(utxo、地址余额及utxo集合) 0不缓存
+	CacheFlushBlocks int64 `yaml:"cache_flush_blocks"` //缓存最多累计的区块数 0只按记录数刷新
 }
 
 // LoadConfig reads and parses the configuration file.
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"google.golang.org/protobuf/proto"
+)
+
+const apiKeyPrefix = "ak:"
+
+// GetAPIKey 按key id(api key的sha256)读取 不存在时返回nil
+func (db *DB) GetAPIKey(id string) (*ApiKey, error) {
+	val, err := db.udb.Get([]byte(apiKeyPrefix + id))
+	if err != nil {
+		return nil, err
+	}
+	if len(val) == 0 {
+		return nil, nil
+	}
+	ak := &ApiKey{}
+	if err := proto.Unmarshal(val, ak); err != nil {
+		return nil, err
+	}
+	return ak, nil
+}
+
+func (db *DB) PutAPIKey(id string, ak *ApiKey) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	b, err := proto.Marshal(ak)
+	if err != nil {
+		return err
+	}
+	return db.udb.SetSync([]byte(apiKeyPrefix+id), b)
+}
+
+func (db *DB) DeleteAPIKey(id string) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	return db.udb.DeleteSync([]byte(apiKeyPrefix + id))
+}
+
+// ListAPIKeys 返回 key id -> 配置
+func (db *DB) ListAPIKeys() (map[string]*ApiKey, error) {
+	it, err := db.udb.Iterator([]byte(apiKeyPrefix), prefixEnd([]byte(apiKeyPrefix)))
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	keys := make(map[string]*ApiKey)
+	for ; it.Valid(); it.Next() {
+		ak := &ApiKey{}
+		if err := proto.Unmarshal(it.Value(), ak); err != nil {
+			return nil, err
+		}
+		keys[string(it.Key()[len(apiKeyPrefix):])] = ak
+	}
+	return keys, it.Error()
+}
+
+// prefixEnd 前缀迭代的结束key
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
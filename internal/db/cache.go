@@ -0,0 +1,150 @@
+package db
+
+import (
+	"time"
+
+	"github.com/scylladb/go-set/strset"
+	"github.com/shopspring/decimal"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// writeCache Store的写回缓存(类似Bitcoin Core的dbcache)
+// 缓存最近几次存储的utxo记录、地址余额及utxo集合(合并后的值)，后续存储优先从缓存读取，
+// 初始同步时大部分输出在几个区块内被花费，产生并花费的记录只写入一次。
+// 存储高度只在刷新时写入，磁盘上的数据始终对应刷新时的高度，查询接口只读磁盘；进程退出未刷新的区块重新同步
+type writeCache struct {
+	utxos    map[string]*UtxoInfo
+	balances map[string]decimal.Decimal //零表示删除
+	sets     map[string]*strset.Set     //空集合表示删除
+	meta     map[string][]byte          //随utxo记录写入udb的元数据
+	height   int64                      //缓存对应的存储高度 0表示缓存为空
+	blocks   int64                      //自上次刷新以来存储的区块数
+
+	maxEntries  int   //缓存记录数上限 0表示不缓存，每次存储直接写入
+	flushBlocks int64 //累计区块数达到该值时刷新 0表示只按记录数刷新
+}
+
+func newWriteCache() *writeCache {
+	c := &writeCache{}
+	c.reset()
+	return c
+}
+
+func (c *writeCache) reset() {
+	c.utxos = make(map[string]*UtxoInfo)
+	c.balances = make(map[string]decimal.Decimal)
+	c.sets = make(map[string]*strset.Set)
+	c.meta = make(map[string][]byte)
+	c.height, c.blocks = 0, 0
+	metrics.UTXOCacheEntries.Set(0)
+}
+
+func (c *writeCache) entries() int {
+	return len(c.utxos) + len(c.balances) + len(c.sets) + len(c.meta)
+}
+
+// add 合并一次存储的结果 后写入的值覆盖之前的值
+func (c *writeCache) add(um map[string]*UtxoInfo, abm map[string]decimal.Decimal, aum map[string]*strset.Set, meta map[string][]byte, height int64) {
+	for k, v := range um {
+		c.utxos[k] = v
+	}
+	for k, v := range abm {
+		c.balances[k] = v
+	}
+	for k, v := range aum {
+		c.sets[k] = v
+	}
+	for k, v := range meta {
+		c.meta[k] = v
+	}
+	if height > c.height {
+		c.blocks += height - c.height
+	}
+	c.height = height
+	metrics.UTXOCacheEntries.Set(float64(c.entries()))
+}
+
+func (c *writeCache) full() bool {
+	return c.entries() >= c.maxEntries || (c.flushBlocks > 0 && c.blocks >= c.flushBlocks)
+}
+
+// SetCache 启用写回缓存 maxEntries为缓存记录数上限，flushBlocks为最多缓存的区块数
+// 由索引同步设置，同步到最新区块后调用Flush使查询及时可见
+func (db *DB) SetCache(maxEntries int, flushBlocks int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.cache.maxEntries, db.cache.flushBlocks = maxEntries, flushBlocks
+}
+
+// lookupUtxo 存储时读取utxo记录 优先从缓存读取
+func (db *DB) lookupUtxo(ukey string) (*UtxoInfo, error) {
+	if info, ok := db.cache.utxos[ukey]; ok {
+		metrics.UTXOCacheHits.Inc()
+		return info, nil
+	}
+	metrics.UTXOCacheMisses.Inc()
+	return db.getUtxoInfo(ukey)
+}
+
+// Flush 将缓存写入数据库并更新存储高度
+func (db *DB) Flush() error {
+	if db.readOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.flush()
+}
+
+// flush 需持有写锁
+func (db *DB) flush() error {
+	c := db.cache
+	if c.height == 0 {
+		return nil
+	}
+	start := time.Now()
+	entries := c.entries()
+
+	// prune模式下刷新前产生、已超过保留深度的花费记录不写入
+	var absorbed int
+	if db.pruneDepth > 0 {
+		flushed, err := db.GetStoreHeight()
+		if err != nil {
+			return err
+		}
+		target := c.height - db.pruneDepth
+		for key, info := range c.utxos {
+			if info.Height > flushed && info.Spend != nil && info.Spend.Height <= target {
+				delete(c.utxos, key)
+				delete(c.meta, string(spendIndexKey(info.Spend.Height, key)))
+				absorbed++
+			}
+		}
+	}
+
+	if err := db.batchStore(c.utxos, c.balances, c.sets, c.meta); err != nil {
+		return err
+	}
+	if err := db.storeLastHeight(c.height); err != nil {
+		return err
+	}
+	height := c.height
+	c.reset()
+	metrics.UTXOCacheFlushes.Inc()
+	metrics.StoreHeight.Set(float64(height))
+
+	//删除失败不影响索引，下次刷新时继续
+	if n, err := db.prune(height); err != nil {
+		db.logger.Error("prune", zap.Error(err))
+	} else if n > 0 || absorbed > 0 {
+		db.logger.Debug("Prune::Info", zap.Int64("lastHeight", height), zap.Int("deleted", n), zap.Int("absorbed", absorbed))
+	}
+	if c.maxEntries > 0 {
+		db.logger.Info("Flush::Info",
+			zap.Int64("lastHeight", height),
+			zap.Int("entries", entries),
+			zap.Duration("ttl", time.Since(start)))
+	}
+	return nil
+}
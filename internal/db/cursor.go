@@ -0,0 +1,47 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor 分页游标 记录首次查询时的存储高度、查询条件以及上一页最后一个utxo key(排序时还有排序字段值)
+// 对客户端不透明，序列化为base64
+type cursor struct {
+	Height int64   `json:"h"`
+	Key    []byte  `json:"k"` //二进制utxo key
+	Value  float64 `json:"v,omitempty"`
+	Query  uint64  `json:"q"` //地址及过滤排序条件的哈希 翻页时条件变化则游标无效
+}
+
+// queryHash 地址及过滤排序条件的哈希
+func queryHash(req *model.UTXORequest) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s|%v|%v|%d|%v|%s|%s", req.Address, req.MinValue, req.MaxValue, req.MinConf,
+		req.DustThreshold, req.SortBy, req.Order)
+	return h.Sum64()
+}
+
+func (c *cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w:%s", ErrInvalidCursor, s)
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(b, c); err != nil || len(c.Key) == 0 {
+		return nil, fmt.Errorf("%w:%s", ErrInvalidCursor, s)
+	}
+	return c, nil
+}
@@ -2,19 +2,21 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"golang.org/x/sync/errgroup"
+	"sort"
 	"strconv"
-	"strings"
+	"sync"
 	"time"
 
-	tmdb "github.com/cosmos/cosmos-db"
 	"github.com/scylladb/go-set/strset"
 	"github.com/shopspring/decimal"
 	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
 	"github.com/wx-shi/utxo-indexer/internal/model"
 	"github.com/wx-shi/utxo-indexer/pkg"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -30,37 +32,75 @@ const (
 	audbName = "address_utxo"
 )
 
+// ErrReadOnly 只读模式下写入
+var ErrReadOnly = errors.New("db is opened read-only")
+
 type DB struct {
-	udb    tmdb.DB
-	bdb    tmdb.DB
-	audb   tmdb.DB
-	logger *zap.Logger
+	udb        KV
+	bdb        KV
+	audb       KV
+	logger     *zap.Logger
+	readOnly   bool
+	pruneDepth int64             //prune模式下已花费记录保留的区块数 0为archive模式
+	cache      *writeCache       //存储的写回缓存 持有写锁访问
+	dirs       map[string]string //各存储所在目录 memdb为空
+	mu         sync.RWMutex      //Store、Rollback持有写锁，校验按批持有锁，避免读到写入一半的数据
 }
 
 func NewDB(conf *config.DBConfig, logger *zap.Logger) (*DB, error) {
-	udb, err := tmdb.NewDB(udbName, tmdb.BackendType(conf.DBType), conf.Dir)
+	depth, err := pruneDepth(conf)
 	if err != nil {
 		return nil, err
 	}
-	bdb, err := tmdb.NewDB(bdbName, tmdb.BackendType(conf.DBType), conf.Dir)
+	if err := checkStoreDirs(conf); err != nil {
+		return nil, err
+	}
+	udb, err := openKV(udbName, conf)
+	if err != nil {
+		return nil, err
+	}
+	bdb, err := openKV(bdbName, conf)
 	if err != nil {
 		return nil, err
 	}
 
-	audb, err := tmdb.NewDB(audbName, tmdb.BackendType(conf.DBType), conf.Dir)
+	audb, err := openKV(audbName, conf)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DB{
-		udb:    udb,
-		bdb:    bdb,
-		audb:   audb,
-		logger: logger,
-	}, nil
+	db := &DB{
+		udb:        udb,
+		bdb:        bdb,
+		audb:       audb,
+		logger:     logger,
+		readOnly:   conf.ReadOnly,
+		pruneDepth: depth,
+		cache:      newWriteCache(),
+	}
+	if conf.DBType != MemDBBackend {
+		db.dirs = make(map[string]string, len(storeNames))
+		for _, name := range storeNames {
+			db.dirs[name] = storeDir(name, conf)
+		}
+	}
+	if err := db.checkSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if to, ok, err := db.pendingRollback(); err != nil {
+		db.Close()
+		return nil, err
+	} else if ok {
+		db.logger.Error("Rollback::Incomplete", zap.Int64("to", to))
+	}
+	return db, nil
 }
 
 func (db *DB) Close() error {
+	if err := db.Flush(); err != nil {
+		db.logger.Error("Flush", zap.Error(err))
+	}
 	g, _ := errgroup.WithContext(context.Background())
 	g.Go(db.udb.Close)
 	g.Go(db.bdb.Close)
@@ -79,14 +119,41 @@ func (db *DB) GetStoreHeight() (int64, error) {
 	return pkg.BytesToInt64(val), err
 }
 
-func (db *DB) GetUTXOByAddress(address string, page int, pageSize int) (*model.UTXOReply, error) {
-
+// GetUTXOByAddress 查询地址余额及utxo列表
+// 未传cursor时按页码分页；传入cursor时从游标位置继续，并忽略游标快照高度之后产生的utxo，
+// 保证新块到达时翻页不会跳过或重复。带过滤/排序条件时total_size为过滤后的数量
+func (db *DB) GetUTXOByAddress(req *model.UTXORequest) (*model.UTXOReply, error) {
+	address := req.Address
 	abKey := addressBalanceKeyPrefix + address
 	auKey := addressUtxoKeyPrefix + address
 
 	reply := &model.UTXOReply{
-		Page:     page,
-		PageSize: pageSize,
+		Page:     req.Page,
+		PageSize: req.PageSize,
+	}
+
+	if req.PageSize <= 0 {
+		return nil, fmt.Errorf("%w:page_size %d", ErrInvalidQuery, req.PageSize)
+	}
+	if err := validateQuery(req); err != nil {
+		return nil, err
+	}
+
+	var c *cursor
+	if len(req.Cursor) > 0 {
+		var err error
+		if c, err = decodeCursor(req.Cursor); err != nil {
+			return nil, err
+		}
+		if c.Query != queryHash(req) {
+			return nil, fmt.Errorf("%w:query changed", ErrInvalidCursor)
+		}
+	} else {
+		sheight, err := db.GetStoreHeight()
+		if err != nil {
+			return nil, err
+		}
+		c = &cursor{Height: sheight, Query: queryHash(req)}
 	}
 
 	// 获取余额
@@ -109,7 +176,7 @@ func (db *DB) GetUTXOByAddress(address string, page int, pageSize int) (*model.U
 	}
 
 	// 获取utxo列表
-	us := &StringSet{}
+	var members []string
 	{
 		uitem, err := db.audb.Get([]byte(auKey))
 		if err != nil {
@@ -120,48 +187,145 @@ func (db *DB) GetUTXOByAddress(address string, page int, pageSize int) (*model.U
 			return reply, nil
 		}
 
-		if err := proto.Unmarshal(uitem, us); err != nil {
+		if members, err = unmarshalMembers(uitem); err != nil {
 			return nil, err
 		}
 	}
 
-	// 获取utxo列表
-	reply.TotalSize = len(us.Members)
-	uarr := pkg.Paginate(us.Members, page, pageSize)
+	// 集合成员顺序不固定 排序后分页
+	sort.Strings(members)
 
-	utxos := make([]*model.UTXO, 0, len(uarr))
-	for _, ukey := range uarr {
-		keyArr := strings.Split(ukey, ":")
-		if len(keyArr) != 3 {
-			return nil, fmt.Errorf("invalid key:%s", ukey)
+	// 带过滤或排序条件
+	if hasFilter(req) {
+		if err := db.filterUtxos(reply, req, c, members); err != nil {
+			return nil, err
 		}
-		val, err := db.udb.Get([]byte(ukey))
+		return reply, nil
+	}
+
+	// 翻页期间有新块时total_size只计快照高度及之前的utxo
+	total, err := db.countAtHeight(address, members, c.Height)
+	if err != nil {
+		return nil, err
+	}
+	reply.TotalSize = total
+
+	var start int
+	if len(c.Key) > 0 {
+		start = sort.SearchStrings(members, string(c.Key))
+		if start < len(members) && members[start] == string(c.Key) {
+			start++
+		}
+	} else {
+		start = req.Page * req.PageSize
+		if start > len(members) {
+			start = len(members)
+		}
+	}
+
+	utxos := make([]*model.UTXO, 0, req.PageSize)
+	for _, ukey := range members[start:] {
+		info, utxo, err := db.getAddressUtxo(address, ukey)
 		if err != nil {
 			return nil, err
 		}
-		info := &UtxoInfo{}
-		if err := proto.Unmarshal(val, info); err != nil {
-			return nil, err
+		// 快照之后产生的utxo
+		if info.Height > c.Height {
+			continue
 		}
-		txid := keyArr[1]
-		index, err := strconv.Atoi(keyArr[2])
+		if len(utxos) == req.PageSize {
+			reply.NextCursor = (&cursor{Height: c.Height, Key: c.Key, Query: c.Query}).encode()
+			break
+		}
+		utxos = append(utxos, utxo)
+		c.Key = []byte(ukey)
+	}
+
+	reply.Utxos = utxos
+
+	return reply, nil
+}
+
+// countAtHeight 地址utxo中不高于快照高度的数量 存储高度未超过快照高度时无需读取utxo
+func (db *DB) countAtHeight(address string, members []string, height int64) (int, error) {
+	sheight, err := db.GetStoreHeight()
+	if err != nil || sheight <= height {
+		return len(members), err
+	}
+	var n int
+	for _, ukey := range members {
+		info, _, err := db.getAddressUtxo(address, ukey)
 		if err != nil {
-			return nil, fmt.Errorf("invalid key:%s", ukey)
+			return 0, err
+		}
+		if info.Height <= height {
+			n++
 		}
+	}
+	return n, nil
+}
+
+// HasUTXO 地址下是否有utxo
+func (db *DB) HasUTXO(address string) (bool, error) {
+	return db.audb.Has([]byte(addressUtxoKeyPrefix + address))
+}
+
+// ListUTXOByAddress 读取地址下全部utxo 用于选币/构造交易
+func (db *DB) ListUTXOByAddress(address string) ([]*model.AddressUTXO, error) {
+	uitem, err := db.audb.Get([]byte(addressUtxoKeyPrefix + address))
+	if err != nil {
+		return nil, err
+	}
+	if len(uitem) == 0 {
+		return nil, nil
+	}
+	members, err := unmarshalMembers(uitem)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(members)
 
-		if info.Address != address {
-			return nil, fmt.Errorf("data anomalies key:%s value:%v", ukey, info)
+	list := make([]*model.AddressUTXO, 0, len(members))
+	for _, ukey := range members {
+		info, utxo, err := db.getAddressUtxo(address, ukey)
+		if err != nil {
+			return nil, err
 		}
-		utxos = append(utxos, &model.UTXO{
-			TxID:  txid,
-			Index: index,
-			Value: fmt.Sprintf("%.8f", info.Value),
+		list = append(list, &model.AddressUTXO{
+			TxID:    utxo.TxID,
+			Index:   utxo.Index,
+			Address: info.Address,
+			Value:   info.Value,
+			Height:  info.Height,
+			Script:  info.Script,
 		})
 	}
+	return list, nil
+}
 
-	reply.Utxos = utxos
+// getAddressUtxo 读取地址下的单个utxo
+func (db *DB) getAddressUtxo(address string, ukey string) (*UtxoInfo, *model.UTXO, error) {
+	txid, index, err := parseUKey(ukey)
+	if err != nil {
+		return nil, nil, err
+	}
+	val, err := db.udb.Get([]byte(ukey))
+	if err != nil {
+		return nil, nil, err
+	}
+	info := &UtxoInfo{}
+	if err := proto.Unmarshal(val, info); err != nil {
+		return nil, nil, err
+	}
 
-	return reply, nil
+	if info.Address != address {
+		return nil, nil, fmt.Errorf("data anomalies key:%s value:%v", formatUKey(ukey), info)
+	}
+	return info, &model.UTXO{
+		TxID:  txid,
+		Index: int(index),
+		Value: fmt.Sprintf("%.8f", info.Value),
+	}, nil
 }
 
 func (db *DB) GetUTXOInfoByKeys(keys []string) (model.UTXOInfoReply, error) {
@@ -169,17 +333,24 @@ func (db *DB) GetUTXOInfoByKeys(keys []string) (model.UTXOInfoReply, error) {
 
 	for i := 0; i < len(keys); i++ {
 		key := keys[i]
-		val, err := db.udb.Get([]byte(utxoKeyPrefix + key))
+		// 格式错误的key视为不存在
+		txid, index, err := pkg.ParseOutpoint(key)
+		if err != nil {
+			reply[key] = nil
+			continue
+		}
+		ukey, err := UtxoKey(txid, index)
+		if err != nil {
+			reply[key] = nil
+			continue
+		}
+		info, err := db.getUtxoInfo(ukey)
 		if err != nil {
 			return nil, err
 		}
-		if len(val) == 0 {
+		if info == nil {
 			reply[key] = nil
 		} else {
-			info := &UtxoInfo{}
-			if err := proto.Unmarshal(val, info); err != nil {
-				return nil, err
-			}
 			reply[key] = &model.UtxoInfo{
 				Address: info.Address,
 				Value:   info.Value,
@@ -190,43 +361,93 @@ func (db *DB) GetUTXOInfoByKeys(keys []string) (model.UTXOInfoReply, error) {
 	return reply, nil
 }
 
+// GetOutpoint 读取单个outpoint记录(含已花费) 不存在时返回nil
+func (db *DB) GetOutpoint(txid string, index uint32) (*UtxoInfo, error) {
+	ukey, err := UtxoKey(txid, index)
+	if err != nil {
+		return nil, err
+	}
+	return db.getUtxoInfo(ukey)
+}
+
 // store 存储
 func (db *DB) Store(vins []model.In, vouts []model.Out, lastHeight int64) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	start := time.Now()
 
-	utxom, abm, aum, err := db.parseUtxo(vins, vouts)
+	utxom, abm, aum, delta, err := db.parseUtxo(vins, vouts)
 	if err != nil {
 		db.logger.Fatal("parseUtxo", zap.Error(err))
 	}
 
-	//store
-	if err := db.batchStore(utxom, abm, aum); err != nil {
-		db.logger.Fatal("batchStore", zap.Error(err))
+	//utxo集合哈希与utxo记录在同一批次写入
+	meta := make(map[string][]byte, 1)
+	st, err := db.applyUTXOSetDelta(delta, lastHeight)
+	if err != nil {
+		db.logger.Fatal("applyUTXOSetDelta", zap.Error(err))
 	}
+	if st != nil {
+		meta[utxoSetKey] = st
+	}
+	db.addSpendIndex(utxom, meta)
 
-	if err := db.storeLastHeight(lastHeight); err != nil {
-		db.logger.Fatal("storeLastHeight", zap.Error(err))
+	//store 未启用缓存或缓存已满时写入数据库
+	db.cache.add(utxom, abm, aum, meta, lastHeight)
+	if db.cache.full() {
+		if err := db.flush(); err != nil {
+			db.logger.Fatal("flush", zap.Error(err))
+		}
 	}
 
+	ttl := time.Since(start)
+	metrics.StoreDuration.Observe(ttl.Seconds())
+	metrics.StoreBatchSize.Observe(float64(len(vins) + len(vouts)))
 	db.logger.Info("Store::Info",
 		zap.Int64("lastHeight", lastHeight),
 		zap.Int("vout_len", len(vouts)),
 		zap.Int("vin_len", len(vins)),
-		zap.Duration("ttl", time.Since(start)))
+		zap.Duration("ttl", ttl))
 	return nil
 }
 
-func (db *DB) parseUtxo(vins []model.In, vouts []model.Out) (map[string]*UtxoInfo, map[string]decimal.Decimal, map[string]*strset.Set, error) {
+func (db *DB) parseUtxo(vins []model.In, vouts []model.Out) (map[string]*UtxoInfo, map[string]decimal.Decimal, map[string]*strset.Set, *utxoSetDelta, error) {
 	um := make(map[string]*UtxoInfo, defaultMapCap)
 	abm := make(map[string]decimal.Decimal, defaultMapCap) //存储地址金额变动
 	aaum := make(map[string]*strset.Set, defaultMapCap)    //存储地址下面新增utxo集合
 	adum := make(map[string]*strset.Set, defaultMapCap)    //存储地址下面移除utxo集合
 	am := make(map[string]struct{}, defaultMapCap)         //地址
+	delta := &utxoSetDelta{}
 	needSearchInfoKeys := make([]string, 0, defaultMapCap)
 	for _, vout := range vouts {
-		um[vout.UKey] = &UtxoInfo{
-			Address: vout.Address,
-			Value:   vout.Value,
+		info := &UtxoInfo{
+			Address:  vout.Address,
+			Value:    vout.Value,
+			Height:   vout.Height,
+			Script:   vout.Script,
+			Coinbase: vout.Coinbase,
+		}
+		// BIP30之前重复的coinbase交易会覆盖未花费的同名utxo
+		if vout.Coinbase {
+			old, err := db.lookupUtxo(vout.UKey)
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+			if old != nil && old.Spend == nil {
+				delta.removed = append(delta.removed, coin{vout.UKey, old})
+				if len(old.Address) > 0 {
+					updateBalance(abm, old.Address, -old.Value)
+					am[old.Address] = struct{}{}
+				}
+			}
+		}
+		um[vout.UKey] = info
+		delta.added = append(delta.added, coin{vout.UKey, info})
+		if len(vout.Address) == 0 {
+			continue
 		}
 		am[vout.Address] = struct{}{}
 
@@ -239,10 +460,14 @@ func (db *DB) parseUtxo(vins []model.In, vouts []model.Out) (map[string]*UtxoInf
 	for _, vin := range vins {
 		if ui, ok := um[vin.UKey]; ok {
 			ui.Spend = &Spend{
-				Txid:  vin.Spend.TxID,
-				Index: uint32(vin.Spend.Index),
+				Txid:   vin.Spend.TxID,
+				Index:  uint32(vin.Spend.Index),
+				Height: vin.Spend.Height,
 			}
 			um[vin.UKey] = ui
+			if len(ui.Address) == 0 {
+				continue
+			}
 
 			//移除地址utxo集合
 			addAddressUtxo(adum, ui.Address, vin.UKey)
@@ -252,30 +477,44 @@ func (db *DB) parseUtxo(vins []model.In, vouts []model.Out) (map[string]*UtxoInf
 			//已花费 待查询地址金额
 			um[vin.UKey] = &UtxoInfo{
 				Spend: &Spend{
-					Txid:  vin.Spend.TxID,
-					Index: uint32(vin.Spend.Index),
+					Txid:   vin.Spend.TxID,
+					Index:  uint32(vin.Spend.Index),
+					Height: vin.Spend.Height,
 				},
 			}
 			needSearchInfoKeys = append(needSearchInfoKeys, vin.UKey)
 		}
 	}
 
+	//同一批次中产生并花费的utxo不影响utxo集合
+	added := delta.added[:0]
+	for _, c := range delta.added {
+		if c.info.Spend == nil {
+			added = append(added, c)
+		}
+	}
+	delta.added = added
+
 	//查询utxo
 	for _, key := range needSearchInfoKeys {
-		val, err := db.udb.Get([]byte(key))
+		info, err := db.lookupUtxo(key)
 		if err != nil {
-			return nil, nil, nil, err
+			return nil, nil, nil, nil, err
 		}
-		if len(val) > 0 {
-			info := &UtxoInfo{}
-			if err := proto.Unmarshal(val, info); err != nil {
-				return nil, nil, nil, err
-			}
-
+		if info != nil {
 			ui := um[key]
 			ui.Address = info.Address
 			ui.Value = info.Value
+			ui.Height = info.Height
+			ui.Script = info.Script
+			ui.Coinbase = info.Coinbase
 			um[key] = ui
+			if info.Spend == nil {
+				delta.removed = append(delta.removed, coin{key, info})
+			}
+			if len(ui.Address) == 0 {
+				continue
+			}
 
 			//移除地址utxo集合
 			addAddressUtxo(adum, ui.Address, key)
@@ -286,45 +525,71 @@ func (db *DB) parseUtxo(vins []model.In, vouts []model.Out) (map[string]*UtxoInf
 	}
 
 	//查询余额 地址下utxo集合
+	if err := db.mergeAddressState(am, abm, aaum, adum); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	return um, abm, aaum, delta, nil
+}
+
+// getUtxoInfo 读取utxo记录 不存在时返回nil
+func (db *DB) getUtxoInfo(ukey string) (*UtxoInfo, error) {
+	val, err := db.udb.Get([]byte(ukey))
+	if err != nil || len(val) == 0 {
+		return nil, err
+	}
+	info := &UtxoInfo{}
+	if err := proto.Unmarshal(val, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// mergeAddressState 将地址余额变动及utxo集合增减合并到当前值(缓存或数据库) 结果写回abm、aaum
+func (db *DB) mergeAddressState(am map[string]struct{}, abm map[string]decimal.Decimal, aaum, adum map[string]*strset.Set) error {
 	for addr := range am {
 		//余额
-		{
+		if bal, ok := db.cache.balances[addr]; ok {
+			abm[addr] = abm[addr].Add(bal)
+		} else {
 			bval, err := db.bdb.Get([]byte(addressBalanceKeyPrefix + addr))
 			if err != nil {
-				return nil, nil, nil, err
+				return err
 			}
 			var value float64
 			if len(bval) > 0 {
 				value, err = strconv.ParseFloat(string(bval), 64)
 				if err != nil {
-					return nil, nil, nil, err
+					return err
 				}
 				updateBalance(abm, addr, value)
 			}
 		}
 
 		//utxo
-		{
+		if set, ok := db.cache.sets[addr]; ok {
+			aaum[addr] = mergeUtxoSet(set, aaum[addr], adum[addr])
+		} else {
 			uval, err := db.audb.Get([]byte(addressUtxoKeyPrefix + addr))
 			if err != nil {
-				return nil, nil, nil, err
+				return err
 			}
 			if len(uval) > 0 {
-				ss := &StringSet{}
-				if err := proto.Unmarshal(uval, ss); err != nil {
-					return nil, nil, nil, err
+				members, err := unmarshalMembers(uval)
+				if err != nil {
+					return err
 				}
-				aaum[addr] = mergeUtxoSet(strset.New(ss.Members...), aaum[addr], adum[addr])
+				aaum[addr] = mergeUtxoSet(strset.New(members...), aaum[addr], adum[addr])
 			} else {
 				aaum[addr] = mergeUtxoSet(strset.New(), aaum[addr], adum[addr])
 			}
 		}
 	}
-
-	return um, abm, aaum, nil
+	return nil
 }
 
-func (db *DB) batchStore(um map[string]*UtxoInfo, abm map[string]decimal.Decimal, aum map[string]*strset.Set) error {
+// batchStore meta为随utxo记录一起写入udb的元数据
+func (db *DB) batchStore(um map[string]*UtxoInfo, abm map[string]decimal.Decimal, aum map[string]*strset.Set, meta map[string][]byte) error {
 	g, _ := errgroup.WithContext(context.Background())
 
 	g.Go(func() error {
@@ -341,6 +606,11 @@ func (db *DB) batchStore(um map[string]*UtxoInfo, abm map[string]decimal.Decimal
 				return err
 			}
 		}
+		for key, val := range meta {
+			if err := wb.Set([]byte(key), val); err != nil {
+				return err
+			}
+		}
 		// 提交WriteBatch，将数据写入数据库
 		return wb.WriteSync()
 	})
@@ -370,15 +640,14 @@ func (db *DB) batchStore(um map[string]*UtxoInfo, abm map[string]decimal.Decimal
 
 		for addr, set := range aum {
 			key := addressUtxoKeyPrefix + addr
-			if len(set.List()) == 0 {
+			if set.IsEmpty() {
 				if err := wb.Delete([]byte(key)); err != nil {
 					return err
 				}
 			} else {
-				ss := &StringSet{
-					Members: set.List(),
-				}
-				b, err := proto.Marshal(ss)
+				members := set.List()
+				sort.Strings(members)
+				b, err := marshalMembers(members)
 				if err != nil {
 					return err
 				}
@@ -0,0 +1,399 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/pkg"
+)
+
+// ExportVersion 导出文件格式版本
+//
+// gzip压缩的数据流:
+//
+//	magic(8) | uint16 version | int64 store height | int64 created at
+//	记录: uint8 store(1 utxo 2 balance 3 address_utxo) | uvarint len | key | uvarint len | value
+//	结尾: uint8 0 | uint64 记录数 | sha256(之前的全部字节)
+//
+// 存储高度只记录在文件头，导入校验通过后最后写入
+const ExportVersion = 1
+
+// importBatchSize 导入时每批写入的记录数
+const importBatchSize = 10000
+
+var exportMagic = []byte{'u', 't', 'x', 'o', 'i', 'd', 'x', 0}
+
+// ErrNotEmpty 导入目标数据库已有数据
+var ErrNotEmpty = errors.New("database is not empty")
+
+// ExportProgress 导出/导入进度回调
+type ExportProgress func(records int64)
+
+// exportStores 导出文件中的存储编号
+func (db *DB) exportStores() []KV {
+	return []KV{nil, db.udb, db.bdb, db.audb}
+}
+
+// Export 将三个存储的全部数据写入w
+// 持有写锁刷新写回缓存并创建各存储的迭代器后即释放锁，迭代器是创建时的快照，导出期间同进程内的索引同步可以继续，
+// 导出的数据对应同一存储高度；其他进程写入同一数据目录时不保证一致(goleveldb会加文件锁)
+func (db *DB) Export(ctx context.Context, w io.Writer, progress ExportProgress) (*model.ExportInfo, error) {
+	sheight, its, err := db.exportSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, it := range its {
+			if it != nil {
+				it.Close()
+			}
+		}
+	}()
+	info := &model.ExportInfo{
+		Version:     ExportVersion,
+		StoreHeight: sheight,
+		CreatedAt:   time.Now().Unix(),
+	}
+
+	gz := gzip.NewWriter(w)
+	bw := bufio.NewWriterSize(gz, 1<<20)
+	sum := sha256.New()
+	out := io.MultiWriter(bw, sum)
+
+	hdr := make([]byte, 0, len(exportMagic)+18)
+	hdr = append(hdr, exportMagic...)
+	hdr = binary.LittleEndian.AppendUint16(hdr, ExportVersion)
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(info.StoreHeight))
+	hdr = binary.LittleEndian.AppendUint64(hdr, uint64(info.CreatedAt))
+	if _, err := out.Write(hdr); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, 1024)
+	for id, it := range its {
+		if it == nil {
+			continue
+		}
+		for ; it.Valid(); it.Next() {
+			key, val := it.Key(), it.Value()
+			if id == 1 && bytes.Equal(key, []byte(StoreHeight)) {
+				continue
+			}
+			buf = append(buf[:0], byte(id))
+			buf = binary.AppendUvarint(buf, uint64(len(key)))
+			buf = append(buf, key...)
+			buf = binary.AppendUvarint(buf, uint64(len(val)))
+			buf = append(buf, val...)
+			if _, err := out.Write(buf); err != nil {
+				return nil, err
+			}
+			info.Records++
+			if info.Records%importBatchSize == 0 {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				if progress != nil {
+					progress(info.Records)
+				}
+			}
+		}
+		if err := it.Error(); err != nil {
+			return nil, err
+		}
+	}
+
+	buf = append(buf[:0], 0)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(info.Records))
+	if _, err := out.Write(buf); err != nil {
+		return nil, err
+	}
+	info.Checksum = fmt.Sprintf("%x", sum.Sum(nil))
+	if _, err := bw.Write(sum.Sum(nil)); err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	if progress != nil {
+		progress(info.Records)
+	}
+	return info, nil
+}
+
+// exportSnapshot 在写锁内刷新写回缓存，读取存储高度并创建各存储的迭代器
+func (db *DB) exportSnapshot() (int64, []tmdb.Iterator, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.readOnly {
+		if err := db.flush(); err != nil {
+			return 0, nil, err
+		}
+	}
+	sheight, err := db.GetStoreHeight()
+	if err != nil {
+		return 0, nil, err
+	}
+	stores := db.exportStores()
+	its := make([]tmdb.Iterator, len(stores))
+	for id, store := range stores {
+		if store == nil {
+			continue
+		}
+		if its[id], err = store.Iterator(nil, nil); err != nil {
+			for _, it := range its {
+				if it != nil {
+					it.Close()
+				}
+			}
+			return 0, nil, err
+		}
+	}
+	return sheight, its, nil
+}
+
+// hashReader 记录已读取字节的sha256
+type hashReader struct {
+	r *bufio.Reader
+	h hash.Hash
+}
+
+func (hr *hashReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+func (hr *hashReader) ReadByte() (byte, error) {
+	b, err := hr.r.ReadByte()
+	if err == nil {
+		hr.h.Write([]byte{b})
+	}
+	return b, err
+}
+
+// Import 将Export导出的数据写入空数据库
+// 校验和在读完全部记录后验证，通过后才写入存储高度；失败(含取消)时删除已写入的记录，数据库恢复为空
+func (db *DB) Import(ctx context.Context, r io.Reader, progress ExportProgress) (*model.ExportInfo, error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 新建的数据库只有存储格式版本记录
+	for _, store := range db.stores() {
+		it, err := store.Iterator(nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		for ; it.Valid(); it.Next() {
+			if string(it.Key()) != schemaVersionKey {
+				it.Close()
+				return nil, ErrNotEmpty
+			}
+		}
+		it.Close()
+	}
+	schema, err := db.udb.Get([]byte(schemaVersionKey))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := db.importRecords(ctx, r, progress)
+	if err != nil {
+		if werr := db.wipeImport(schema); werr != nil {
+			return nil, fmt.Errorf("%w (removing imported records failed: %v, clear the data directory before retrying)", err, werr)
+		}
+		return nil, fmt.Errorf("%w (imported records removed)", err)
+	}
+	return info, nil
+}
+
+// wipeImport 删除导入失败时已写入的全部记录，恢复新建数据库的存储格式版本记录
+func (db *DB) wipeImport(schema []byte) error {
+	for _, store := range db.stores() {
+		if err := db.deletePrefix(store, nil); err != nil {
+			return err
+		}
+	}
+	if schema == nil {
+		return nil
+	}
+	return db.udb.SetSync([]byte(schemaVersionKey), schema)
+}
+
+// importRecords 调用方需持有写锁
+func (db *DB) importRecords(ctx context.Context, r io.Reader, progress ExportProgress) (*model.ExportInfo, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read export header: %w", err)
+	}
+	defer gz.Close()
+	hr := &hashReader{r: bufio.NewReaderSize(gz, 1<<20), h: sha256.New()}
+
+	hdr := make([]byte, len(exportMagic)+18)
+	if _, err := io.ReadFull(hr, hdr); err != nil {
+		return nil, fmt.Errorf("read export header: %w", err)
+	}
+	if !bytes.Equal(hdr[:len(exportMagic)], exportMagic) {
+		return nil, errors.New("not an indexer export file")
+	}
+	hdr = hdr[len(exportMagic):]
+	info := &model.ExportInfo{
+		Version:     int(binary.LittleEndian.Uint16(hdr)),
+		StoreHeight: int64(binary.LittleEndian.Uint64(hdr[2:])),
+		CreatedAt:   int64(binary.LittleEndian.Uint64(hdr[10:])),
+	}
+	if info.Version != ExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", info.Version)
+	}
+
+	var hasSchema bool
+	stores := db.exportStores()
+	batches := make([]tmdb.Batch, len(stores))
+	pending := 0
+	flush := func() error {
+		for i, wb := range batches {
+			if wb == nil {
+				continue
+			}
+			err := wb.Write()
+			wb.Close()
+			batches[i] = nil
+			if err != nil {
+				return err
+			}
+		}
+		pending = 0
+		return nil
+	}
+	defer func() {
+		for _, wb := range batches {
+			if wb != nil {
+				wb.Close()
+			}
+		}
+	}()
+
+	for {
+		id, err := hr.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if id == 0 {
+			break
+		}
+		if int(id) >= len(stores) {
+			return nil, fmt.Errorf("invalid store id %d", id)
+		}
+		key, err := readBytes(hr)
+		if err != nil {
+			return nil, err
+		}
+		val, err := readBytes(hr)
+		if err != nil {
+			return nil, err
+		}
+		if id == 1 && string(key) == schemaVersionKey {
+			if pkg.BytesToInt64(val) > SchemaVersion {
+				return nil, fmt.Errorf("%w %d in export file", ErrUnknownSchema, pkg.BytesToInt64(val))
+			}
+			hasSchema = true
+		}
+		if batches[id] == nil {
+			batches[id] = stores[id].NewBatch()
+		}
+		if err := batches[id].Set(key, val); err != nil {
+			return nil, err
+		}
+		info.Records++
+		if pending++; pending >= importBatchSize {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			if progress != nil {
+				progress(info.Records)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	var count uint64
+	if err := binary.Read(hr, binary.LittleEndian, &count); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if count != uint64(info.Records) {
+		return nil, fmt.Errorf("record count mismatch: header %d read %d", count, info.Records)
+	}
+	expected := hr.h.Sum(nil)
+	checksum := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hr.r, checksum); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if !bytes.Equal(expected, checksum) {
+		return nil, errors.New("export checksum mismatch")
+	}
+	// 读到结尾以校验gzip的crc
+	if n, err := io.Copy(io.Discard, hr.r); err != nil || n > 0 {
+		return nil, fmt.Errorf("trailing data after export: %d bytes %v", n, err)
+	}
+	info.Checksum = fmt.Sprintf("%x", checksum)
+
+	// 引入版本记录之前导出的文件为版本1，旧版本数据导入后执行迁移
+	if !hasSchema {
+		if err := db.udb.SetSync([]byte(schemaVersionKey), pkg.Int64ToBytes(1)); err != nil {
+			return nil, err
+		}
+	}
+	if err := db.storeLastHeight(info.StoreHeight); err != nil {
+		return nil, err
+	}
+	if err := db.checkSchema(); err != nil {
+		return nil, err
+	}
+	if progress != nil {
+		progress(info.Records)
+	}
+	return info, nil
+}
+
+func readBytes(r *hashReader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > 1<<30 {
+		return nil, fmt.Errorf("record too large: %d bytes", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return b, nil
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
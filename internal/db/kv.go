@@ -0,0 +1,320 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/google/btree"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+)
+
+// MemDBBackend 内存存储 用于测试，进程退出后数据丢失
+const MemDBBackend = string(tmdb.MemDBBackend)
+
+var (
+	errKeyEmpty    = errors.New("key cannot be empty")
+	errValueNil    = errors.New("value cannot be nil")
+	errBatchClosed = errors.New("batch has been written or closed")
+)
+
+// KV 索引使用的键值存储 是cosmos-db DB接口的子集，各后端可直接使用
+type KV interface {
+	// Get key不存在时返回nil
+	Get(key []byte) ([]byte, error)
+	Has(key []byte) (bool, error)
+	SetSync(key, value []byte) error
+	DeleteSync(key []byte) error
+	// Iterator 按key升序遍历[start, end)，nil表示不限
+	Iterator(start, end []byte) (tmdb.Iterator, error)
+	NewBatch() tmdb.Batch
+	Stats() map[string]string
+	Close() error
+}
+
+// kvOpener 使用调优参数打开存储 pebbledb、rocksdb需对应的编译标签才会注册
+type kvOpener func(name, dir string, o *config.BackendOptions, readOnly bool) (KV, error)
+
+var kvOpeners = map[tmdb.BackendType]kvOpener{
+	tmdb.GoLevelDBBackend: openGoLevelDB,
+}
+
+// openKV memdb使用内存实现，goleveldb支持以只读方式打开，其他存储类型只读模式由DB拒绝写入
+// store_dirs指定的存储放在单独的目录，可以分布在不同磁盘
+func openKV(name string, conf *config.DBConfig) (KV, error) {
+	if conf.DBType == MemDBBackend {
+		return newMemKV(), nil
+	}
+	dir := storeDir(name, conf)
+	backend := tmdb.BackendType(conf.DBType)
+	if open, ok := kvOpeners[backend]; ok {
+		return open(name, dir, conf.Options[conf.DBType], conf.ReadOnly)
+	}
+	if conf.Options[conf.DBType] != nil {
+		return nil, fmt.Errorf("db_type %s does not support options", conf.DBType)
+	}
+	return tmdb.NewDB(name, backend, dir)
+}
+
+// storeDir 存储所在目录 数据位于<dir>/<name>.db
+func storeDir(name string, conf *config.DBConfig) string {
+	if d := conf.StoreDirs[name]; len(d) > 0 {
+		return d
+	}
+	return conf.Dir
+}
+
+// checkStoreDirs store_dirs只能指定已有的存储
+func checkStoreDirs(conf *config.DBConfig) error {
+	for name := range conf.StoreDirs {
+		switch name {
+		case udbName, bdbName, audbName:
+		default:
+			return fmt.Errorf("unknown store %q in store_dirs, expected %s, %s or %s", name, udbName, bdbName, audbName)
+		}
+	}
+	return nil
+}
+
+func unsupportedCompression(backend tmdb.BackendType, compression string) error {
+	return fmt.Errorf("compression %q is not supported by %s", compression, backend)
+}
+
+func openGoLevelDB(name, dir string, o *config.BackendOptions, readOnly bool) (KV, error) {
+	lo := &opt.Options{ReadOnly: readOnly}
+	if o != nil {
+		lo.BlockCacheCapacity = o.BlockCacheMB * opt.MiB
+		lo.WriteBuffer = o.WriteBufferMB * opt.MiB
+		lo.OpenFilesCacheCapacity = o.MaxOpenFiles
+		if o.BloomBits > 0 {
+			lo.Filter = filter.NewBloomFilter(o.BloomBits)
+		}
+		switch o.Compression {
+		case "":
+		case "none":
+			lo.Compression = opt.NoCompression
+		case "snappy":
+			lo.Compression = opt.SnappyCompression
+		default:
+			return nil, unsupportedCompression(tmdb.GoLevelDBBackend, o.Compression)
+		}
+	}
+	return tmdb.NewGoLevelDBWithOpts(name, dir, lo)
+}
+
+type memItem struct {
+	key   []byte
+	value []byte
+}
+
+func memItemLess(a, b memItem) bool {
+	return bytes.Compare(a.key, b.key) < 0
+}
+
+// memKV 基于btree的内存存储
+// 迭代器创建时复制范围内的数据，遍历期间可以读写同一存储(cosmos-db memdb遍历期间持有锁)
+type memKV struct {
+	mu   sync.RWMutex
+	tree *btree.BTreeG[memItem]
+}
+
+func newMemKV() *memKV {
+	return &memKV{tree: btree.NewG(32, memItemLess)}
+}
+
+func (m *memKV) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	item, ok := m.tree.Get(memItem{key: key})
+	if !ok {
+		return nil, nil
+	}
+	return item.value, nil
+}
+
+func (m *memKV) Has(key []byte) (bool, error) {
+	val, err := m.Get(key)
+	return val != nil, err
+}
+
+func (m *memKV) SetSync(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, value)
+	return nil
+}
+
+func (m *memKV) DeleteSync(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tree.Delete(memItem{key: key})
+	return nil
+}
+
+// set 复制key及value 调用方之后修改切片不影响存储
+func (m *memKV) set(key, value []byte) {
+	m.tree.ReplaceOrInsert(memItem{
+		key:   append([]byte{}, key...),
+		value: append([]byte{}, value...),
+	})
+}
+
+func (m *memKV) Iterator(start, end []byte) (tmdb.Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	it := &memIterator{start: start, end: end}
+	visit := func(item memItem) bool {
+		if end != nil && bytes.Compare(item.key, end) >= 0 {
+			return false
+		}
+		it.items = append(it.items, item)
+		return true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if start == nil {
+		m.tree.Ascend(visit)
+	} else {
+		m.tree.AscendGreaterOrEqual(memItem{key: start}, visit)
+	}
+	return it, nil
+}
+
+func (m *memKV) NewBatch() tmdb.Batch {
+	return &memBatch{kv: m}
+}
+
+func (m *memKV) Stats() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return map[string]string{
+		"database.type": "memKV",
+		"database.size": fmt.Sprintf("%d", m.tree.Len()),
+	}
+}
+
+func (m *memKV) Close() error {
+	return nil
+}
+
+type memIterator struct {
+	start, end []byte
+	items      []memItem
+	pos        int
+}
+
+func (it *memIterator) Domain() ([]byte, []byte) { return it.start, it.end }
+func (it *memIterator) Valid() bool              { return it.pos < len(it.items) }
+func (it *memIterator) Error() error             { return nil }
+func (it *memIterator) Close() error             { it.items = nil; return nil }
+
+func (it *memIterator) Next() {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+	it.pos++
+}
+
+func (it *memIterator) Key() []byte {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+	return it.items[it.pos].key
+}
+
+func (it *memIterator) Value() []byte {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+	return it.items[it.pos].value
+}
+
+type memOp struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// memBatch 写入时持有写锁一次性应用全部操作
+type memBatch struct {
+	kv   *memKV
+	ops  []memOp
+	size int
+}
+
+func (b *memBatch) Set(key, value []byte) error {
+	if b.kv == nil {
+		return errBatchClosed
+	}
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	b.ops = append(b.ops, memOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+	b.size += len(key) + len(value)
+	return nil
+}
+
+func (b *memBatch) Delete(key []byte) error {
+	if b.kv == nil {
+		return errBatchClosed
+	}
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	b.ops = append(b.ops, memOp{key: append([]byte{}, key...), delete: true})
+	b.size += len(key)
+	return nil
+}
+
+func (b *memBatch) Write() error {
+	if b.kv == nil {
+		return errBatchClosed
+	}
+	b.kv.mu.Lock()
+	for _, op := range b.ops {
+		if op.delete {
+			b.kv.tree.Delete(memItem{key: op.key})
+		} else {
+			b.kv.tree.ReplaceOrInsert(memItem{key: op.key, value: op.value})
+		}
+	}
+	b.kv.mu.Unlock()
+	return b.Close()
+}
+
+func (b *memBatch) WriteSync() error {
+	return b.Write()
+}
+
+func (b *memBatch) Close() error {
+	b.kv, b.ops = nil, nil
+	return nil
+}
+
+func (b *memBatch) GetByteSize() (int, error) {
+	if b.kv == nil {
+		return 0, errBatchClosed
+	}
+	return b.size, nil
+}
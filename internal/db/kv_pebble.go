@@ -0,0 +1,228 @@
+//go:build pebbledb
+
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+
+	"github.com/cockroachdb/pebble"
+	"github.com/cockroachdb/pebble/bloom"
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+)
+
+func init() {
+	kvOpeners[tmdb.PebbleDBBackend] = openPebble
+}
+
+// pebbleKV cosmos-db的PebbleDB只支持maxopenfiles参数 这里直接打开pebble以使用全部调优参数
+// 数据目录与cosmos-db一致(<name>.db)，已有数据可以直接打开
+type pebbleKV struct {
+	db *pebble.DB
+}
+
+func openPebble(name, dir string, o *config.BackendOptions, _ bool) (KV, error) {
+	do := &pebble.Options{
+		MaxConcurrentCompactions: func() int { return 3 },
+	}
+	if o != nil {
+		if o.BlockCacheMB > 0 {
+			c := pebble.NewCache(int64(o.BlockCacheMB) << 20)
+			defer c.Unref()
+			do.Cache = c
+		}
+		do.MemTableSize = o.WriteBufferMB << 20
+		do.MaxOpenFiles = o.MaxOpenFiles
+		lo := pebble.LevelOptions{}
+		switch o.Compression {
+		case "":
+		case "none":
+			lo.Compression = pebble.NoCompression
+		case "snappy":
+			lo.Compression = pebble.SnappyCompression
+		case "zstd":
+			lo.Compression = pebble.ZstdCompression
+		default:
+			return nil, unsupportedCompression(tmdb.PebbleDBBackend, o.Compression)
+		}
+		if o.BloomBits > 0 {
+			lo.FilterPolicy = bloom.FilterPolicy(o.BloomBits)
+		}
+		do.Levels = []pebble.LevelOptions{lo}
+	}
+	do.EnsureDefaults()
+
+	p, err := pebble.Open(filepath.Join(dir, name+".db"), do)
+	if err != nil {
+		return nil, err
+	}
+	return &pebbleKV{db: p}, nil
+}
+
+func (p *pebbleKV) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errKeyEmpty
+	}
+	val, closer, err := p.db.Get(key)
+	if err == pebble.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte{}, val...), nil
+}
+
+func (p *pebbleKV) Has(key []byte) (bool, error) {
+	val, err := p.Get(key)
+	return val != nil, err
+}
+
+func (p *pebbleKV) SetSync(key, value []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return p.db.Set(key, value, pebble.Sync)
+}
+
+func (p *pebbleKV) DeleteSync(key []byte) error {
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	return p.db.Delete(key, pebble.Sync)
+}
+
+func (p *pebbleKV) Iterator(start, end []byte) (tmdb.Iterator, error) {
+	if (start != nil && len(start) == 0) || (end != nil && len(end) == 0) {
+		return nil, errKeyEmpty
+	}
+	source := p.db.NewIter(&pebble.IterOptions{LowerBound: start, UpperBound: end})
+	source.First()
+	return &pebbleIterator{source: source, start: start, end: end}, nil
+}
+
+func (p *pebbleKV) NewBatch() tmdb.Batch {
+	return &pebbleBatch{batch: p.db.NewBatch()}
+}
+
+func (p *pebbleKV) Stats() map[string]string {
+	m := p.db.Metrics()
+	return map[string]string{
+		"database.type":    string(tmdb.PebbleDBBackend),
+		"database.disk":    fmt.Sprintf("%d", m.DiskSpaceUsage()),
+		"database.metrics": m.String(),
+	}
+}
+
+// ForceCompact 压缩[start, limit)范围 nil表示全部
+func (p *pebbleKV) ForceCompact(start, limit []byte) error {
+	if start == nil {
+		start = []byte{0}
+	}
+	if limit == nil {
+		limit = bytes.Repeat([]byte{0xff}, 64)
+	}
+	return p.db.Compact(start, limit, true)
+}
+
+func (p *pebbleKV) Close() error {
+	return p.db.Close()
+}
+
+type pebbleIterator struct {
+	source     *pebble.Iterator
+	start, end []byte
+}
+
+func (it *pebbleIterator) Domain() ([]byte, []byte) { return it.start, it.end }
+func (it *pebbleIterator) Valid() bool              { return it.source.Valid() }
+func (it *pebbleIterator) Error() error             { return it.source.Error() }
+func (it *pebbleIterator) Close() error             { return it.source.Close() }
+
+func (it *pebbleIterator) Next() {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+	it.source.Next()
+}
+
+func (it *pebbleIterator) Key() []byte {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+	return append([]byte{}, it.source.Key()...)
+}
+
+func (it *pebbleIterator) Value() []byte {
+	if !it.Valid() {
+		panic("iterator is invalid")
+	}
+	return append([]byte{}, it.source.Value()...)
+}
+
+type pebbleBatch struct {
+	batch *pebble.Batch
+}
+
+func (b *pebbleBatch) Set(key, value []byte) error {
+	if b.batch == nil {
+		return errBatchClosed
+	}
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	if value == nil {
+		return errValueNil
+	}
+	return b.batch.Set(key, value, nil)
+}
+
+func (b *pebbleBatch) Delete(key []byte) error {
+	if b.batch == nil {
+		return errBatchClosed
+	}
+	if len(key) == 0 {
+		return errKeyEmpty
+	}
+	return b.batch.Delete(key, nil)
+}
+
+func (b *pebbleBatch) Write() error {
+	return b.commit(pebble.NoSync)
+}
+
+func (b *pebbleBatch) WriteSync() error {
+	return b.commit(pebble.Sync)
+}
+
+func (b *pebbleBatch) commit(opts *pebble.WriteOptions) error {
+	if b.batch == nil {
+		return errBatchClosed
+	}
+	if err := b.batch.Commit(opts); err != nil {
+		return err
+	}
+	return b.Close()
+}
+
+func (b *pebbleBatch) Close() error {
+	if b.batch == nil {
+		return nil
+	}
+	err := b.batch.Close()
+	b.batch = nil
+	return err
+}
+
+func (b *pebbleBatch) GetByteSize() (int, error) {
+	if b.batch == nil {
+		return 0, errBatchClosed
+	}
+	return b.batch.Len(), nil
+}
@@ -0,0 +1,61 @@
+//go:build rocksdb
+
+package db
+
+import (
+	"runtime"
+
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/linxGnu/grocksdb"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+)
+
+func init() {
+	kvOpeners[tmdb.RocksDBBackend] = openRocksDB
+}
+
+// openRocksDB 未配置的参数使用cosmos-db的默认值(1GB块缓存、10位布隆过滤器、4096个文件、512MB写缓冲)
+func openRocksDB(name, dir string, o *config.BackendOptions, _ bool) (KV, error) {
+	if o == nil {
+		o = &config.BackendOptions{}
+	}
+	var compression grocksdb.CompressionType
+	switch o.Compression {
+	case "", "snappy":
+		compression = grocksdb.SnappyCompression
+	case "none":
+		compression = grocksdb.NoCompression
+	case "lz4":
+		compression = grocksdb.LZ4Compression
+	case "zstd":
+		compression = grocksdb.ZSTDCompression
+	default:
+		return nil, unsupportedCompression(tmdb.RocksDBBackend, o.Compression)
+	}
+
+	bbto := grocksdb.NewDefaultBlockBasedTableOptions()
+	cacheMB, bloomBits := 1024, 10
+	if o.BlockCacheMB > 0 {
+		cacheMB = o.BlockCacheMB
+	}
+	if o.BloomBits > 0 {
+		bloomBits = o.BloomBits
+	}
+	bbto.SetBlockCache(grocksdb.NewLRUCache(uint64(cacheMB) << 20))
+	bbto.SetFilterPolicy(grocksdb.NewBloomFilter(float64(bloomBits)))
+
+	ro := grocksdb.NewDefaultOptions()
+	ro.SetBlockBasedTableFactory(bbto)
+	ro.SetCreateIfMissing(true)
+	ro.IncreaseParallelism(runtime.NumCPU())
+	ro.OptimizeLevelStyleCompaction(512 << 20)
+	ro.SetMaxOpenFiles(4096)
+	if o.MaxOpenFiles > 0 {
+		ro.SetMaxOpenFiles(o.MaxOpenFiles)
+	}
+	if o.WriteBufferMB > 0 {
+		ro.SetWriteBufferSize(uint64(o.WriteBufferMB) << 20)
+	}
+	ro.SetCompression(compression)
+	return tmdb.NewRocksDBWithOptions(name, dir, ro)
+}
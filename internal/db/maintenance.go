@@ -0,0 +1,506 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/scylladb/go-set/strset"
+	"github.com/shopspring/decimal"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"google.golang.org/protobuf/proto"
+)
+
+// deleteBatchSize 按前缀删除时每批删除的key数量
+const deleteBatchSize = 10000
+
+// rollbackKey 进行中的回滚的目标高度 全部完成后与存储高度同一批次删除
+const rollbackKey = "s:rollback"
+
+// ErrRollbackPending 上次回滚未完成
+var ErrRollbackPending = errors.New("interrupted rollback must be finished first")
+
+// ErrCompactUnsupported 存储类型不支持手动压缩
+var ErrCompactUnsupported = errors.New("compaction is not supported by this backend")
+
+// Rollback 将索引回滚到指定高度：删除之后区块产生的utxo，恢复之后区块花费的utxo
+// 需遍历全部utxo记录，按deleteBatchSize条分批处理；未记录花费高度的旧数据无法判断，计入UnknownSpendHeight
+// 开始前写入回滚标记，全部完成后存储高度与清除标记在同一批次写入；中断后标记保留，
+// 重新打开数据库时可通过PendingRollback检测，需再次回滚到不高于标记的高度并执行verify --repair修正余额
+func (db *DB) Rollback(to int64) (*model.RollbackResult, error) {
+	if db.readOnly {
+		return nil, ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.flush(); err != nil {
+		return nil, err
+	}
+	sheight, err := db.GetStoreHeight()
+	if err != nil {
+		return nil, err
+	}
+	if to < 0 || to >= sheight {
+		return nil, fmt.Errorf("rollback height %d must be in [0, %d)", to, sheight)
+	}
+	pruned, err := db.GetPrunedHeight()
+	if err != nil {
+		return nil, err
+	}
+	if to < pruned {
+		return nil, fmt.Errorf("%w: rollback height %d, pruned up to %d", ErrPruned, to, pruned)
+	}
+	pending, ok, err := db.pendingRollback()
+	if err != nil {
+		return nil, err
+	}
+	if ok && to > pending {
+		return nil, fmt.Errorf("%w: rollback height %d above interrupted rollback to %d", ErrRollbackPending, to, pending)
+	}
+	if err := db.udb.SetSync([]byte(rollbackKey), pkg.Int64ToBytes(to)); err != nil {
+		return nil, err
+	}
+
+	res := &model.RollbackResult{From: sheight, To: to}
+	// 已处理的记录被删除或改为未花费，不再满足条件，每批从上一批最后的key之后继续
+	start, end := []byte(utxoKeyPrefix), prefixEnd([]byte(utxoKeyPrefix))
+	for {
+		next, err := db.rollbackChunk(start, end, to, res)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			break
+		}
+		start = next
+	}
+	if err := db.deleteSpendIndex(to + 1); err != nil {
+		return nil, err
+	}
+
+	wb := db.udb.NewBatch()
+	defer wb.Close()
+	if err := wb.Set([]byte(StoreHeight), pkg.Int64ToBytes(to)); err != nil {
+		return nil, err
+	}
+	if err := wb.Delete([]byte(rollbackKey)); err != nil {
+		return nil, err
+	}
+	if err := wb.WriteSync(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// rollbackChunk 处理[start, end)内最多deleteBatchSize条utxo记录 返回下一批的起始key，没有更多记录时返回nil
+// 先写入地址余额及utxo集合，再在同一批次写入utxo记录及集合哈希；两步之间中断时重新回滚会重复计算该批余额，由verify --repair修正
+func (db *DB) rollbackChunk(start, end []byte, to int64, res *model.RollbackResult) ([]byte, error) {
+	deletes := make([][]byte, 0, deleteBatchSize)
+	restore := make(map[string]*UtxoInfo)
+	abm := make(map[string]decimal.Decimal)
+	aaum := make(map[string]*strset.Set)
+	adum := make(map[string]*strset.Set)
+	am := make(map[string]struct{})
+	delta := &utxoSetDelta{}
+
+	it, err := db.udb.Iterator(start, end)
+	if err != nil {
+		return nil, err
+	}
+	var n int
+	var last []byte
+	for ; it.Valid() && n < deleteBatchSize; it.Next() {
+		n++
+		last = append(last[:0], it.Key()...)
+		info := &UtxoInfo{}
+		if err = proto.Unmarshal(it.Value(), info); err != nil {
+			break
+		}
+		ukey := string(it.Key())
+		switch {
+		case info.Height > to:
+			deletes = append(deletes, []byte(ukey))
+			if info.Spend == nil {
+				delta.removed = append(delta.removed, coin{ukey, info})
+			}
+			if info.Spend == nil && len(info.Address) > 0 {
+				addAddressUtxo(adum, info.Address, ukey)
+				updateBalance(abm, info.Address, -info.Value)
+				am[info.Address] = struct{}{}
+			}
+		case info.Spend != nil && info.Spend.Height > to:
+			if info.Height == 0 && len(info.Address) == 0 {
+				//未找到原记录的花费记录
+				continue
+			}
+			info.Spend = nil
+			restore[ukey] = info
+			delta.added = append(delta.added, coin{ukey, info})
+			if len(info.Address) == 0 {
+				continue
+			}
+			addAddressUtxo(aaum, info.Address, ukey)
+			updateBalance(abm, info.Address, info.Value)
+			am[info.Address] = struct{}{}
+		case info.Spend != nil && info.Spend.Height == 0:
+			res.UnknownSpendHeight++
+		}
+	}
+	if err == nil {
+		err = it.Error()
+	}
+	it.Close()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+
+	if err := db.mergeAddressState(am, abm, aaum, adum); err != nil {
+		return nil, err
+	}
+	if err := db.batchStore(nil, abm, aaum, nil); err != nil {
+		return nil, err
+	}
+	st, err := db.applyUTXOSetDelta(delta, to)
+	if err != nil {
+		return nil, err
+	}
+
+	wb := db.udb.NewBatch()
+	defer wb.Close()
+	for key, info := range restore {
+		b, err := proto.Marshal(info)
+		if err != nil {
+			return nil, err
+		}
+		if err := wb.Set([]byte(key), b); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range deletes {
+		if err := wb.Delete(key); err != nil {
+			return nil, err
+		}
+	}
+	if st != nil {
+		if err := wb.Set([]byte(utxoSetKey), st); err != nil {
+			return nil, err
+		}
+	}
+	if err := wb.WriteSync(); err != nil {
+		return nil, err
+	}
+	res.Deleted += len(deletes)
+	res.Restored += len(restore)
+	return append(last, 0), nil
+}
+
+// pendingRollback 未完成的回滚的目标高度
+func (db *DB) pendingRollback() (int64, bool, error) {
+	val, err := db.udb.Get([]byte(rollbackKey))
+	if err != nil || len(val) == 0 {
+		return 0, false, err
+	}
+	return pkg.BytesToInt64(val), true, nil
+}
+
+// PendingRollback 上次回滚中断时返回其目标高度 此时余额及utxo记录处于回滚一半的状态，不能继续同步
+func (db *DB) PendingRollback() (int64, bool, error) {
+	return db.pendingRollback()
+}
+
+// Reset 清空索引数据(保留api key) 用于从创世区块重建
+func (db *DB) Reset() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.cache.reset()
+	if err := db.deletePrefix(db.udb, []byte(utxoKeyPrefix)); err != nil {
+		return err
+	}
+	if err := db.deletePrefix(db.bdb, []byte(addressBalanceKeyPrefix)); err != nil {
+		return err
+	}
+	if err := db.deletePrefix(db.audb, []byte(addressUtxoKeyPrefix)); err != nil {
+		return err
+	}
+	if err := db.deletePrefix(db.udb, []byte(spendIndexPrefix)); err != nil {
+		return err
+	}
+	for _, key := range []string{utxoSetKey, prunedHeightKey, noHeightKey} {
+		if err := db.udb.DeleteSync([]byte(key)); err != nil {
+			return err
+		}
+	}
+	return db.udb.DeleteSync([]byte(StoreHeight))
+}
+
+// Stats 统计各类记录数量 需遍历全部数据；top>0时同时统计utxo数量最多的top个地址
+// 遍历期间不持有锁，索引运行时统计的是各存储遍历时的快照
+func (db *DB) Stats(top int) (*model.DBStats, error) {
+	sheight, err := db.GetStoreHeight()
+	if err != nil {
+		return nil, err
+	}
+	schema, err := db.GetSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	pruned, err := db.GetPrunedHeight()
+	if err != nil {
+		return nil, err
+	}
+	noHeight, err := db.GetNoHeightCount()
+	if err != nil {
+		return nil, err
+	}
+	stats := &model.DBStats{
+		StoreHeight:  sheight,
+		Schema:       schema,
+		Mode:         ModeArchive,
+		PrunedHeight: pruned,
+		NoHeight:     noHeight,
+		Keys:         make(map[string]map[string]int64, 3),
+		Backend:      make(map[string]map[string]string, 3),
+	}
+	if db.pruneDepth > 0 {
+		stats.Mode = ModePrune
+	}
+
+	var unspent decimal.Decimal
+	scans := map[string]func(key, val []byte) error{
+		utxoKeyPrefix: func(key, val []byte) error {
+			info := &UtxoInfo{}
+			if err := proto.Unmarshal(val, info); err != nil {
+				return err
+			}
+			if info.Spend != nil {
+				stats.Spent++
+			} else {
+				stats.Unspent++
+				unspent = unspent.Add(decimal.NewFromFloat(info.Value))
+			}
+			return nil
+		},
+	}
+	if top > 0 {
+		scans[addressUtxoKeyPrefix] = func(key, val []byte) error {
+			set := &OutpointSet{}
+			if err := proto.Unmarshal(val, set); err != nil {
+				return err
+			}
+			stats.TopAddresses = addTopAddress(stats.TopAddresses, top, model.AddressUtxoCount{
+				Address: string(key[len(addressUtxoKeyPrefix):]),
+				Utxos:   int64(len(set.Members)),
+			})
+			return nil
+		}
+	}
+	for _, name := range storeNames {
+		keys := make(map[string]int64)
+		if err := db.iteratePrefix(db.stores()[name], nil, func(key, val []byte) error {
+			pre := keyPrefix(key)
+			keys[pre]++
+			if fn, ok := scans[pre]; ok {
+				return fn(key, val)
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		stats.Keys[name] = keys
+	}
+	stats.UnspentValue = unspent.StringFixed(8)
+	stats.Balances = stats.Keys[bdbName][addressBalanceKeyPrefix]
+	stats.Addresses = stats.Keys[audbName][addressUtxoKeyPrefix]
+	stats.APIKeys = stats.Keys[udbName][apiKeyPrefix]
+
+	if stats.DiskSize, err = db.DiskSize(); err != nil {
+		return nil, err
+	}
+	for name, store := range db.stores() {
+		stats.Backend[name] = store.Stats()
+	}
+	return stats, nil
+}
+
+// keyPrefix key中第一个':'及之前的部分 如u:、ab:、s:
+func keyPrefix(key []byte) string {
+	if i := bytes.IndexByte(key, ':'); i >= 0 {
+		return string(key[:i+1])
+	}
+	return "other"
+}
+
+// addTopAddress 按utxo数量降序保留前top个地址
+func addTopAddress(list []model.AddressUtxoCount, top int, a model.AddressUtxoCount) []model.AddressUtxoCount {
+	if len(list) == top && list[top-1].Utxos >= a.Utxos {
+		return list
+	}
+	i := sort.Search(len(list), func(i int) bool { return list[i].Utxos < a.Utxos })
+	if len(list) < top {
+		list = append(list, model.AddressUtxoCount{})
+	}
+	copy(list[i+1:], list[i:])
+	list[i] = a
+	return list
+}
+
+// DiskSize 各存储数据目录占用的字节数 memdb返回空
+func (db *DB) DiskSize() (map[string]int64, error) {
+	sizes := make(map[string]int64, len(db.dirs))
+	for name, dir := range db.dirs {
+		var size int64
+		err := filepath.WalkDir(filepath.Join(dir, name+".db"), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				// 压缩期间文件可能被删除
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sizes[name] = size
+	}
+	return sizes, nil
+}
+
+// Compact 手动压缩全部存储 goleveldb及pebbledb支持，压缩期间可以正常读写
+func (db *DB) Compact() error {
+	for _, name := range storeNames {
+		if err := db.CompactStore(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type compacter interface {
+	ForceCompact(start, limit []byte) error
+}
+
+// CanCompact 只读打开或存储类型不支持时返回错误
+func (db *DB) CanCompact() error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	for _, store := range db.stores() {
+		if _, ok := store.(compacter); !ok {
+			return ErrCompactUnsupported
+		}
+	}
+	return nil
+}
+
+// CompactStore 压缩指定存储
+func (db *DB) CompactStore(name string) error {
+	if err := db.CanCompact(); err != nil {
+		return err
+	}
+	store, ok := db.stores()[name]
+	if !ok {
+		return fmt.Errorf("unknown store %s", name)
+	}
+	if err := store.(compacter).ForceCompact(nil, nil); err != nil {
+		return fmt.Errorf("compact %s: %w", name, err)
+	}
+	return nil
+}
+
+// storeNames 存储名称 按固定顺序遍历
+var storeNames = []string{udbName, bdbName, audbName}
+
+// StoreNames 存储名称 utxo、balance、address_utxo
+func StoreNames() []string {
+	return append([]string{}, storeNames...)
+}
+
+func (db *DB) stores() map[string]KV {
+	return map[string]KV{
+		udbName:  db.udb,
+		bdbName:  db.bdb,
+		audbName: db.audb,
+	}
+}
+
+// iteratePrefix 遍历前缀下的全部key 回调中不可读写同一存储(memdb迭代期间持有锁)
+func (db *DB) iteratePrefix(store KV, prefix []byte, fn func(key, val []byte) error) error {
+	it, err := store.Iterator(prefix, prefixEnd(prefix))
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for ; it.Valid(); it.Next() {
+		if err := fn(it.Key(), it.Value()); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// deletePrefix 分批删除前缀下的全部key
+func (db *DB) deletePrefix(store KV, prefix []byte) error {
+	for {
+		keys := make([][]byte, 0, deleteBatchSize)
+		it, err := store.Iterator(prefix, prefixEnd(prefix))
+		if err != nil {
+			return err
+		}
+		for ; it.Valid() && len(keys) < deleteBatchSize; it.Next() {
+			keys = append(keys, append([]byte{}, it.Key()...))
+		}
+		err = it.Error()
+		it.Close()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := deleteKeys(store, keys); err != nil {
+			return err
+		}
+	}
+}
+
+func deleteKeys(store KV, keys [][]byte) error {
+	for start := 0; start < len(keys); start += deleteBatchSize {
+		end := start + deleteBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		wb := store.NewBatch()
+		for _, key := range keys[start:end] {
+			if err := wb.Delete(key); err != nil {
+				wb.Close()
+				return err
+			}
+		}
+		err := wb.WriteSync()
+		wb.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"google.golang.org/protobuf/proto"
+)
+
+// UtxoKey utxo记录的key u: + 二进制outpoint(pkg.EncodeOutpoint)，即model.In/Out的UKey
+func UtxoKey(txid string, index uint32) (string, error) {
+	key, err := pkg.EncodeOutpoint([]byte(utxoKeyPrefix), txid, index)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// parseUKey 解析utxo记录的key
+func parseUKey(ukey string) (string, uint32, error) {
+	return pkg.DecodeOutpoint([]byte(utxoKeyPrefix), []byte(ukey))
+}
+
+// formatUKey 可读形式txid:index 用于日志及校验报告
+func formatUKey(ukey string) string {
+	txid, index, err := parseUKey(ukey)
+	if err != nil {
+		return fmt.Sprintf("%x", ukey)
+	}
+	return fmt.Sprintf("%s:%d", txid, index)
+}
+
+// unmarshalMembers 地址utxo集合 成员不含u:前缀，返回完整的utxo key
+func unmarshalMembers(b []byte) ([]string, error) {
+	set := &OutpointSet{}
+	if err := proto.Unmarshal(b, set); err != nil {
+		return nil, err
+	}
+	members := make([]string, len(set.Members))
+	for i, m := range set.Members {
+		members[i] = utxoKeyPrefix + string(m)
+	}
+	return members, nil
+}
+
+func marshalMembers(members []string) ([]byte, error) {
+	set := &OutpointSet{Members: make([][]byte, len(members))}
+	for i, ukey := range members {
+		set.Members[i] = []byte(ukey[len(utxoKeyPrefix):])
+	}
+	return proto.Marshal(set)
+}
@@ -0,0 +1,231 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	ModeArchive = "archive"
+	ModePrune   = "prune"
+
+	// DefaultPruneDepth 与Bitcoin Core裁剪模式至少保留的区块数一致
+	DefaultPruneDepth = 288
+
+	spendIndexPrefix = "p:"       //p:<花费高度 8字节大端><ukey> prune模式下按花费高度查找已花费记录
+	prunedHeightKey  = "s:pruned" //已删除该高度及之前花费的记录
+)
+
+// ErrPruned 回滚需要的已花费记录已被删除
+var ErrPruned = errors.New("spent records below rollback height are pruned")
+
+// pruneDepth 解析存储模式 archive返回0
+func pruneDepth(conf *config.DBConfig) (int64, error) {
+	switch conf.Mode {
+	case "", ModeArchive:
+		return 0, nil
+	case ModePrune:
+		if conf.PruneDepth < 0 {
+			return 0, fmt.Errorf("invalid prune_depth %d", conf.PruneDepth)
+		}
+		if conf.PruneDepth == 0 {
+			return DefaultPruneDepth, nil
+		}
+		return conf.PruneDepth, nil
+	}
+	return 0, fmt.Errorf("unknown db mode %q", conf.Mode)
+}
+
+func spendIndexKey(height int64, ukey string) []byte {
+	key := make([]byte, 0, len(spendIndexPrefix)+8+len(ukey))
+	key = append(key, spendIndexPrefix...)
+	key = binary.BigEndian.AppendUint64(key, uint64(height))
+	return append(key, ukey...)
+}
+
+// GetPrunedHeight 已删除的已花费记录的最高花费高度 0表示未删除
+func (db *DB) GetPrunedHeight() (int64, error) {
+	val, err := db.udb.Get([]byte(prunedHeightKey))
+	if err != nil || len(val) == 0 {
+		return 0, err
+	}
+	return pkg.BytesToInt64(val), nil
+}
+
+// addSpendIndex prune模式下记录本批次已花费记录的花费高度 与utxo记录同一批次写入
+func (db *DB) addSpendIndex(um map[string]*UtxoInfo, meta map[string][]byte) {
+	if db.pruneDepth == 0 {
+		return
+	}
+	for key, info := range um {
+		if info.Spend != nil {
+			meta[string(spendIndexKey(info.Spend.Height, key))] = []byte{}
+		}
+	}
+}
+
+// prune 删除花费高度不超过height-pruneDepth的记录 按花费高度索引分批处理
+func (db *DB) prune(height int64) (int, error) {
+	target := height - db.pruneDepth
+	pruned, err := db.GetPrunedHeight()
+	if err != nil || db.pruneDepth == 0 || target <= pruned {
+		return 0, err
+	}
+
+	var deleted int
+	start, end := spendIndexKey(pruned+1, ""), spendIndexKey(target+1, "")
+	for {
+		keys := make([][]byte, 0, deleteBatchSize)
+		it, err := db.udb.Iterator(start, end)
+		if err != nil {
+			return deleted, err
+		}
+		for ; it.Valid() && len(keys) < deleteBatchSize; it.Next() {
+			keys = append(keys, append([]byte{}, it.Key()...))
+		}
+		err = it.Error()
+		it.Close()
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) == 0 {
+			break
+		}
+
+		wb := db.udb.NewBatch()
+		for _, key := range keys {
+			h := int64(binary.BigEndian.Uint64(key[len(spendIndexPrefix):]))
+			ukey := key[len(spendIndexPrefix)+8:]
+			// 只删除花费高度与索引一致的记录
+			info, err := db.getUtxoInfo(string(ukey))
+			if err != nil {
+				wb.Close()
+				return deleted, err
+			}
+			if info != nil && info.Spend != nil && info.Spend.Height == h {
+				if err := wb.Delete(ukey); err != nil {
+					wb.Close()
+					return deleted, err
+				}
+				deleted++
+			}
+			if err := wb.Delete(key); err != nil {
+				wb.Close()
+				return deleted, err
+			}
+		}
+		err = wb.WriteSync()
+		wb.Close()
+		if err != nil {
+			return deleted, err
+		}
+		start = append(keys[len(keys)-1], 0)
+	}
+	return deleted, db.udb.SetSync([]byte(prunedHeightKey), pkg.Int64ToBytes(target))
+}
+
+// PruneAll 遍历全部utxo记录，删除超过保留深度的已花费记录并为其余已花费记录建立花费高度索引
+// 用于archive模式切换到prune模式；未记录花费高度的旧数据无法回滚，一并删除
+func (db *DB) PruneAll() (int, error) {
+	if db.readOnly {
+		return 0, ErrReadOnly
+	}
+	if db.pruneDepth == 0 {
+		return 0, errors.New("db mode is not prune")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.flush(); err != nil {
+		return 0, err
+	}
+	sheight, err := db.GetStoreHeight()
+	if err != nil {
+		return 0, err
+	}
+	target := sheight - db.pruneDepth
+
+	// 每批读取deleteBatchSize条记录后关闭迭代器再写入，不在内存中累积全部已花费记录
+	var deleted int
+	start, end := []byte(utxoKeyPrefix), prefixEnd([]byte(utxoKeyPrefix))
+	for {
+		it, err := db.udb.Iterator(start, end)
+		if err != nil {
+			return deleted, err
+		}
+		wb := db.udb.NewBatch()
+		var n, batchDeleted int
+		var last []byte
+		for ; it.Valid() && n < deleteBatchSize; it.Next() {
+			n++
+			last = append(last[:0], it.Key()...)
+			info := &UtxoInfo{}
+			if err = proto.Unmarshal(it.Value(), info); err != nil {
+				break
+			}
+			switch {
+			case info.Spend == nil:
+			case info.Spend.Height <= target:
+				err = wb.Delete(append([]byte{}, it.Key()...))
+				batchDeleted++
+			default:
+				err = wb.Set(spendIndexKey(info.Spend.Height, string(it.Key())), []byte{})
+			}
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = it.Error()
+		}
+		it.Close()
+		if err == nil && n > 0 {
+			err = wb.WriteSync()
+		}
+		wb.Close()
+		if err != nil {
+			return deleted, err
+		}
+		if n == 0 {
+			break
+		}
+		deleted += batchDeleted
+		start = append(last, 0)
+	}
+	if target > 0 {
+		if err := db.udb.SetSync([]byte(prunedHeightKey), pkg.Int64ToBytes(target)); err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// deleteSpendIndex 删除花费高度不低于from的索引 回滚时使用
+func (db *DB) deleteSpendIndex(from int64) error {
+	start, end := spendIndexKey(from, ""), prefixEnd([]byte(spendIndexPrefix))
+	for {
+		keys := make([][]byte, 0, deleteBatchSize)
+		it, err := db.udb.Iterator(start, end)
+		if err != nil {
+			return err
+		}
+		for ; it.Valid() && len(keys) < deleteBatchSize; it.Next() {
+			keys = append(keys, append([]byte{}, it.Key()...))
+		}
+		err = it.Error()
+		it.Close()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := deleteKeys(db.udb, keys); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+const (
+	SortByValue = "value"
+	SortByAge   = "age" //按确认数 asc为最新的在前
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrUnknownHeight 地址下有升级前索引的utxo(height为0)，无法计算确认数
+// 索引从高度1开始，height为0即未记录，需要reindex --from 0重建
+var ErrUnknownHeight = fmt.Errorf("%w:utxo indexed without height, min_conf and sort_by=age need reindex", ErrInvalidQuery)
+
+// needHeight 过滤或排序条件是否依赖utxo的创建高度
+func needHeight(req *model.UTXORequest) bool {
+	return req.MinConf > 0 || req.SortBy == SortByAge
+}
+
+// utxoEntry 过滤/排序时使用的地址utxo
+type utxoEntry struct {
+	key  string
+	info *UtxoInfo
+	utxo *model.UTXO
+}
+
+// validateQuery 校验过滤及排序参数
+func validateQuery(req *model.UTXORequest) error {
+	switch req.SortBy {
+	case "", SortByValue, SortByAge:
+	default:
+		return fmt.Errorf("%w:sort_by %s", ErrInvalidQuery, req.SortBy)
+	}
+	switch req.Order {
+	case "", OrderAsc, OrderDesc:
+	default:
+		return fmt.Errorf("%w:order %s", ErrInvalidQuery, req.Order)
+	}
+	if req.MinValue < 0 || req.MaxValue < 0 || req.DustThreshold < 0 || req.MinConf < 0 {
+		return fmt.Errorf("%w:negative filter", ErrInvalidQuery)
+	}
+	if req.MaxValue > 0 && req.MinValue > req.MaxValue {
+		return fmt.Errorf("%w:min_value greater than max_value", ErrInvalidQuery)
+	}
+	return nil
+}
+
+// hasFilter 是否需要读取地址下全部utxo进行过滤或排序
+func hasFilter(req *model.UTXORequest) bool {
+	return req.MinValue > 0 || req.MaxValue > 0 || req.DustThreshold > 0 ||
+		req.MinConf > 0 || len(req.SortBy) > 0
+}
+
+// matchFilter 判断utxo是否满足过滤条件 snapHeight为查询快照高度
+func matchFilter(req *model.UTXORequest, info *UtxoInfo, snapHeight int64) bool {
+	if info.Height > snapHeight {
+		return false
+	}
+	if req.MinValue > 0 && info.Value < req.MinValue {
+		return false
+	}
+	if req.MaxValue > 0 && info.Value > req.MaxValue {
+		return false
+	}
+	if req.DustThreshold > 0 && info.Value < req.DustThreshold {
+		return false
+	}
+	if req.MinConf > 0 && snapHeight-info.Height+1 < req.MinConf {
+		return false
+	}
+	return true
+}
+
+// sortValue 排序字段的值，同时写入游标用于定位
+func sortValue(req *model.UTXORequest, info *UtxoInfo) float64 {
+	switch req.SortBy {
+	case SortByValue:
+		return info.Value
+	case SortByAge:
+		return float64(-info.Height)
+	}
+	return 0
+}
+
+// lessEntry 排序字段相同时按key升序，保证顺序稳定
+func lessEntry(req *model.UTXORequest, av float64, ak string, bv float64, bk string) bool {
+	if av != bv {
+		if req.Order == OrderDesc {
+			return av > bv
+		}
+		return av < bv
+	}
+	return ak < bk
+}
+
+// filterUtxos 读取地址下全部utxo，过滤排序后分页 total_size为过滤后的数量
+func (db *DB) filterUtxos(reply *model.UTXOReply, req *model.UTXORequest, c *cursor, members []string) error {
+	entries := make([]*utxoEntry, 0, len(members))
+	for _, ukey := range members {
+		info, utxo, err := db.getAddressUtxo(req.Address, ukey)
+		if err != nil {
+			return err
+		}
+		if info.Height == 0 && needHeight(req) {
+			return ErrUnknownHeight
+		}
+		if !matchFilter(req, info, c.Height) {
+			continue
+		}
+		entries = append(entries, &utxoEntry{key: ukey, info: info, utxo: utxo})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		return lessEntry(req, sortValue(req, a.info), a.key, sortValue(req, b.info), b.key)
+	})
+	reply.TotalSize = len(entries)
+
+	var start int
+	if len(c.Key) > 0 {
+		start = sort.Search(len(entries), func(i int) bool {
+			e := entries[i]
+			return lessEntry(req, c.Value, string(c.Key), sortValue(req, e.info), e.key)
+		})
+	} else {
+		start = req.Page * req.PageSize
+		if start > len(entries) {
+			start = len(entries)
+		}
+	}
+	end := start + req.PageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	utxos := make([]*model.UTXO, 0, end-start)
+	for _, e := range entries[start:end] {
+		utxos = append(utxos, e.utxo)
+	}
+	if end < len(entries) {
+		last := entries[end-1]
+		reply.NextCursor = (&cursor{Height: c.Height, Key: []byte(last.key), Value: sortValue(req, last.info), Query: c.Query}).encode()
+	}
+	reply.Utxos = utxos
+	return nil
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"go.uber.org/zap"
+)
+
+const (
+	schemaVersionKey = "s:schema"
+	migrationKey     = "s:migrate" //进行中的迁移步骤的断点
+)
+
+// SchemaVersion 当前程序使用的存储格式版本
+// 没有版本记录的数据库为版本1(引入版本记录之前的格式，之后新增的proto字段向前兼容)
+// 版本2: utxo key改为二进制outpoint
+// 版本3: 统计未记录创建高度的utxo
+const SchemaVersion = 3
+
+// ErrUnknownSchema 数据库由更新版本的程序创建
+var ErrUnknownSchema = errors.New("unknown database schema version")
+
+// migration 将数据库从version-1升级到version
+// step每次处理一部分数据：从cursor之后开始，返回下一次的断点及处理的记录数，done为true表示完成
+// 每步完成后保存断点，中断后重新启动从断点继续，因此step需可重复执行
+type migration struct {
+	version int64
+	name    string
+	step    func(db *DB, cursor []byte) (next []byte, n int, done bool, err error)
+}
+
+// migrations 按版本顺序排列
+var migrations = []migration{
+	{version: 2, name: "binary outpoint keys", step: migrateBinaryKeys},
+	{version: 3, name: "legacy utxo heights", step: migrateLegacyHeights},
+}
+
+// GetSchemaVersion 读取存储格式版本 没有记录时返回0
+func (db *DB) GetSchemaVersion() (int64, error) {
+	val, err := db.udb.Get([]byte(schemaVersionKey))
+	if err != nil || len(val) == 0 {
+		return 0, err
+	}
+	return pkg.BytesToInt64(val), nil
+}
+
+// checkSchema 打开数据库时检查存储格式版本，旧版本依次执行迁移
+func (db *DB) checkSchema() error {
+	version, err := db.GetSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		// 新建的数据库直接使用当前版本
+		empty, err := db.IsEmpty()
+		if err != nil {
+			return err
+		}
+		version = 1
+		if empty {
+			version = SchemaVersion
+		}
+		if !db.readOnly {
+			if err := db.udb.SetSync([]byte(schemaVersionKey), pkg.Int64ToBytes(version)); err != nil {
+				return err
+			}
+		}
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w %d, this build supports up to %d", ErrUnknownSchema, version, SchemaVersion)
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+	if db.readOnly {
+		return fmt.Errorf("database schema version %d needs migration to %d, open it read-write first", version, SchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := db.migrate(m); err != nil {
+			return fmt.Errorf("migrate to schema %d (%s): %w", m.version, m.name, err)
+		}
+	}
+	return nil
+}
+
+func (db *DB) migrate(m migration) error {
+	cursor, err := db.udb.Get([]byte(migrationKey))
+	if err != nil {
+		return err
+	}
+	db.logger.Info("Migrate::Start",
+		zap.Int64("version", m.version),
+		zap.String("name", m.name),
+		zap.Bool("resume", len(cursor) > 0))
+
+	start, last := time.Now(), time.Now()
+	var total int
+	for {
+		next, n, done, err := m.step(db, cursor)
+		if err != nil {
+			return err
+		}
+		total += n
+		if done {
+			break
+		}
+		if err := db.udb.SetSync([]byte(migrationKey), next); err != nil {
+			return err
+		}
+		cursor = next
+		if time.Since(last) >= 10*time.Second {
+			last = time.Now()
+			db.logger.Info("Migrate::Progress",
+				zap.Int64("version", m.version),
+				zap.Int("records", total),
+				zap.String("cursor", hex.EncodeToString(cursor)))
+		}
+	}
+
+	// 版本号与清除断点在同一批次写入
+	wb := db.udb.NewBatch()
+	defer wb.Close()
+	if err := wb.Set([]byte(schemaVersionKey), pkg.Int64ToBytes(m.version)); err != nil {
+		return err
+	}
+	if err := wb.Delete([]byte(migrationKey)); err != nil {
+		return err
+	}
+	if err := wb.WriteSync(); err != nil {
+		return err
+	}
+	db.logger.Info("Migrate::Done",
+		zap.Int64("version", m.version),
+		zap.Int("records", total),
+		zap.Duration("ttl", time.Since(start)))
+	return nil
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"google.golang.org/protobuf/proto"
+)
+
+// migrateBatchSize 迁移时每步处理的记录数
+const migrateBatchSize = 10000
+
+// 版本2迁移的阶段 断点为阶段编号 + 该阶段最后处理的key
+const (
+	phaseUtxo       = 'u' //utxo记录的key
+	phaseSpendIndex = 'p' //花费高度索引中的utxo key
+	phaseAddress    = 'a' //地址utxo集合的成员
+)
+
+// migrateBinaryKeys 版本2: utxo key由u:txid:index字符串改为二进制(pkg.EncodeOutpoint)
+// 新旧key长度不同可直接区分，同一前缀下改写的新key被再次遍历时跳过，中断后重复执行不会重复改写
+func migrateBinaryKeys(db *DB, cursor []byte) ([]byte, int, bool, error) {
+	phase, last := byte(phaseUtxo), []byte(nil)
+	if len(cursor) > 0 {
+		phase, last = cursor[0], cursor[1:]
+	}
+
+	var (
+		store   KV
+		prefix  string
+		convert func(key, val []byte) ([]byte, []byte, bool, error)
+		next    byte
+	)
+	switch phase {
+	case phaseUtxo:
+		store, prefix, next = db.udb, utxoKeyPrefix, phaseSpendIndex
+		convert = func(key, val []byte) ([]byte, []byte, bool, error) {
+			ukey, ok := legacyUKey(string(key))
+			return []byte(ukey), val, ok, nil
+		}
+	case phaseSpendIndex:
+		store, prefix, next = db.udb, spendIndexPrefix, phaseAddress
+		convert = func(key, val []byte) ([]byte, []byte, bool, error) {
+			head := len(spendIndexPrefix) + 8
+			if len(key) <= head {
+				return nil, nil, false, nil
+			}
+			ukey, ok := legacyUKey(string(key[head:]))
+			return append(append([]byte{}, key[:head]...), ukey...), val, ok, nil
+		}
+	case phaseAddress:
+		store, prefix = db.audb, addressUtxoKeyPrefix
+		convert = func(key, val []byte) ([]byte, []byte, bool, error) {
+			set := &OutpointSet{}
+			if err := proto.Unmarshal(val, set); err != nil {
+				return nil, nil, false, err
+			}
+			var changed bool
+			for i, m := range set.Members {
+				if ukey, ok := legacyUKey(string(m)); ok {
+					set.Members[i] = []byte(ukey[len(utxoKeyPrefix):])
+					changed = true
+				}
+			}
+			if !changed {
+				return nil, nil, false, nil
+			}
+			b, err := proto.Marshal(set)
+			return key, b, true, err
+		}
+	default:
+		return nil, 0, false, ErrInvalidCursor
+	}
+
+	end, n, err := rewriteKeys(store, []byte(prefix), last, convert)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if n < migrateBatchSize {
+		// 当前阶段完成
+		if next == 0 {
+			return nil, n, true, nil
+		}
+		return []byte{next}, n, false, nil
+	}
+	return append([]byte{phase}, end...), n, false, nil
+}
+
+// rewriteKeys 处理prefix下after之后的一批记录 convert返回新的key及value，不需要改写时返回false
+// key变化时删除旧key，与新记录在同一批次写入；返回本批最后一个key及遍历的记录数
+func rewriteKeys(store KV, prefix, after []byte, convert func(key, val []byte) ([]byte, []byte, bool, error)) ([]byte, int, error) {
+	start := prefix
+	if len(after) > 0 {
+		start = append(append([]byte{}, after...), 0)
+	}
+	items := make([]kv, 0, migrateBatchSize)
+	it, err := store.Iterator(start, prefixEnd(prefix))
+	if err != nil {
+		return nil, 0, err
+	}
+	for ; it.Valid() && len(items) < migrateBatchSize; it.Next() {
+		items = append(items, kv{
+			key: append([]byte{}, it.Key()...),
+			val: append([]byte{}, it.Value()...),
+		})
+	}
+	err = it.Error()
+	it.Close()
+	if err != nil || len(items) == 0 {
+		return nil, 0, err
+	}
+
+	wb := store.NewBatch()
+	defer wb.Close()
+	for _, item := range items {
+		key, val, ok, err := convert(item.key, item.val)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !ok {
+			continue
+		}
+		if !bytes.Equal(key, item.key) {
+			if err := wb.Delete(item.key); err != nil {
+				return nil, 0, err
+			}
+		}
+		if err := wb.Set(key, val); err != nil {
+			return nil, 0, err
+		}
+	}
+	if err := wb.WriteSync(); err != nil {
+		return nil, 0, err
+	}
+	return items[len(items)-1].key, len(items), nil
+}
+
+// legacyUKey 将版本1的utxo key(u:txid:index)转换为二进制key 不是版本1格式时返回false
+func legacyUKey(s string) (string, bool) {
+	if !strings.HasPrefix(s, utxoKeyPrefix) {
+		return "", false
+	}
+	txid, index, err := pkg.ParseOutpoint(s[len(utxoKeyPrefix):])
+	if err != nil {
+		return "", false
+	}
+	ukey, err := UtxoKey(txid, index)
+	return ukey, err == nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// noHeightKey 版本3迁移统计的未记录创建高度的未花费utxo数量 没有这类utxo时不写入
+const noHeightKey = "s:noheight"
+
+// migrateLegacyHeights 版本3: 统计UtxoInfo.height引入之前索引的未花费utxo
+// 索引从高度1开始，height为0即未记录；创建高度无法从数据库恢复，只记录数量并提示reindex，
+// 查询时这些utxo不支持min_conf及sort_by=age(见ErrUnknownHeight)
+// 断点为已统计的数量(8字节) + 最后处理的key
+func migrateLegacyHeights(db *DB, cursor []byte) ([]byte, int, bool, error) {
+	var (
+		count int64
+		last  []byte
+	)
+	if len(cursor) >= 8 {
+		count, last = pkg.BytesToInt64(cursor[:8]), cursor[8:]
+	}
+	start := []byte(utxoKeyPrefix)
+	if len(last) > 0 {
+		start = append(append([]byte{}, last...), 0)
+	}
+
+	it, err := db.udb.Iterator(start, prefixEnd([]byte(utxoKeyPrefix)))
+	if err != nil {
+		return nil, 0, false, err
+	}
+	var n int
+	for ; it.Valid() && n < migrateBatchSize; it.Next() {
+		n++
+		last = append(last[:0], it.Key()...)
+		info := &UtxoInfo{}
+		if err := proto.Unmarshal(it.Value(), info); err != nil {
+			it.Close()
+			return nil, 0, false, err
+		}
+		if info.Spend == nil && info.Height == 0 {
+			count++
+		}
+	}
+	err = it.Error()
+	it.Close()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	if n == migrateBatchSize {
+		return append(pkg.Int64ToBytes(count), last...), n, false, nil
+	}
+
+	if count > 0 {
+		if err := db.udb.SetSync([]byte(noHeightKey), pkg.Int64ToBytes(count)); err != nil {
+			return nil, 0, false, err
+		}
+		db.logger.Warn("Migrate::NoHeight",
+			zap.Int64("utxos", count),
+			zap.String("hint", "utxos indexed before heights were stored reject min_conf and sort_by=age, use reindex --from 0 to rebuild"))
+	}
+	return nil, n, true, nil
+}
+
+// GetNoHeightCount 版本3迁移时未记录创建高度的未花费utxo数量
+func (db *DB) GetNoHeightCount() (int64, error) {
+	val, err := db.udb.Get([]byte(noHeightKey))
+	if err != nil || len(val) == 0 {
+		return 0, err
+	}
+	return pkg.BytesToInt64(val), nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// IsEmpty 没有存储高度及utxo记录
+func (db *DB) IsEmpty() (bool, error) {
+	sheight, err := db.GetStoreHeight()
+	if err != nil || sheight > 0 {
+		return false, err
+	}
+	it, err := db.udb.Iterator([]byte(utxoKeyPrefix), prefixEnd([]byte(utxoKeyPrefix)))
+	if err != nil {
+		return false, err
+	}
+	defer it.Close()
+	return !it.Valid(), it.Error()
+}
+
+// StoreSnapshot 写入快照中的一批utxo 不更新存储高度，全部写入后调用SetStoreHeight
+func (db *DB) StoreSnapshot(vouts []model.Out, height int64) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	utxom, abm, aum, delta, err := db.parseUtxo(nil, vouts)
+	if err != nil {
+		return err
+	}
+	meta := make(map[string][]byte, 1)
+	st, err := db.applyUTXOSetDelta(delta, height)
+	if err != nil {
+		return err
+	}
+	if st != nil {
+		meta[utxoSetKey] = st
+	}
+	return db.batchStore(utxom, abm, aum, meta)
+}
+
+// SetStoreHeight 设置存储高度 Indexer.Sync从下一个区块继续同步
+func (db *DB) SetStoreHeight(height int64) error {
+	if db.readOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.storeLastHeight(height)
+}
+
+// ForEachUnspent 按key顺序遍历未花费的utxo，同一txid的输出相邻(输出序号按uvarint编码的字节排序，小于128时与数值顺序一致)
+// 遍历期间持有读锁，begin收到的utxo集合信息与之后遍历的数据一致；旧版本索引没有锁定脚本，返回ErrUTXOSetUnavailable
+func (db *DB) ForEachUnspent(ctx context.Context, begin func(info *model.UTXOSetInfo) error, fn func(txid string, index uint32, info *UtxoInfo) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	info, err := db.GetUTXOSetInfo()
+	if err != nil {
+		return err
+	}
+	if err := begin(info); err != nil {
+		return err
+	}
+	var n int
+	return db.iteratePrefix(db.udb, []byte(utxoKeyPrefix), func(key, val []byte) error {
+		if n++; n%deleteBatchSize == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		info := &UtxoInfo{}
+		if err := proto.Unmarshal(val, info); err != nil {
+			return err
+		}
+		if info.Spend != nil {
+			return nil
+		}
+		txid, index, err := parseUKey(string(key))
+		if err != nil {
+			return err
+		}
+		return fn(txid, index, info)
+	})
+}
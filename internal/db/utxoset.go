@@ -0,0 +1,154 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/muhash"
+	"google.golang.org/protobuf/proto"
+)
+
+const utxoSetKey = "s:utxoset"
+
+// ErrUTXOSetUnavailable 旧版本建立的索引缺少锁定脚本，无法计算utxo集合哈希
+var ErrUTXOSetUnavailable = errors.New("utxo set hash unavailable, reindex from genesis to enable it")
+
+// coin utxo集合哈希的元素
+type coin struct {
+	ukey string
+	info *UtxoInfo
+}
+
+// utxoSetDelta 一次存储中utxo集合的变化
+type utxoSetDelta struct {
+	added   []coin
+	removed []coin
+}
+
+// serializeCoin 与Bitcoin Core的TxOutSer一致：outpoint + (height<<1 | coinbase) + value + script
+func serializeCoin(c coin) ([]byte, error) {
+	txid, index, err := parseUKey(c.ukey)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		return nil, err
+	}
+	value, err := btcutil.NewAmount(c.info.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(chainhash.HashSize + 16 + 9 + len(c.info.Script))
+	buf.Write(hash[:])
+	var b [8]byte
+	binary.LittleEndian.PutUint32(b[:4], index)
+	buf.Write(b[:4])
+	code := uint32(c.info.Height) << 1
+	if c.info.Coinbase {
+		code |= 1
+	}
+	binary.LittleEndian.PutUint32(b[:4], code)
+	buf.Write(b[:4])
+	binary.LittleEndian.PutUint64(b[:], uint64(value))
+	buf.Write(b[:])
+	if err := wire.WriteVarBytes(&buf, 0, c.info.Script); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// getUTXOSetState 读取utxo集合哈希状态 空库时从空集合开始，旧索引没有状态时返回nil
+func (db *DB) getUTXOSetState() (*UtxoSetState, error) {
+	val, err := db.udb.Get([]byte(utxoSetKey))
+	if err != nil {
+		return nil, err
+	}
+	if len(val) > 0 {
+		st := &UtxoSetState{}
+		if err := proto.Unmarshal(val, st); err != nil {
+			return nil, err
+		}
+		return st, nil
+	}
+	sheight, err := db.GetStoreHeight()
+	if err != nil || sheight > 0 {
+		return nil, err
+	}
+	num, den := muhash.New().Bytes()
+	return &UtxoSetState{Numerator: num, Denominator: den}, nil
+}
+
+// applyUTXOSetDelta 更新utxo集合哈希 返回待写入的状态，索引不支持时返回nil
+func (db *DB) applyUTXOSetDelta(delta *utxoSetDelta, height int64) ([]byte, error) {
+	st := &UtxoSetState{}
+	if val, ok := db.cache.meta[utxoSetKey]; ok {
+		if err := proto.Unmarshal(val, st); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if st, err = db.getUTXOSetState(); err != nil || st == nil {
+			return nil, err
+		}
+	}
+
+	mh := muhash.FromBytes(st.Numerator, st.Denominator)
+	total := btcutil.Amount(st.TotalAmount)
+	for _, c := range delta.added {
+		data, err := serializeCoin(c)
+		if err != nil {
+			return nil, err
+		}
+		mh.Insert(data)
+		st.Txouts++
+		value, _ := btcutil.NewAmount(c.info.Value)
+		total += value
+	}
+	for _, c := range delta.removed {
+		data, err := serializeCoin(c)
+		if err != nil {
+			return nil, err
+		}
+		mh.Remove(data)
+		st.Txouts--
+		value, _ := btcutil.NewAmount(c.info.Value)
+		total -= value
+	}
+
+	st.Numerator, st.Denominator = mh.Bytes()
+	st.TotalAmount = int64(total)
+	st.Height = height
+	return proto.Marshal(st)
+}
+
+// GetUTXOSetInfo utxo集合哈希 与gettxoutsetinfo muhash的结果对应
+func (db *DB) GetUTXOSetInfo() (*model.UTXOSetInfo, error) {
+	st, err := db.getUTXOSetState()
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return nil, ErrUTXOSetUnavailable
+	}
+
+	hash := chainhash.Hash(muhash.FromBytes(st.Numerator, st.Denominator).Finalize())
+	return &model.UTXOSetInfo{
+		Height:      st.Height,
+		TxOuts:      st.Txouts,
+		TotalAmount: formatAmount(btcutil.Amount(st.TotalAmount)),
+		MuHash:      hash.String(),
+	}, nil
+}
+
+func formatAmount(amount btcutil.Amount) string {
+	return fmt.Sprintf("%.8f", amount.ToBTC())
+}
@@ -0,0 +1,277 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	IssueMissingUtxo     = "missing_utxo"     //au:成员在utxo中不存在
+	IssueSpentUtxo       = "spent_utxo"       //au:成员已花费
+	IssueAddressMismatch = "address_mismatch" //au:成员属于其他地址
+	IssueBalanceMismatch = "balance_mismatch" //ab:余额与有效utxo金额之和不一致
+	IssueOrphanBalance   = "orphan_balance"   //ab:余额不为0但地址下没有utxo
+
+	verifyChunkSize = 1000
+	maxReportIssues = 1000
+)
+
+// VerifyProgress 校验进度回调
+type VerifyProgress func(processed, total int64)
+
+type kv struct {
+	key, val []byte
+}
+
+// Verify 校验索引一致性：遍历au:地址，检查每个成员存在、未花费且属于该地址，
+// 并用有效utxo金额之和校验ab:余额；再遍历ab:检查没有utxo集合的余额。
+// repair为true时移除无效成员并按有效utxo重算余额。按批持有锁，可与同步同时运行
+func (db *DB) Verify(ctx context.Context, repair bool, progress VerifyProgress) (*model.VerifyReport, error) {
+	if repair && db.readOnly {
+		return nil, ErrReadOnly
+	}
+	// 修复基于数据库中的数据，先写入缓存
+	if repair {
+		if err := db.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	sheight, err := db.GetStoreHeight()
+	if err != nil {
+		return nil, err
+	}
+	report := &model.VerifyReport{
+		Repair:      repair,
+		StoreHeight: sheight,
+		Issues:      make([]*model.VerifyIssue, 0),
+	}
+
+	var total, processed int64
+	for _, c := range []struct {
+		store  KV
+		prefix string
+	}{{db.audb, addressUtxoKeyPrefix}, {db.bdb, addressBalanceKeyPrefix}} {
+		if err := db.iteratePrefix(c.store, []byte(c.prefix), func(key, val []byte) error {
+			total++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if progress != nil {
+		progress(0, total)
+	}
+
+	phases := []struct {
+		store  KV
+		prefix string
+		fn     func(chunk []kv, report *model.VerifyReport, repair bool) error
+	}{
+		{db.audb, addressUtxoKeyPrefix, db.verifyAddressUtxo},
+		{db.bdb, addressBalanceKeyPrefix, db.verifyOrphanBalance},
+	}
+	for _, phase := range phases {
+		start, end := []byte(phase.prefix), prefixEnd([]byte(phase.prefix))
+		for {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+			n, next, err := db.verifyChunk(phase.store, start, end, report, repair, phase.fn)
+			if err != nil {
+				return report, err
+			}
+			if n == 0 {
+				break
+			}
+			processed += int64(n)
+			if progress != nil {
+				progress(processed, total)
+			}
+			start = next
+		}
+	}
+	return report, nil
+}
+
+// verifyChunk 持锁读取一批key并校验 返回数量及下一批的起始key
+func (db *DB) verifyChunk(store KV, start, end []byte, report *model.VerifyReport, repair bool,
+	fn func(chunk []kv, report *model.VerifyReport, repair bool) error) (int, []byte, error) {
+	if repair {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		// 批次之间Store写入的缓存可能包含本批地址的旧状态，修复前先写入，避免之后刷新覆盖修复结果
+		if err := db.flush(); err != nil {
+			return 0, nil, err
+		}
+	} else {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+	}
+
+	chunk := make([]kv, 0, verifyChunkSize)
+	it, err := store.Iterator(start, end)
+	if err != nil {
+		return 0, nil, err
+	}
+	for ; it.Valid() && len(chunk) < verifyChunkSize; it.Next() {
+		chunk = append(chunk, kv{
+			key: append([]byte{}, it.Key()...),
+			val: append([]byte{}, it.Value()...),
+		})
+	}
+	err = it.Error()
+	it.Close()
+	if err != nil || len(chunk) == 0 {
+		return 0, nil, err
+	}
+	if err := fn(chunk, report, repair); err != nil {
+		return 0, nil, err
+	}
+	// 下一批从最后一个key之后开始
+	next := append(chunk[len(chunk)-1].key, 0)
+	return len(chunk), next, nil
+}
+
+func addIssue(report *model.VerifyReport, issue *model.VerifyIssue) {
+	report.IssueCount++
+	if len(report.Issues) < maxReportIssues {
+		report.Issues = append(report.Issues, issue)
+	}
+}
+
+func (db *DB) verifyAddressUtxo(chunk []kv, report *model.VerifyReport, repair bool) error {
+	aub := db.audb.NewBatch()
+	defer aub.Close()
+	abb := db.bdb.NewBatch()
+	defer abb.Close()
+
+	for _, item := range chunk {
+		address := strings.TrimPrefix(string(item.key), addressUtxoKeyPrefix)
+		members, err := unmarshalMembers(item.val)
+		if err != nil {
+			return fmt.Errorf("invalid utxo set %s: %w", item.key, err)
+		}
+		report.Addresses++
+
+		valid := make([]string, 0, len(members))
+		var sum decimal.Decimal
+		for _, ukey := range members {
+			val, err := db.udb.Get([]byte(ukey))
+			if err != nil {
+				return err
+			}
+			if len(val) == 0 {
+				addIssue(report, &model.VerifyIssue{Type: IssueMissingUtxo, Address: address, UKey: formatUKey(ukey)})
+				continue
+			}
+			info := &UtxoInfo{}
+			if err := proto.Unmarshal(val, info); err != nil {
+				return err
+			}
+			if info.Address != address {
+				addIssue(report, &model.VerifyIssue{Type: IssueAddressMismatch, Address: address, UKey: formatUKey(ukey),
+					Expected: address, Actual: info.Address})
+				continue
+			}
+			if info.Spend != nil {
+				addIssue(report, &model.VerifyIssue{Type: IssueSpentUtxo, Address: address, UKey: formatUKey(ukey),
+					Actual: fmt.Sprintf("%s:%d", info.Spend.Txid, info.Spend.Index)})
+				continue
+			}
+			valid = append(valid, ukey)
+			sum = sum.Add(decimal.NewFromFloat(info.Value))
+		}
+
+		bal, err := db.getBalance(address)
+		if err != nil {
+			return err
+		}
+		balanceOK := bal.Equal(sum)
+		if !balanceOK {
+			addIssue(report, &model.VerifyIssue{Type: IssueBalanceMismatch, Address: address,
+				Expected: sum.StringFixed(8), Actual: bal.StringFixed(8)})
+		}
+
+		if !repair || (balanceOK && len(valid) == len(members)) {
+			continue
+		}
+		report.Repaired++
+		abKey := []byte(addressBalanceKeyPrefix + address)
+		if len(valid) == 0 {
+			if err := aub.Delete(item.key); err != nil {
+				return err
+			}
+		} else if len(valid) != len(members) {
+			b, err := marshalMembers(valid)
+			if err != nil {
+				return err
+			}
+			if err := aub.Set(item.key, b); err != nil {
+				return err
+			}
+		}
+		if sum.IsZero() {
+			err = abb.Delete(abKey)
+		} else {
+			err = abb.Set(abKey, []byte(sum.StringFixed(8)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !repair {
+		return nil
+	}
+	if err := aub.WriteSync(); err != nil {
+		return err
+	}
+	return abb.WriteSync()
+}
+
+func (db *DB) verifyOrphanBalance(chunk []kv, report *model.VerifyReport, repair bool) error {
+	abb := db.bdb.NewBatch()
+	defer abb.Close()
+
+	for _, item := range chunk {
+		address := strings.TrimPrefix(string(item.key), addressBalanceKeyPrefix)
+		report.Balances++
+		ok, err := db.audb.Has([]byte(addressUtxoKeyPrefix + address))
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		addIssue(report, &model.VerifyIssue{Type: IssueOrphanBalance, Address: address,
+			Expected: decimal.Zero.StringFixed(8), Actual: string(item.val)})
+		if repair {
+			report.Repaired++
+			if err := abb.Delete(item.key); err != nil {
+				return err
+			}
+		}
+	}
+	if !repair {
+		return nil
+	}
+	return abb.WriteSync()
+}
+
+func (db *DB) getBalance(address string) (decimal.Decimal, error) {
+	val, err := db.bdb.Get([]byte(addressBalanceKeyPrefix + address))
+	if err != nil || len(val) == 0 {
+		return decimal.Zero, err
+	}
+	f, err := strconv.ParseFloat(string(val), 64)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid balance %s: %w", address, err)
+	}
+	return decimal.NewFromFloat(f), nil
+}
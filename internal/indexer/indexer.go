@@ -2,14 +2,17 @@ package indexer
 
 import (
 	"context"
+	"encoding/hex"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/wx-shi/utxo-indexer/internal/config"
 	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
 	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
 	"github.com/wx-shi/utxo-indexer/pkg"
 	"go.uber.org/zap"
 )
@@ -17,18 +20,18 @@ import (
 type Indexer struct {
 	ctx                 context.Context
 	logger              *zap.Logger
-	rpc                 *rpcclient.Client
+	rpc                 rpc.Node
 	db                  *db.DB
 	conf                *config.IndexerConfig
 	scanHeight          int64
 	storeHeight         int64
 	blockChan           chan model.BlockUTXO
-	isHistoryScanFinish bool
+	isHistoryScanFinish atomic.Bool //扫描协程写入，存储协程读取
 	Finish              chan struct{}
 }
 
 func NewIndexer(ctx context.Context, conf *config.IndexerConfig,
-	logger *zap.Logger, rpc *rpcclient.Client, db *db.DB) *Indexer {
+	logger *zap.Logger, rpc rpc.Node, db *db.DB) *Indexer {
 	return &Indexer{
 		ctx:    ctx,
 		conf:   conf,
@@ -40,6 +43,7 @@ func NewIndexer(ctx context.Context, conf *config.IndexerConfig,
 }
 
 func (i *Indexer) Sync() {
+	i.db.SetCache(i.conf.CacheSize, i.conf.CacheFlushBlocks)
 	i.init()
 	// i.fixBalance()
 	go i.scan()
@@ -56,6 +60,8 @@ func (i *Indexer) init() {
 	i.storeHeight = height
 	i.scanHeight = height + 1
 	i.blockChan = make(chan model.BlockUTXO, i.conf.BlockChanBuf)
+	metrics.StoreHeight.Set(float64(height))
+	metrics.ScanHeight.Set(float64(height))
 }
 
 func (i *Indexer) scan() {
@@ -68,15 +74,17 @@ func (i *Indexer) scan() {
 			//获取当前最新高度
 			nheight, err := i.rpc.GetBlockCount()
 			if err != nil {
+				metrics.RPCErrors.WithLabelValues("getblockcount").Inc()
 				i.logger.Error("GetBlockCount", zap.Error(err))
 				continue
 			}
+			metrics.NodeHeight.Set(float64(nheight))
 
 			if i.scanHeight > nheight {
 				continue
 			}
 
-			i.isHistoryScanFinish = false
+			i.isHistoryScanFinish.Store(false)
 
 			if err := i.scanByHeightRange(i.scanHeight, nheight); err != nil {
 				i.logger.Error("scanByHeightRange", zap.Error(err))
@@ -91,13 +99,15 @@ func (i *Indexer) scan() {
 func (idx *Indexer) scanByHeightRange(startHeight int64, endHeight int64) error {
 	for i := startHeight; i <= endHeight; i++ {
 		if i == endHeight {
-			idx.isHistoryScanFinish = true
+			idx.isHistoryScanFinish.Store(true)
 		}
 		if err := idx.scanTxByBlock(i); err != nil {
 			idx.logger.Error("scanTxByBlock", zap.Int64("height", i), zap.Error(err))
 			return err
 		}
 		idx.scanHeight = i + 1
+		metrics.ScanHeight.Set(float64(i))
+		metrics.BlocksScanned.Inc()
 	}
 	return nil
 }
@@ -120,40 +130,62 @@ func (idx *Indexer) scanTxByBlock(height int64) error {
 			if len(vin.Coinbase) > 0 || len(vin.Txid) == 0 {
 				continue
 			}
+			ukey, err := db.UtxoKey(vin.Txid, vin.Vout)
+			if err != nil {
+				return fmt.Errorf("vin %s:%d: %w", tx.Txid, i, err)
+			}
 			vins = append(vins, model.In{
-				UKey:  fmt.Sprintf("u:%s:%d", vin.Txid, vin.Vout),
+				UKey:  ukey,
 				TxID:  vin.Txid,
 				Index: int(vin.Vout),
 				Spend: &model.Spend{
-					TxID:  tx.Txid,
-					Index: i,
+					TxID:   tx.Txid,
+					Index:  i,
+					Height: height,
 				},
 			})
 		}
+		coinbase := len(tx.Vin) > 0 && tx.Vin[0].IsCoinBase()
 		for i, vout := range tx.Vout {
+			script, err := hex.DecodeString(vout.ScriptPubKey.Hex)
+			if err != nil {
+				return fmt.Errorf("decode script %s:%d: %w", tx.Txid, i, err)
+			}
+			// 不可花费的输出不进入utxo集合
+			if isUnspendable(script) {
+				continue
+			}
+			// 没有地址的输出(非标准脚本等)只记录utxo，不计入地址余额
+			var address string
 			switch vout.ScriptPubKey.Type {
 			case txscript.NonStandardTy.String(),
 				txscript.NullDataTy.String():
-				continue
 			default:
-				address, err := pkg.GetAddressByScriptPubKeyResult(vout.ScriptPubKey)
-				if err != nil || len(address) == 0 {
+				address, err = pkg.GetAddressByScriptPubKeyResult(vout.ScriptPubKey)
+				if err != nil {
 					// todo debug
 					idx.logger.Debug("GetAddressByScriptPubKeyResult",
 						zap.Any("vout", vout),
 						zap.String("txid", tx.Txid),
 						zap.Int("index", i),
 						zap.Error(err))
-					continue
+					address = ""
 				}
-				vouts = append(vouts, model.Out{
-					UKey:    fmt.Sprintf("u:%s:%d", tx.Txid, i),
-					TxID:    tx.Txid,
-					Index:   i,
-					Address: address,
-					Value:   vout.Value,
-				})
 			}
+			ukey, err := db.UtxoKey(tx.Txid, uint32(i))
+			if err != nil {
+				return fmt.Errorf("vout %s:%d: %w", tx.Txid, i, err)
+			}
+			vouts = append(vouts, model.Out{
+				UKey:     ukey,
+				TxID:     tx.Txid,
+				Index:    i,
+				Address:  address,
+				Value:    vout.Value,
+				Height:   height,
+				Script:   script,
+				Coinbase: coinbase,
+			})
 		}
 	}
 	idx.blockChan <- model.BlockUTXO{
@@ -166,34 +198,49 @@ func (idx *Indexer) scanTxByBlock(height int64) error {
 	return nil
 }
 
+// isUnspendable 与Bitcoin Core的CScript::IsUnspendable一致：OP_RETURN开头或超过最大脚本长度
+func isUnspendable(script []byte) bool {
+	return (len(script) > 0 && script[0] == txscript.OP_RETURN) || len(script) > txscript.MaxScriptSize
+}
+
 func (i *Indexer) store() {
 	vins := make([]model.In, 0, 1000000)
 	vouts := make([]model.Out, 0, 1000000)
 	var lastHeight int64
+	lastStore := time.Now()
+	flush := func() {
+		if err := i.db.Store(vins, vouts, lastHeight); err == nil {
+			vins = make([]model.In, 0, 1000000)
+			vouts = make([]model.Out, 0, 1000000)
+
+			now := time.Now()
+			metrics.BlocksPerSecond.Set(float64(lastHeight-i.storeHeight) / now.Sub(lastStore).Seconds())
+			i.storeHeight = lastHeight
+			lastStore = now
+		}
+	}
 	for {
 		select {
 		case <-i.ctx.Done():
 			close(i.Finish) //确保存储完成后退出
 			return
 		case hUtxos := <-i.blockChan:
+			metrics.BlockChanLen.Set(float64(len(i.blockChan)))
 			lastHeight = hUtxos.Height
 			vins = append(vins, hUtxos.Vins...)
 			vouts = append(vouts, hUtxos.Vouts...)
-			if i.isHistoryScanFinish {
-				//直接存储
-				if err := i.db.Store(vins, vouts, lastHeight); err == nil {
-					vins = make([]model.In, 0, 1000000)
-					vouts = make([]model.Out, 0, 1000000)
+			if i.isHistoryScanFinish.Load() {
+				//直接存储 已同步到最新区块，写入缓存使查询可见
+				flush()
+				if err := i.db.Flush(); err != nil {
+					i.logger.Error("Flush", zap.Error(err))
 				}
 				continue
 			}
 		}
 		//如果10w个utxo进行存储
 		if len(vins)+len(vouts) >= int(i.conf.BatchSize) {
-			if err := i.db.Store(vins, vouts, lastHeight); err == nil {
-				vins = make([]model.In, 0, 1000000)
-				vouts = make([]model.Out, 0, 1000000)
-			}
+			flush()
 		}
 	}
 }
@@ -3,16 +3,19 @@ package indexer
 import (
 	"github.com/avast/retry-go"
 	"github.com/btcsuite/btcd/btcjson"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
 )
 
 func (i *Indexer) getBlockTx(height int64) (*btcjson.GetBlockVerboseTxResult, error) {
 	f := func() (*btcjson.GetBlockVerboseTxResult, error) {
 		hash, err := i.rpc.GetBlockHash(height)
 		if err != nil {
+			metrics.RPCErrors.WithLabelValues("getblockhash").Inc()
 			return nil, err
 		}
 		res, err := i.rpc.GetBlockVerboseTx(hash)
 		if err != nil {
+			metrics.RPCErrors.WithLabelValues("getblock").Inc()
 			return nil, err
 		}
 		return res, nil
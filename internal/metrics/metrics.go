@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "utxo_indexer"
+
+var (
+	// ScanHeight 已扫描到的区块高度
+	ScanHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "scan_height",
+		Help:      "Height of the last block fetched from the node.",
+	})
+
+	// StoreHeight 已存储的区块高度
+	StoreHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "store_height",
+		Help:      "Height of the last block persisted to the database.",
+	})
+
+	// NodeHeight 节点最新高度
+	NodeHeight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "node_height",
+		Help:      "Latest block height reported by the node.",
+	})
+
+	// BlocksScanned 扫描的区块数
+	BlocksScanned = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_scanned_total",
+		Help:      "Number of blocks fetched from the node.",
+	})
+
+	// BlocksPerSecond 最近一次存储期间的同步速度
+	BlocksPerSecond = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sync_blocks_per_second",
+		Help:      "Blocks persisted per second between the last two stores.",
+	})
+
+	// BlockChanLen 待存储区块缓冲区占用
+	BlockChanLen = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "block_chan_len",
+		Help:      "Number of scanned blocks waiting in the store buffer.",
+	})
+
+	// StoreDuration DB.Store耗时
+	StoreDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_duration_seconds",
+		Help:      "Duration of DB.Store calls.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	})
+
+	// StoreBatchSize 每次存储的len(vin)+len(vout)
+	StoreBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_batch_size",
+		Help:      "Number of inputs plus outputs written per DB.Store call.",
+		Buckets:   prometheus.ExponentialBuckets(10, 4, 10),
+	})
+
+	// UTXOCacheHits 存储时从写回缓存读到utxo记录的次数
+	UTXOCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "utxo_cache_hits_total",
+		Help:      "Number of UTXO lookups during store served by the write cache.",
+	})
+
+	// UTXOCacheMisses 存储时缓存未命中读取数据库的次数
+	UTXOCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "utxo_cache_misses_total",
+		Help:      "Number of UTXO lookups during store that read the database.",
+	})
+
+	// UTXOCacheEntries 缓存中的记录数
+	UTXOCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "utxo_cache_entries",
+		Help:      "Number of records held in the write cache.",
+	})
+
+	// UTXOCacheFlushes 缓存刷新次数
+	UTXOCacheFlushes = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "utxo_cache_flushes_total",
+		Help:      "Number of write cache flushes to the database.",
+	})
+
+	// RPCErrors 节点RPC错误数
+	RPCErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed node RPC calls.",
+	}, []string{"method"})
+
+	// RPCEndpointUp 节点健康状态
+	RPCEndpointUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rpc_endpoint_up",
+		Help:      "Whether the node RPC endpoint passed the last health and consistency check.",
+	}, []string{"endpoint"})
+
+	// RPCFailovers 节点切换次数
+	RPCFailovers = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_failovers_total",
+		Help:      "Number of times the active node RPC endpoint changed.",
+	})
+
+	// HTTPDuration 接口耗时
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
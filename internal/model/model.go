@@ -16,23 +16,37 @@ type In struct {
 }
 
 type Spend struct {
-	TxID  string
-	Index int
+	TxID   string
+	Index  int
+	Height int64
 }
 
 // 新入
 type Out struct {
-	UKey    string
-	TxID    string
-	Index   int
-	Address string  `json:"address"`
-	Value   float64 `json:"value"`
+	UKey     string
+	TxID     string
+	Index    int
+	Address  string  `json:"address"`
+	Value    float64 `json:"value"`
+	Height   int64   `json:"height"`
+	Script   []byte  `json:"-"` //锁定脚本 没有地址的输出Address为空
+	Coinbase bool    `json:"-"`
 }
 
 type UTXORequest struct {
 	Address  string `json:"address"`
 	Page     int    `json:"page"`
 	PageSize int    `json:"page_size"`
+	Cursor   string `json:"cursor"` //上一页返回的next_cursor，传入时忽略page
+
+	// 过滤 0表示不限制
+	MinValue      float64 `json:"min_value"`
+	MaxValue      float64 `json:"max_value"`
+	MinConf       int64   `json:"min_conf"`       //最小确认数
+	DustThreshold float64 `json:"dust_threshold"` //排除低于该金额的utxo
+	// 排序 sort_by: value|age order: asc|desc
+	SortBy string `json:"sort_by"`
+	Order  string `json:"order"`
 }
 
 type UTXO struct {
@@ -41,12 +55,23 @@ type UTXO struct {
 	Value string `json:"value"`
 }
 
+// AddressUTXO 地址下utxo的完整信息
+type AddressUTXO struct {
+	TxID    string
+	Index   int
+	Address string
+	Value   float64
+	Height  int64
+	Script  []byte
+}
+
 type UTXOReply struct {
-	Balance   string  `json:"balance"`
-	Page      int     `json:"page"`
-	PageSize  int     `json:"page_size"`
-	TotalSize int     `json:"total_size"`
-	Utxos     []*UTXO `json:"utxos"`
+	Balance    string  `json:"balance"`
+	Page       int     `json:"page"`
+	PageSize   int     `json:"page_size"`
+	TotalSize  int     `json:"total_size"`
+	Utxos      []*UTXO `json:"utxos"`
+	NextCursor string  `json:"next_cursor,omitempty"` //为空表示没有下一页
 }
 
 type HeightReply struct {
@@ -64,3 +89,219 @@ type UtxoInfo struct {
 	Address string  `json:"address"`
 	Value   float64 `json:"value"`
 }
+
+type CoinSelectRequest struct {
+	Addresses     []string `json:"addresses"`
+	Xpub          string   `json:"xpub"`           //xpub/ypub/zpub 账户层级扩展公钥
+	Target        float64  `json:"target"`         //支付金额
+	TargetAddress string   `json:"target_address"` //可选 用于估算输出大小
+	ChangeAddress string   `json:"change_address"` //可选 用于估算找零输出大小
+	FeeRate       float64  `json:"fee_rate"`       //sat/vB
+	MinConf       int64    `json:"min_conf"`
+}
+
+type CoinSelectReply struct {
+	Algorithm string  `json:"algorithm"` //bnb|knapsack
+	Inputs    []*Coin `json:"inputs"`
+	Total     string  `json:"total"`
+	Fee       string  `json:"fee"`
+	Change    string  `json:"change"` //0表示无找零输出
+	VSize     int64   `json:"vsize"`
+}
+
+type Coin struct {
+	TxID    string `json:"tx_id"`
+	Index   int    `json:"index"`
+	Address string `json:"address"`
+	Value   string `json:"value"`
+}
+
+type PSBTRequest struct {
+	Addresses     []string      `json:"addresses"` //来源地址
+	Outputs       []*PSBTOutput `json:"outputs"`
+	ChangeAddress string        `json:"change_address"` //可选 默认第一个来源地址
+	FeeRate       float64       `json:"fee_rate"`       //sat/vB
+	MinConf       int64         `json:"min_conf"`
+}
+
+type PSBTOutput struct {
+	Address string  `json:"address"`
+	Value   float64 `json:"value"`
+}
+
+type PSBTReply struct {
+	PSBT   string  `json:"psbt"` //base64
+	Inputs []*Coin `json:"inputs"`
+	Fee    string  `json:"fee"`
+	Change string  `json:"change"`
+	VSize  int64   `json:"vsize"`
+}
+
+type BroadcastRequest struct {
+	Hex string `json:"hex"` //原始交易
+}
+
+type BroadcastReply struct {
+	TxID      string           `json:"tx_id"`
+	Conflicts []*InputConflict `json:"conflicts,omitempty"`
+}
+
+// InputConflict 无法花费的输入 reason: missing|spent|mempool_spent
+type InputConflict struct {
+	TxID    string `json:"tx_id"`
+	Index   int    `json:"index"`
+	Reason  string `json:"reason"`
+	SpentBy string `json:"spent_by,omitempty"` //txid:index
+}
+
+// BroadcastError 节点拒绝交易时返回的错误
+type BroadcastError struct {
+	RPCCode    int    `json:"rpc_code"`
+	RPCMessage string `json:"rpc_message"`
+}
+
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+type HealthReply struct {
+	Status string                  `json:"status"`
+	Checks map[string]*HealthCheck `json:"checks"`
+}
+
+type HealthCheck struct {
+	OK          bool   `json:"ok"`
+	Error       string `json:"error,omitempty"`
+	StoreHeight int64  `json:"store_height,omitempty"`
+	NodeHeight  int64  `json:"node_height,omitempty"`
+	Lag         int64  `json:"lag,omitempty"`
+	MaxLag      int64  `json:"max_lag,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name  string  `json:"name"`
+	Rate  float64 `json:"rate"` //每秒请求数 0使用默认值
+	Burst int     `json:"burst"`
+	Quota int64   `json:"quota"` //每日请求配额 0使用默认值
+}
+
+type APIKeyReply struct {
+	ID        string  `json:"id"`
+	Key       string  `json:"key,omitempty"` //仅创建时返回
+	Name      string  `json:"name"`
+	Rate      float64 `json:"rate"`
+	Burst     int     `json:"burst"`
+	Quota     int64   `json:"quota"`
+	CreatedAt int64   `json:"created_at"`
+}
+
+type RollbackResult struct {
+	From               int64 `json:"from"`
+	To                 int64 `json:"to"`
+	Deleted            int   `json:"deleted"`              //删除的utxo记录
+	Restored           int   `json:"restored"`             //恢复为未花费的utxo
+	UnknownSpendHeight int   `json:"unknown_spend_height"` //未记录花费高度的已花费记录
+}
+
+type DBStats struct {
+	StoreHeight  int64                        `json:"store_height"`
+	Schema       int64                        `json:"schema"`              //存储格式版本
+	Mode         string                       `json:"mode"`                //archive或prune
+	PrunedHeight int64                        `json:"pruned_height"`       //已删除该高度及之前花费的记录
+	NoHeight     int64                        `json:"no_height,omitempty"` //升级时未记录创建高度的未花费utxo数量
+	Unspent      int64                        `json:"unspent"`
+	Spent        int64                        `json:"spent"`
+	UnspentValue string                       `json:"unspent_value"`
+	Addresses    int64                        `json:"addresses"`
+	Balances     int64                        `json:"balances"`
+	APIKeys      int64                        `json:"api_keys"`
+	Keys         map[string]map[string]int64  `json:"keys"`                    //各存储按key前缀(u:、ab:、au:等)的记录数
+	DiskSize     map[string]int64             `json:"disk_size,omitempty"`     //各存储数据目录占用的字节数 memdb不统计
+	TopAddresses []AddressUtxoCount           `json:"top_addresses,omitempty"` //utxo数量最多的地址
+	Backend      map[string]map[string]string `json:"backend"`                 //各存储的后端统计信息
+}
+
+type AddressUtxoCount struct {
+	Address string `json:"address"`
+	Utxos   int64  `json:"utxos"`
+}
+
+const (
+	CompactRunning = "running"
+	CompactDone    = "done"
+	CompactFailed  = "failed"
+)
+
+// CompactJob 后台压缩任务 按utxo、balance、address_utxo依次压缩
+type CompactJob struct {
+	State      string           `json:"state"`
+	Done       []string         `json:"done"` //已完成压缩的存储
+	SizeBefore map[string]int64 `json:"size_before,omitempty"`
+	SizeAfter  map[string]int64 `json:"size_after,omitempty"`
+	StartedAt  int64            `json:"started_at"`
+	FinishedAt int64            `json:"finished_at,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type ExportInfo struct {
+	Version     int    `json:"version"`
+	StoreHeight int64  `json:"store_height"`
+	CreatedAt   int64  `json:"created_at"`
+	Records     int64  `json:"records"`
+	Checksum    string `json:"checksum"` //未压缩数据的sha256
+}
+
+type VerifyReport struct {
+	Repair      bool           `json:"repair"`
+	StoreHeight int64          `json:"store_height"`
+	Addresses   int64          `json:"addresses"`   //校验的au:地址数
+	Balances    int64          `json:"balances"`    //校验的ab:余额数
+	IssueCount  int64          `json:"issue_count"` //发现的问题总数
+	Repaired    int64          `json:"repaired"`    //修复的地址数
+	Issues      []*VerifyIssue `json:"issues"`      //最多返回前1000条
+}
+
+type VerifyIssue struct {
+	Type     string `json:"type"`
+	Address  string `json:"address"`
+	UKey     string `json:"ukey,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+const (
+	VerifyRunning  = "running"
+	VerifyDone     = "done"
+	VerifyFailed   = "failed"
+	VerifyCanceled = "canceled"
+)
+
+type VerifyJob struct {
+	State      string        `json:"state"`
+	Repair     bool          `json:"repair"`
+	Processed  int64         `json:"processed"`
+	Total      int64         `json:"total"`
+	StartedAt  int64         `json:"started_at"`
+	FinishedAt int64         `json:"finished_at,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Report     *VerifyReport `json:"report,omitempty"`
+}
+
+type VerifyRequest struct {
+	Repair bool `json:"repair"`
+}
+
+// UTXOSetInfo utxo集合承诺 muhash与gettxoutsetinfo muhash一致(显示字节序)
+type UTXOSetInfo struct {
+	Height      int64  `json:"height"`
+	TxOuts      uint64 `json:"txouts"`
+	TotalAmount string `json:"total_amount"`
+	MuHash      string `json:"muhash"`
+}
+
+type UTXOSetReply struct {
+	*UTXOSetInfo
+	Node  *UTXOSetInfo `json:"node,omitempty"`  //compare=true时返回节点gettxoutsetinfo的结果
+	Diffs []string     `json:"diffs,omitempty"` //与节点不一致的字段
+}
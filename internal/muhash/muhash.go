@@ -0,0 +1,94 @@
+// Package muhash 实现Bitcoin Core的MuHash3072集合哈希
+// 集合元素映射为模p(p = 2^3072 - 1103717)乘法群中的元素，插入乘到分子、删除乘到分母，
+// 结果与插入删除顺序无关，可按区块增量维护UTXO集合哈希
+package muhash
+
+import (
+	"crypto/sha256"
+	"math/big"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// ByteSize 3072位数值的字节长度
+const ByteSize = 384
+
+var prime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 3072), big.NewInt(1103717))
+
+// MuHash3072 零值不可用，使用New创建
+type MuHash3072 struct {
+	numerator   *big.Int
+	denominator *big.Int
+}
+
+// New 空集合
+func New() *MuHash3072 {
+	return &MuHash3072{numerator: big.NewInt(1), denominator: big.NewInt(1)}
+}
+
+// FromBytes 恢复Bytes序列化的状态
+func FromBytes(numerator, denominator []byte) *MuHash3072 {
+	return &MuHash3072{numerator: fromLE(numerator), denominator: fromLE(denominator)}
+}
+
+// Bytes 分子、分母的小端序列化 用于持久化
+func (m *MuHash3072) Bytes() (numerator, denominator []byte) {
+	return toLE(m.numerator), toLE(m.denominator)
+}
+
+// Insert 添加元素
+func (m *MuHash3072) Insert(data []byte) {
+	m.numerator.Mul(m.numerator, toNum3072(data))
+	m.numerator.Mod(m.numerator, prime)
+}
+
+// Remove 删除元素
+func (m *MuHash3072) Remove(data []byte) {
+	m.denominator.Mul(m.denominator, toNum3072(data))
+	m.denominator.Mod(m.denominator, prime)
+}
+
+// Combine 合并另一个集合
+func (m *MuHash3072) Combine(o *MuHash3072) {
+	m.numerator.Mod(m.numerator.Mul(m.numerator, o.numerator), prime)
+	m.denominator.Mod(m.denominator.Mul(m.denominator, o.denominator), prime)
+}
+
+// Finalize 计算集合哈希 SHA256(分子/分母的小端序列化)，字节序与Core的uint256内部字节序相同
+func (m *MuHash3072) Finalize() [32]byte {
+	inv := new(big.Int).ModInverse(m.denominator, prime)
+	num := new(big.Int).Mul(m.numerator, inv)
+	num.Mod(num, prime)
+	// 约分后写回 避免分母保持为非1时每次都需要求逆
+	m.numerator, m.denominator = num, big.NewInt(1)
+	return sha256.Sum256(toLE(num))
+}
+
+// toNum3072 SHA256(data)作为ChaCha20密钥生成384字节密钥流，按小端解释为3072位数值
+func toNum3072(data []byte) *big.Int {
+	key := sha256.Sum256(data)
+	cipher, err := chacha20.NewUnauthenticatedCipher(key[:], make([]byte, chacha20.NonceSize))
+	if err != nil {
+		panic(err)
+	}
+	buf := make([]byte, ByteSize)
+	cipher.XORKeyStream(buf, buf)
+	return fromLE(buf)
+}
+
+func fromLE(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+func toLE(n *big.Int) []byte {
+	b := make([]byte, ByteSize)
+	n.FillBytes(b)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"go.uber.org/zap"
+)
+
+// Client 节点JSON-RPC客户端 请求经本地转发代理发送
+type Client struct {
+	*rpcclient.Client
+	check *rpcclient.Client //健康检查用 请求超过checkTimeout由代理取消
+	proxy *http.Server
+}
+
+// NewClient 创建节点JSON-RPC客户端
+// user/password为空时使用cookie文件认证，rpcclient在文件修改后重新读取(节点重启会重新生成cookie)
+// rpcclient不支持超时、客户端证书及自定义ServerName，请求经本地转发代理发送，由代理建立(m)TLS连接并取消超时的请求
+func NewClient(conf *config.RPCEndpoint, logger *zap.Logger) (*Client, error) {
+	if len(conf.User) == 0 && len(conf.Password) == 0 && len(conf.CookieFile) == 0 {
+		return nil, fmt.Errorf("rpc %s: user/password or cookie_file required", conf.URL)
+	}
+
+	var tlsConf *tls.Config
+	if conf.TLS != nil && conf.TLS.Enabled {
+		var err error
+		tlsConf, err = pkg.NewClientTLSConfig(conf.TLS.CAFile, conf.TLS.CertFile, conf.TLS.KeyFile, conf.TLS.ServerName)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c := &Client{}
+	proxy, host, err := startProxy(conf.URL, tlsConf, logger)
+	if err != nil {
+		return nil, err
+	}
+	c.proxy = proxy
+
+	connCfg := &rpcclient.ConnConfig{
+		Host:         host,
+		User:         conf.User,
+		Pass:         conf.Password,
+		CookiePath:   conf.CookieFile,
+		HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
+		DisableTLS:   true, // 代理监听明文HTTP
+	}
+	if c.Client, err = rpcclient.New(connCfg, nil); err != nil {
+		c.Shutdown()
+		return nil, err
+	}
+	checkCfg := *connCfg
+	checkCfg.ExtraHeaders = map[string]string{proxyTimeoutHeader: checkTimeout.String()}
+	if c.check, err = rpcclient.New(&checkCfg, nil); err != nil {
+		c.Shutdown()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Shutdown 关闭客户端及转发代理
+func (c *Client) Shutdown() {
+	if c.Client != nil {
+		c.Client.Shutdown()
+	}
+	if c.check != nil {
+		c.check.Shutdown()
+	}
+	if c.proxy != nil {
+		c.proxy.Close()
+	}
+}
@@ -0,0 +1,66 @@
+package rpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+// Node 索引及接口使用的节点RPC方法 *rpcclient.Client与*Pool均实现该接口
+type Node interface {
+	GetBlockCount() (int64, error)
+	GetBlockHash(height int64) (*chainhash.Hash, error)
+	GetBlock(hash *chainhash.Hash) (*wire.MsgBlock, error)
+	GetBlockVerboseTx(hash *chainhash.Hash) (*btcjson.GetBlockVerboseTxResult, error)
+	GetRawTransaction(hash *chainhash.Hash) (*btcutil.Tx, error)
+	GetTxOut(hash *chainhash.Hash, index uint32, mempool bool) (*btcjson.GetTxOutResult, error)
+	SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (*chainhash.Hash, error)
+	RawRequest(method string, params []json.RawMessage) (json.RawMessage, error)
+}
+
+// TxOutSetInfo gettxoutsetinfo muhash的结果
+type TxOutSetInfo struct {
+	Height      int64   `json:"height"`
+	BestBlock   string  `json:"bestblock"`
+	TxOuts      uint64  `json:"txouts"`
+	MuHash      string  `json:"muhash"`
+	TotalAmount float64 `json:"total_amount"`
+}
+
+// GetTxOutSetInfo 查询节点指定高度的utxo集合哈希 非最新高度需要节点开启-coinstatsindex
+func GetTxOutSetInfo(node Node, height int64) (*TxOutSetInfo, error) {
+	params := []json.RawMessage{json.RawMessage(`"muhash"`), json.RawMessage(strconv.FormatInt(height, 10))}
+	res, err := node.RawRequest("gettxoutsetinfo", params)
+	if err != nil {
+		return nil, err
+	}
+	info := &TxOutSetInfo{}
+	if err := json.Unmarshal(res, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Diff 与索引的utxo集合哈希比较 返回不一致的字段
+func (i *TxOutSetInfo) Diff(local *model.UTXOSetInfo) []string {
+	var diff []string
+	if i.Height != local.Height {
+		diff = append(diff, fmt.Sprintf("height: node %d, index %d", i.Height, local.Height))
+	}
+	if i.TxOuts != local.TxOuts {
+		diff = append(diff, fmt.Sprintf("txouts: node %d, index %d", i.TxOuts, local.TxOuts))
+	}
+	if total := fmt.Sprintf("%.8f", i.TotalAmount); total != local.TotalAmount {
+		diff = append(diff, fmt.Sprintf("total_amount: node %s, index %s", total, local.TotalAmount))
+	}
+	if i.MuHash != local.MuHash {
+		diff = append(diff, fmt.Sprintf("muhash: node %s, index %s", i.MuHash, local.MuHash))
+	}
+	return diff
+}
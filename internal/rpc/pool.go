@@ -0,0 +1,329 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultMaxLag         = 3
+	checkTimeout          = 5 * time.Second //健康检查单次请求超时时间
+)
+
+type endpoint struct {
+	url     string
+	client  *Client
+	healthy bool
+	height  int64
+	err     error
+}
+
+// Pool 多节点RPC 按配置顺序使用第一个健康节点，请求失败时切换到下一个健康节点
+// 定时检查各节点高度及共同高度的区块哈希，落后过多或哈希与多数节点不一致的节点不参与请求
+type Pool struct {
+	logger    *zap.Logger
+	interval  time.Duration
+	maxLag    int64
+	endpoints []*endpoint
+
+	mu     sync.RWMutex
+	active int
+}
+
+var _ Node = (*Pool)(nil)
+
+// NewPool 创建节点池 需调用Start启动健康检查
+func NewPool(conf *config.BitcoinRPCConfig, logger *zap.Logger) (*Pool, error) {
+	list := conf.EndpointList()
+	if len(list) == 0 {
+		return nil, fmt.Errorf("rpc endpoint required")
+	}
+	p := &Pool{
+		logger:    logger,
+		interval:  time.Duration(conf.HealthInterval) * time.Second,
+		maxLag:    conf.MaxLag,
+		endpoints: make([]*endpoint, 0, len(list)),
+	}
+	if p.interval <= 0 {
+		p.interval = defaultHealthInterval
+	}
+	if p.maxLag <= 0 {
+		p.maxLag = defaultMaxLag
+	}
+	for _, ep := range list {
+		client, err := NewClient(ep, logger)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints = append(p.endpoints, &endpoint{url: ep.URL, client: client, healthy: true})
+	}
+	return p, nil
+}
+
+// Start 执行一次健康检查并在后台定时检查 ctx取消后停止
+func (p *Pool) Start(ctx context.Context) {
+	p.Check()
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.Check()
+			}
+		}
+	}()
+}
+
+// Shutdown 关闭所有节点连接及转发代理
+func (p *Pool) Shutdown() {
+	for _, e := range p.endpoints {
+		e.client.Shutdown()
+	}
+}
+
+// Check 检查所有节点的高度及区块哈希一致性并选出当前节点
+func (p *Pool) Check() {
+	type state struct {
+		height int64
+		err    error
+	}
+	states := make([]state, len(p.endpoints))
+	var wg sync.WaitGroup
+	for idx, e := range p.endpoints {
+		wg.Add(1)
+		go func(idx int, e *endpoint) {
+			defer wg.Done()
+			h, err := e.client.check.GetBlockCount()
+			states[idx] = state{h, err}
+		}(idx, e)
+	}
+	wg.Wait()
+
+	var best int64
+	for _, st := range states {
+		if st.err == nil && st.height > best {
+			best = st.height
+		}
+	}
+	// 共同高度取未落后节点的最低高度，避免比较各节点尚未同步的最新区块
+	common := best
+	for idx, st := range states {
+		if st.err == nil && best-st.height > p.maxLag {
+			states[idx].err = fmt.Errorf("lags best height %d by %d blocks", best, best-st.height)
+		} else if st.err == nil && st.height < common {
+			common = st.height
+		}
+	}
+
+	// 按区块哈希分组 取节点数最多的组，数量相同时取优先级高的组
+	hashes := make([]string, len(p.endpoints))
+	groups := make(map[string]int)
+	for idx, e := range p.endpoints {
+		if states[idx].err != nil || len(p.endpoints) == 1 {
+			continue
+		}
+		hash, err := e.client.check.GetBlockHash(common)
+		if err != nil {
+			states[idx].err = err
+			continue
+		}
+		hashes[idx] = hash.String()
+		groups[hashes[idx]]++
+	}
+	var major string
+	for idx := range p.endpoints {
+		if h := hashes[idx]; len(h) > 0 && (len(major) == 0 || groups[h] > groups[major]) {
+			major = h
+		}
+	}
+	for idx, h := range hashes {
+		if len(h) > 0 && h != major {
+			states[idx].err = fmt.Errorf("block hash mismatch at height %d: %s != %s", common, h, major)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	active := -1
+	for idx, e := range p.endpoints {
+		e.height, e.err = states[idx].height, states[idx].err
+		if e.healthy != (e.err == nil) {
+			if e.err != nil {
+				p.logger.Warn("RPC::Check", zap.String("endpoint", e.url), zap.Error(e.err))
+			} else {
+				p.logger.Info("RPC::Check", zap.String("endpoint", e.url), zap.String("status", "recovered"))
+			}
+		}
+		e.healthy = e.err == nil
+		if e.healthy {
+			metrics.RPCEndpointUp.WithLabelValues(e.url).Set(1)
+			if active < 0 {
+				active = idx
+			}
+		} else {
+			metrics.RPCEndpointUp.WithLabelValues(e.url).Set(0)
+		}
+	}
+	if active >= 0 {
+		p.setActive(active)
+	}
+}
+
+// setActive 调用方需持有锁
+func (p *Pool) setActive(idx int) {
+	if p.active == idx {
+		return
+	}
+	p.logger.Warn("RPC::Failover",
+		zap.String("from", p.endpoints[p.active].url),
+		zap.String("to", p.endpoints[idx].url))
+	metrics.RPCFailovers.Inc()
+	p.active = idx
+}
+
+// candidates 当前节点优先，其次按优先级排列的其他健康节点；全部不健康时依次尝试所有节点
+func (p *Pool) candidates() []*endpoint {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	list := make([]*endpoint, 0, len(p.endpoints))
+	if p.endpoints[p.active].healthy {
+		list = append(list, p.endpoints[p.active])
+	}
+	for idx, e := range p.endpoints {
+		if idx != p.active && e.healthy {
+			list = append(list, e)
+		}
+	}
+	if len(list) == 0 {
+		list = append(list, p.endpoints...)
+	}
+	return list
+}
+
+func (p *Pool) markDown(e *endpoint, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e.healthy {
+		p.logger.Warn("RPC::Call", zap.String("endpoint", e.url), zap.Error(err))
+	}
+	e.healthy, e.err = false, err
+	metrics.RPCEndpointUp.WithLabelValues(e.url).Set(0)
+}
+
+func (p *Pool) markActive(e *endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for idx := range p.endpoints {
+		if p.endpoints[idx] == e {
+			p.setActive(idx)
+			return
+		}
+	}
+}
+
+// do 依次在候选节点上执行 节点返回的业务错误(如交易被拒绝)直接返回不切换节点
+func (p *Pool) do(fn func(c *rpcclient.Client) error) error {
+	var err error
+	for n, e := range p.candidates() {
+		err = fn(e.client.Client)
+		if err == nil || !shouldFailover(err) {
+			if n > 0 && err == nil {
+				p.markActive(e)
+			}
+			return err
+		}
+		p.markDown(e, err)
+	}
+	return err
+}
+
+// shouldFailover 连接失败、认证失败等非节点业务错误，以及节点启动中(-28)需要切换节点
+func shouldFailover(err error) bool {
+	var rerr *btcjson.RPCError
+	if errors.As(err, &rerr) {
+		return rerr.Code == btcjson.ErrRPCInWarmup
+	}
+	return true
+}
+
+func (p *Pool) GetBlockCount() (height int64, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		height, err = c.GetBlockCount()
+		return err
+	})
+	return
+}
+
+func (p *Pool) GetBlockHash(height int64) (hash *chainhash.Hash, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		hash, err = c.GetBlockHash(height)
+		return err
+	})
+	return
+}
+
+func (p *Pool) GetBlock(hash *chainhash.Hash) (block *wire.MsgBlock, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		block, err = c.GetBlock(hash)
+		return err
+	})
+	return
+}
+
+func (p *Pool) GetBlockVerboseTx(hash *chainhash.Hash) (res *btcjson.GetBlockVerboseTxResult, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		res, err = c.GetBlockVerboseTx(hash)
+		return err
+	})
+	return
+}
+
+func (p *Pool) GetRawTransaction(hash *chainhash.Hash) (tx *btcutil.Tx, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		tx, err = c.GetRawTransaction(hash)
+		return err
+	})
+	return
+}
+
+func (p *Pool) GetTxOut(hash *chainhash.Hash, index uint32, mempool bool) (res *btcjson.GetTxOutResult, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		res, err = c.GetTxOut(hash, index, mempool)
+		return err
+	})
+	return
+}
+
+// SendRawTransaction 广播成功或被节点拒绝都不会在其他节点重试
+func (p *Pool) SendRawTransaction(tx *wire.MsgTx, allowHighFees bool) (hash *chainhash.Hash, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		hash, err = c.SendRawTransaction(tx, allowHighFees)
+		return err
+	})
+	return
+}
+
+func (p *Pool) RawRequest(method string, params []json.RawMessage) (res json.RawMessage, err error) {
+	err = p.do(func(c *rpcclient.Client) error {
+		res, err = c.RawRequest(method, params)
+		return err
+	})
+	return
+}
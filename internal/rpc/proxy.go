@@ -0,0 +1,73 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+	// proxyTimeoutHeader 请求超时时间 代理取消超时的请求并返回504，不转发给节点
+	proxyTimeoutHeader = "X-Proxy-Timeout"
+)
+
+// startProxy 在本地回环地址监听明文HTTP并转发到节点，tlsConf不为nil时通过TLS转发 返回代理服务及监听地址，关闭服务时一并关闭监听
+// 节点不可达时代理立即返回502，避免rpcclient对连接错误内置的10次退避重试(累计约22s)阻塞节点切换
+func startProxy(target string, tlsConf *tls.Config, logger *zap.Logger) (*http.Server, string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+
+	scheme := "http"
+	if tlsConf != nil {
+		scheme = "https"
+	}
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: scheme, Host: target})
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = target
+	}
+	proxy.Transport = &http.Transport{
+		Proxy:               nil, // 不使用环境变量中的HTTP代理，与rpcclient直连行为一致
+		TLSClientConfig:     tlsConf,
+		DialContext:         (&net.Dialer{Timeout: dialTimeout}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConnsPerHost: 16,
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		if errors.Is(err, context.DeadlineExceeded) {
+			w.WriteHeader(http.StatusGatewayTimeout)
+			return
+		}
+		logger.Error("RPC::Proxy", zap.String("target", target), zap.Error(err))
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if timeout, err := time.ParseDuration(req.Header.Get(proxyTimeoutHeader)); err == nil && timeout > 0 {
+			req.Header.Del(proxyTimeoutHeader)
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+			req = req.WithContext(ctx)
+		}
+		proxy.ServeHTTP(w, req)
+	})
+	srv := &http.Server{Handler: handler}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("RPC::Proxy", zap.Error(err))
+		}
+	}()
+	return srv, ln.Addr().String(), nil
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"golang.org/x/time/rate"
+)
+
+const (
+	apiKeyHeader   = "X-API-Key"
+	adminKeyHeader = "X-Admin-Key"
+	apiKeyNameKey  = "api_key_name" //认证通过后gin.Context中保存的key名称
+
+	defaultKeyTTL  = 30 * time.Second
+	missingKeyTTL  = 5 * time.Second //不存在的key的缓存时间，避免无效key每次请求都读库
+	maxMissingKeys = 10000
+)
+
+// 无需认证的路由
+var publicRoutes = map[string]struct{}{
+	"/healthz": {},
+	"/readyz":  {},
+	"/metrics": {},
+}
+
+// keyState api key的限流及配额状态
+type keyState struct {
+	name    string
+	static  bool //配置文件中的key 不可通过admin接口删除
+	limiter *rate.Limiter
+	quota   int64
+	day     int64
+	used    int64
+	checked time.Time //最近一次从数据库确认的时间
+}
+
+// authenticator api key认证 配置文件中的key启动时加载，数据库中的key首次使用时加载
+// 数据库中的key缓存ttl后重新读取，其他实例吊销的key最迟ttl后失效
+type authenticator struct {
+	conf    *config.AuthConfig
+	db      *db.DB
+	ttl     time.Duration
+	mu      sync.Mutex
+	keys    map[string]*keyState // key id -> state
+	missing map[string]time.Time // 不存在的key id -> 查询时间
+}
+
+func newAuthenticator(conf *config.AuthConfig, db *db.DB) *authenticator {
+	if conf == nil {
+		conf = &config.AuthConfig{}
+	}
+	a := &authenticator{
+		conf:    conf,
+		db:      db,
+		ttl:     time.Duration(conf.KeyTTL) * time.Second,
+		keys:    make(map[string]*keyState, len(conf.Keys)),
+		missing: make(map[string]time.Time),
+	}
+	if a.ttl <= 0 {
+		a.ttl = defaultKeyTTL
+	}
+	for _, k := range conf.Keys {
+		st := a.newState(k.Name, k.Rate, k.Burst, k.Quota)
+		st.static = true
+		a.keys[keyID(k.Key)] = st
+	}
+	return a
+}
+
+// keyID 数据库及内存中只保存api key的sha256
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *authenticator) newState(name string, r float64, burst int, quota int64) *keyState {
+	if r <= 0 {
+		r = a.conf.Rate
+	}
+	if burst <= 0 {
+		burst = a.conf.Burst
+	}
+	if quota <= 0 {
+		quota = a.conf.Quota
+	}
+	limit := rate.Inf
+	if r > 0 {
+		limit = rate.Limit(r)
+		if burst <= 0 {
+			burst = int(math.Max(1, math.Ceil(r)))
+		}
+	}
+	return &keyState{
+		name:    name,
+		limiter: rate.NewLimiter(limit, burst),
+		quota:   quota,
+	}
+}
+
+func (a *authenticator) lookup(id string) (*keyState, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	st, ok := a.keys[id]
+	if ok && (st.static || now.Sub(st.checked) < a.ttl) {
+		return st, nil
+	}
+	if t, ok := a.missing[id]; ok && now.Sub(t) < a.missingTTL() {
+		return nil, nil
+	}
+
+	ak, err := a.db.GetAPIKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if ak == nil {
+		delete(a.keys, id)
+		if len(a.missing) >= maxMissingKeys {
+			a.missing = make(map[string]time.Time)
+		}
+		a.missing[id] = now
+		return nil, nil
+	}
+	delete(a.missing, id)
+	// 已缓存的key保留限流及配额状态
+	if st == nil {
+		st = a.newState(ak.Name, ak.Rate, int(ak.Burst), ak.Quota)
+		a.keys[id] = st
+	}
+	st.checked = now
+	return st, nil
+}
+
+func (a *authenticator) missingTTL() time.Duration {
+	if a.ttl < missingKeyTTL {
+		return a.ttl
+	}
+	return missingKeyTTL
+}
+
+// consume 扣减每日配额 按UTC自然日重置
+func (a *authenticator) consume(st *keyState) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if st.quota <= 0 {
+		return true
+	}
+	day := time.Now().UTC().Unix() / 86400
+	if st.day != day {
+		st.day, st.used = day, 0
+	}
+	if st.used >= st.quota {
+		return false
+	}
+	st.used++
+	return true
+}
+
+func (a *authenticator) forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.keys, id)
+	delete(a.missing, id)
+}
+
+func (a *authenticator) isStatic(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st, ok := a.keys[id]
+	return ok && st.static
+}
+
+// requestKey 依次读取 X-API-Key、Authorization: Bearer、api_key查询参数
+func requestKey(ctx *gin.Context) string {
+	if key := ctx.GetHeader(apiKeyHeader); key != "" {
+		return key
+	}
+	if auth := ctx.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ctx.Query("api_key")
+}
+
+// middleware api key认证、令牌桶限流及每日配额
+func (a *authenticator) middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !a.conf.Enabled || ctx.Request.Method == http.MethodOptions {
+			ctx.Next()
+			return
+		}
+		path := ctx.Request.URL.Path
+		if _, ok := publicRoutes[path]; ok || strings.HasPrefix(path, "/admin/") {
+			ctx.Next()
+			return
+		}
+
+		key := requestKey(ctx)
+		if key == "" {
+			abortError(ctx, http.StatusUnauthorized, fmt.Errorf("api key required"))
+			return
+		}
+		st, err := a.lookup(keyID(key))
+		if err != nil {
+			abortError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if st == nil {
+			abortError(ctx, http.StatusUnauthorized, fmt.Errorf("invalid api key"))
+			return
+		}
+
+		if r := st.limiter.Reserve(); !r.OK() || r.Delay() > 0 {
+			if r.OK() {
+				ctx.Header("Retry-After", fmt.Sprintf("%d", int(math.Ceil(r.Delay().Seconds()))))
+				r.Cancel()
+			}
+			abortError(ctx, http.StatusTooManyRequests, fmt.Errorf("rate limit exceeded"))
+			return
+		}
+		if !a.consume(st) {
+			abortError(ctx, http.StatusTooManyRequests, fmt.Errorf("daily quota exceeded"))
+			return
+		}
+		ctx.Set(apiKeyNameKey, st.name)
+		ctx.Next()
+	}
+}
+
+// adminMiddleware admin接口使用配置中的admin_key认证 未配置时禁用
+func (a *authenticator) adminMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if a.conf.AdminKey == "" {
+			abortError(ctx, http.StatusForbidden, fmt.Errorf("admin api disabled"))
+			return
+		}
+		key := ctx.GetHeader(adminKeyHeader)
+		if subtle.ConstantTimeCompare([]byte(key), []byte(a.conf.AdminKey)) != 1 {
+			abortError(ctx, http.StatusUnauthorized, fmt.Errorf("invalid admin key"))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+func abortError(ctx *gin.Context, status int, err error) {
+	ctx.AbortWithStatusJSON(status, gin.H{
+		"code": status,
+		"msg":  err.Error(),
+	})
+}
+
+// createKeyHandle POST /admin/keys 生成新的api key，明文只在创建时返回一次
+func (s *Server) createKeyHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var req model.APIKeyRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		if req.Name == "" || req.Rate < 0 || req.Burst < 0 || req.Quota < 0 {
+			replyError(ctx, http.StatusBadRequest, fmt.Errorf("invalid key params"))
+			return
+		}
+
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		key := hex.EncodeToString(buf)
+		id := keyID(key)
+		ak := &db.ApiKey{
+			Name:      req.Name,
+			Rate:      req.Rate,
+			Burst:     int32(req.Burst),
+			Quota:     req.Quota,
+			CreatedAt: time.Now().Unix(),
+		}
+		if err := s.db.PutAPIKey(id, ak); err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		replyData(ctx, &model.APIKeyReply{
+			ID:        id,
+			Key:       key,
+			Name:      ak.Name,
+			Rate:      ak.Rate,
+			Burst:     int(ak.Burst),
+			Quota:     ak.Quota,
+			CreatedAt: ak.CreatedAt,
+		})
+	}
+}
+
+// listKeysHandle GET /admin/keys 列出数据库中的key(不含明文)
+func (s *Server) listKeysHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		keys, err := s.db.ListAPIKeys()
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		list := make([]*model.APIKeyReply, 0, len(keys))
+		for id, ak := range keys {
+			list = append(list, &model.APIKeyReply{
+				ID:        id,
+				Name:      ak.Name,
+				Rate:      ak.Rate,
+				Burst:     int(ak.Burst),
+				Quota:     ak.Quota,
+				CreatedAt: ak.CreatedAt,
+			})
+		}
+		replyData(ctx, list)
+	}
+}
+
+// revokeKeyHandle DELETE /admin/keys/:id 本实例立即生效，共享数据库的其他实例最迟key_ttl后生效
+func (s *Server) revokeKeyHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		id := ctx.Param("id")
+		if s.auth.isStatic(id) {
+			replyError(ctx, http.StatusBadRequest, fmt.Errorf("key %s is configured in yaml", id))
+			return
+		}
+		ak, err := s.db.GetAPIKey(id)
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if ak == nil {
+			replyError(ctx, http.StatusNotFound, fmt.Errorf("key not found:%s", id))
+			return
+		}
+		if err := s.db.DeleteAPIKey(id); err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		s.auth.forget(id)
+		replyData(ctx, id)
+	}
+}
@@ -1,10 +1,20 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
 	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/wallet"
 )
 
 const defaultPageSize = 50
@@ -23,8 +33,13 @@ func (s *Server) utxoHandle() func(ctx *gin.Context) {
 			req.PageSize = defaultPageSize
 		}
 
-		reply, err := s.db.GetUTXOByAddress(req.Address, req.Page, req.PageSize)
-		if err != nil {
+		reply, err := s.db.GetUTXOByAddress(&req)
+		if isBadRequest(err) {
+			ctx.JSON(http.StatusOK, gin.H{
+				"code": http.StatusBadRequest,
+				"msg":  err.Error(),
+			})
+		} else if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
 				"code": http.StatusInternalServerError,
 				"msg":  err.Error(),
@@ -52,6 +67,7 @@ func (s *Server) heightHandle() func(ctx *gin.Context) {
 
 		nheight, err := s.rpc.GetBlockCount()
 		if err != nil {
+			metrics.RPCErrors.WithLabelValues("getblockcount").Inc()
 			ctx.JSON(http.StatusInternalServerError, gin.H{
 				"code": http.StatusInternalServerError,
 				"msg":  err.Error(),
@@ -94,3 +110,209 @@ func (s *Server) utxoInfoHandle() func(ctx *gin.Context) {
 		}
 	}
 }
+
+// cacheMaxAge GET结果可被客户端/CDN缓存的秒数 新块到达后缓存最多滞后30秒，过期后凭ETag重新验证
+const cacheMaxAge = 30
+
+// setCacheHeaders 根据存储高度设置ETag/Cache-Control，命中If-None-Match时返回true
+func setCacheHeaders(ctx *gin.Context, storeHeight int64) bool {
+	return setETag(ctx, fmt.Sprintf(`"h%d"`, storeHeight))
+}
+
+// setETag 经api key认证的请求只允许客户端缓存，避免共享缓存/CDN把结果返回给没有key的请求而绕过认证及限流
+func setETag(ctx *gin.Context, etag string) bool {
+	ctx.Header("ETag", etag)
+	if _, ok := ctx.Get(apiKeyNameKey); ok {
+		ctx.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", cacheMaxAge))
+		ctx.Header("Vary", "X-API-Key, Authorization")
+	} else {
+		ctx.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", cacheMaxAge))
+	}
+	if match := ctx.GetHeader("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == etag || tag == "W/"+etag || tag == "*" {
+				ctx.Status(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// replyError 返回真实的HTTP状态码
+func replyError(ctx *gin.Context, status int, err error) {
+	ctx.JSON(status, gin.H{
+		"code": status,
+		"msg":  err.Error(),
+	})
+}
+
+func replyData(ctx *gin.Context, data interface{}) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"code": http.StatusOK,
+		"data": data,
+	})
+}
+
+// getHeightHandle GET /height
+func (s *Server) getHeightHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		sheight, err := s.db.GetStoreHeight()
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		nheight, err := s.rpc.GetBlockCount()
+		if err != nil {
+			metrics.RPCErrors.WithLabelValues("getblockcount").Inc()
+			replyError(ctx, http.StatusBadGateway, err)
+			return
+		}
+		// 返回内容包含节点高度 ETag需同时包含两个高度
+		if setETag(ctx, fmt.Sprintf(`"h%d-n%d"`, sheight, nheight)) {
+			return
+		}
+
+		replyData(ctx, model.HeightReply{
+			StoreHeight: sheight,
+			NodeHeight:  nheight,
+		})
+	}
+}
+
+// getAddressUtxoHandle GET /address/:addr/utxo?page=&page_size=&cursor=
+// 过滤排序参数: min_value max_value min_conf dust_threshold sort_by order
+func (s *Server) getAddressUtxoHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		address := ctx.Param("addr")
+		if _, err := btcutil.DecodeAddress(address, &chaincfg.MainNetParams); err != nil {
+			replyError(ctx, http.StatusBadRequest, fmt.Errorf("invalid address:%s", address))
+			return
+		}
+		req, err := bindUTXOQuery(ctx)
+		if err != nil {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		req.Address = address
+
+		sheight, err := s.db.GetStoreHeight()
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if setCacheHeaders(ctx, sheight) {
+			return
+		}
+
+		reply, err := s.db.GetUTXOByAddress(req)
+		if isBadRequest(err) {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		replyData(ctx, reply)
+	}
+}
+
+// getOutpointHandle GET /outpoint/:txid/:vout
+func (s *Server) getOutpointHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		txid := ctx.Param("txid")
+		if _, err := chainhash.NewHashFromStr(txid); err != nil || len(txid) != chainhash.MaxHashStringSize {
+			replyError(ctx, http.StatusBadRequest, fmt.Errorf("invalid txid:%s", txid))
+			return
+		}
+		vout, err := strconv.ParseUint(ctx.Param("vout"), 10, 32)
+		if err != nil {
+			replyError(ctx, http.StatusBadRequest, fmt.Errorf("invalid vout:%s", ctx.Param("vout")))
+			return
+		}
+
+		sheight, err := s.db.GetStoreHeight()
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if setCacheHeaders(ctx, sheight) {
+			return
+		}
+
+		key := fmt.Sprintf("%s:%d", txid, vout)
+		reply, err := s.db.GetUTXOInfoByKeys([]string{key})
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		info := reply[key]
+		if info == nil {
+			replyError(ctx, http.StatusNotFound, fmt.Errorf("outpoint not found:%s", key))
+			return
+		}
+		replyData(ctx, info)
+	}
+}
+
+// bindUTXOQuery 解析GET查询参数
+func bindUTXOQuery(ctx *gin.Context) (*model.UTXORequest, error) {
+	req := &model.UTXORequest{
+		Cursor: ctx.Query("cursor"),
+		SortBy: ctx.Query("sort_by"),
+		Order:  ctx.Query("order"),
+	}
+	var err error
+	if req.Page, err = queryInt(ctx, "page", 0); err != nil {
+		return nil, err
+	}
+	if req.PageSize, err = queryInt(ctx, "page_size", defaultPageSize); err != nil {
+		return nil, err
+	}
+	minConf, err := queryInt(ctx, "min_conf", 0)
+	if err != nil {
+		return nil, err
+	}
+	req.MinConf = int64(minConf)
+	if req.MinValue, err = queryFloat(ctx, "min_value"); err != nil {
+		return nil, err
+	}
+	if req.MaxValue, err = queryFloat(ctx, "max_value"); err != nil {
+		return nil, err
+	}
+	if req.DustThreshold, err = queryFloat(ctx, "dust_threshold"); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func queryInt(ctx *gin.Context, name string, def int) (int, error) {
+	val := ctx.Query(name)
+	if val == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s:%s", name, val)
+	}
+	return n, nil
+}
+
+func queryFloat(ctx *gin.Context, name string) (float64, error) {
+	val := ctx.Query(name)
+	if val == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid %s:%s", name, val)
+	}
+	return f, nil
+}
+
+func isBadRequest(err error) bool {
+	return errors.Is(err, db.ErrInvalidCursor) || errors.Is(err, db.ErrInvalidQuery) ||
+		errors.Is(err, errInvalidParam) || errors.Is(err, wallet.ErrInsufficientFunds)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+const (
+	// defaultMaxLag 默认允许落后节点的区块数
+	defaultMaxLag = 3
+
+	// healthCheckTimeout 节点检查超时时间
+	healthCheckTimeout = 3 * time.Second
+)
+
+// healthzHandle 存活检查 仅检查数据库是否可读，节点不可用时不应重启indexer
+func (s *Server) healthzHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		reply := &model.HealthReply{Status: model.HealthOK, Checks: map[string]*model.HealthCheck{}}
+		if _, err := s.db.GetStoreHeight(); err != nil {
+			reply.Status = model.HealthFail
+			reply.Checks["db"] = &model.HealthCheck{Error: err.Error()}
+		} else {
+			reply.Checks["db"] = &model.HealthCheck{OK: true}
+		}
+		writeHealth(ctx, reply)
+	}
+}
+
+// readyzHandle 就绪检查 数据库、节点均可用且存储高度落后节点不超过max_lag
+func (s *Server) readyzHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		reply := &model.HealthReply{Status: model.HealthOK, Checks: map[string]*model.HealthCheck{}}
+		fail := func(name string, err error) {
+			reply.Status = model.HealthFail
+			reply.Checks[name] = &model.HealthCheck{Error: err.Error()}
+		}
+
+		sheight, dbErr := s.db.GetStoreHeight()
+		if dbErr != nil {
+			fail("db", dbErr)
+		} else {
+			reply.Checks["db"] = &model.HealthCheck{OK: true}
+		}
+
+		nheight, rpcErr := s.nodeHeight()
+		if rpcErr != nil {
+			fail("rpc", rpcErr)
+		} else {
+			reply.Checks["rpc"] = &model.HealthCheck{OK: true}
+		}
+
+		if dbErr == nil && rpcErr == nil {
+			maxLag := s.conf.MaxLag
+			if maxLag <= 0 {
+				maxLag = defaultMaxLag
+			}
+			lag := nheight - sheight
+			check := &model.HealthCheck{OK: lag <= maxLag, StoreHeight: sheight, NodeHeight: nheight, Lag: lag, MaxLag: maxLag}
+			if !check.OK {
+				reply.Status = model.HealthFail
+				check.Error = fmt.Sprintf("store height lags node by %d blocks", lag)
+			}
+			reply.Checks["lag"] = check
+		}
+		writeHealth(ctx, reply)
+	}
+}
+
+// nodeHeight 带超时的节点高度查询
+func (s *Server) nodeHeight() (int64, error) {
+	type result struct {
+		height int64
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		h, err := s.rpc.GetBlockCount()
+		ch <- result{h, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			metrics.RPCErrors.WithLabelValues("getblockcount").Inc()
+		}
+		return r.height, r.err
+	case <-time.After(healthCheckTimeout):
+		metrics.RPCErrors.WithLabelValues("getblockcount").Inc()
+		return 0, fmt.Errorf("getblockcount timeout after %s", healthCheckTimeout)
+	}
+}
+
+func writeHealth(ctx *gin.Context, reply *model.HealthReply) {
+	status := http.StatusOK
+	if reply.Status != model.HealthOK {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.JSON(status, reply)
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/wx-shi/utxo-indexer/internal/config"
 	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
+	"github.com/wx-shi/utxo-indexer/internal/verifier"
 	"github.com/wx-shi/utxo-indexer/pkg"
 	"go.uber.org/zap"
 )
@@ -30,21 +34,27 @@ const (
 )
 
 type Server struct {
-	conf   *config.ServerConfig
-	logger *zap.Logger
-	db     *db.DB
-	rpc    *rpcclient.Client
-	engine *gin.Engine
-	hs     *http.Server
+	conf      *config.ServerConfig
+	logger    *zap.Logger
+	db        *db.DB
+	rpc       rpc.Node
+	engine    *gin.Engine
+	hs        *http.Server
+	auth      *authenticator
+	verifier  *verifier.Verifier
+	compactor *compactor
 }
 
-func NewServer(conf *config.ServerConfig, logger *zap.Logger, db *db.DB, rpc *rpcclient.Client) *Server {
+func NewServer(conf *config.ServerConfig, logger *zap.Logger, db *db.DB, rpc rpc.Node) *Server {
 
 	s := &Server{
-		conf:   conf,
-		logger: logger,
-		db:     db,
-		rpc:    rpc,
+		conf:      conf,
+		logger:    logger,
+		db:        db,
+		rpc:       rpc,
+		auth:      newAuthenticator(conf.Auth, db),
+		verifier:  verifier.New(db, logger),
+		compactor: &compactor{db: db, logger: logger},
 	}
 
 	s.initGin()
@@ -54,14 +64,56 @@ func NewServer(conf *config.ServerConfig, logger *zap.Logger, db *db.DB, rpc *rp
 func (s *Server) initGin() {
 	gin.SetMode(gin.ReleaseMode)
 	engine := gin.New()
-	engine.Use(pkg.LogMiddleware(s.logger), pkg.CORSMiddleware(), gin.Recovery())
+	var allowOrigins []string
+	if s.conf.CORS != nil {
+		allowOrigins = s.conf.CORS.AllowOrigins
+	}
+	engine.Use(pkg.LogMiddleware(s.logger), metricsMiddleware(), pkg.CORSMiddleware(allowOrigins), gin.Recovery(), s.auth.middleware())
 
 	engine.POST("utxo", s.utxoHandle())
 	engine.POST("utxo_info", s.utxoInfoHandle())
 	engine.POST("height", s.heightHandle())
+	engine.POST("coin_select", s.coinSelectHandle())
+	engine.POST("psbt", s.psbtHandle())
+	engine.POST("tx/broadcast", s.broadcastHandle())
+
+	engine.GET("metrics", gin.WrapH(promhttp.Handler()))
+	engine.GET("healthz", s.healthzHandle())
+	engine.GET("readyz", s.readyzHandle())
+
+	admin := engine.Group("admin", s.auth.adminMiddleware())
+	admin.POST("keys", s.createKeyHandle())
+	admin.GET("keys", s.listKeysHandle())
+	admin.DELETE("keys/:id", s.revokeKeyHandle())
+	admin.POST("verify", s.startVerifyHandle())
+	admin.GET("verify", s.verifyStatusHandle())
+	admin.DELETE("verify", s.cancelVerifyHandle())
+	admin.GET("stats", s.statsHandle())
+	admin.POST("compact", s.startCompactHandle())
+	admin.GET("compact", s.compactStatusHandle())
+
+	engine.GET("height", s.getHeightHandle())
+	engine.GET("address/:addr/utxo", s.getAddressUtxoHandle())
+	engine.GET("outpoint/:txid/:vout", s.getOutpointHandle())
+	engine.GET("utxoset", s.getUTXOSetHandle())
 	s.engine = engine
 }
 
+// metricsMiddleware 按路由、方法及状态码记录请求耗时
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPDuration.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
 func (s *Server) Run() {
 	addr := fmt.Sprintf("%s:%d", s.conf.Host, s.conf.Port)
 	hs := &http.Server{
@@ -73,13 +125,33 @@ func (s *Server) Run() {
 	}
 	s.hs = hs
 
+	if s.conf.TLS != nil {
+		tlsConf, err := pkg.NewServerTLSConfig(s.conf.TLS.CertFile, s.conf.TLS.KeyFile,
+			s.conf.TLS.ClientCAFile, s.conf.TLS.RequireClientCert)
+		if err != nil {
+			s.logger.Fatal("tls", zap.Error(err))
+		}
+		hs.TLSConfig = tlsConf
+	}
+
 	go func() {
-		if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if hs.TLSConfig != nil {
+			err = hs.ListenAndServeTLS("", "")
+		} else {
+			err = hs.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			s.logger.Fatal("listen", zap.Error(err))
 		}
 	}()
-	s.logger.Info("listen", zap.String("addr", addr))
+	s.logger.Info("listen", zap.String("addr", addr), zap.Bool("tls", hs.TLSConfig != nil))
+
+}
 
+// Handler 返回路由 便于测试
+func (s *Server) Handler() http.Handler {
+	return s.engine
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultStatsTop = 10
+	maxStatsTop     = 1000
+)
+
+// errCompactRunning 已有压缩任务在运行
+var errCompactRunning = errors.New("compact job is already running")
+
+// compactor 后台压缩任务 同一时间只运行一个，保留最近一次任务的状态
+// 压缩不持有数据库锁，索引同步及查询照常进行
+type compactor struct {
+	db     *db.DB
+	logger *zap.Logger
+
+	mu  sync.Mutex
+	job *model.CompactJob
+}
+
+func (c *compactor) start() (*model.CompactJob, error) {
+	if err := c.db.CanCompact(); err != nil {
+		return nil, err
+	}
+	before, err := c.db.DiskSize()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.job != nil && c.job.State == model.CompactRunning {
+		return nil, errCompactRunning
+	}
+	c.job = &model.CompactJob{
+		State:      model.CompactRunning,
+		Done:       []string{},
+		SizeBefore: before,
+		StartedAt:  time.Now().Unix(),
+	}
+	job := *c.job
+
+	go c.run()
+	return &job, nil
+}
+
+func (c *compactor) status() *model.CompactJob {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.job == nil {
+		return nil
+	}
+	job := *c.job
+	job.Done = append([]string{}, c.job.Done...)
+	return &job
+}
+
+func (c *compactor) run() {
+	start := time.Now()
+	err := c.compact()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.job.FinishedAt = time.Now().Unix()
+	if err != nil {
+		c.job.State = model.CompactFailed
+		c.job.Error = err.Error()
+	} else {
+		c.job.State = model.CompactDone
+	}
+	c.logger.Info("Compact::Info", zap.String("state", c.job.State), zap.Any("size_before", c.job.SizeBefore),
+		zap.Any("size_after", c.job.SizeAfter), zap.Duration("ttl", time.Since(start)), zap.Error(err))
+}
+
+func (c *compactor) compact() error {
+	for _, name := range db.StoreNames() {
+		if err := c.db.CompactStore(name); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		c.job.Done = append(c.job.Done, name)
+		c.mu.Unlock()
+	}
+	after, err := c.db.DiskSize()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.job.SizeAfter = after
+	c.mu.Unlock()
+	return nil
+}
+
+// statsHandle GET /admin/stats?top=10 各存储按前缀的记录数、磁盘占用、utxo最多的地址及后端统计
+// 需遍历全部数据，主网数据量下耗时较长
+func (s *Server) statsHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		top, err := queryInt(ctx, "top", defaultStatsTop)
+		if err != nil {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		if top > maxStatsTop {
+			top = maxStatsTop
+		}
+		stats, err := s.db.Stats(top)
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		replyData(ctx, stats)
+	}
+}
+
+// startCompactHandle POST /admin/compact 启动后台压缩
+func (s *Server) startCompactHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		job, err := s.compactor.start()
+		switch {
+		case errors.Is(err, errCompactRunning):
+			replyError(ctx, http.StatusConflict, err)
+			return
+		case errors.Is(err, db.ErrReadOnly), errors.Is(err, db.ErrCompactUnsupported):
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		case err != nil:
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"code": http.StatusAccepted,
+			"data": job,
+		})
+	}
+}
+
+// compactStatusHandle GET /admin/compact 最近一次压缩任务的进度及结果
+func (s *Server) compactStatusHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		job := s.compactor.status()
+		if job == nil {
+			replyError(ctx, http.StatusNotFound, errors.New("no compact job"))
+			return
+		}
+		replyData(ctx, job)
+	}
+}
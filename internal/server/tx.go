@@ -0,0 +1,115 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+const (
+	conflictMissing      = "missing"       //索引及节点中都不存在
+	conflictSpent        = "spent"         //已在索引的区块中花费
+	conflictMempoolSpent = "mempool_spent" //节点内存池(或尚未索引的区块)中已花费
+)
+
+// broadcastHandle 校验交易输入后通过节点sendrawtransaction广播
+func (s *Server) broadcastHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var req model.BroadcastRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		raw, err := hex.DecodeString(req.Hex)
+		if err != nil {
+			replyError(ctx, http.StatusBadRequest, fmt.Errorf("invalid hex:%v", err))
+			return
+		}
+		tx := wire.NewMsgTx(wire.TxVersion)
+		if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+			replyError(ctx, http.StatusBadRequest, fmt.Errorf("invalid transaction:%v", err))
+			return
+		}
+
+		reply := &model.BroadcastReply{TxID: tx.TxHash().String()}
+		conflicts, code, err := s.checkInputs(tx)
+		if err != nil {
+			replyError(ctx, code, err)
+			return
+		}
+		if len(conflicts) > 0 {
+			reply.Conflicts = conflicts
+			ctx.JSON(http.StatusConflict, gin.H{
+				"code": http.StatusConflict,
+				"msg":  "inputs unavailable",
+				"data": reply,
+			})
+			return
+		}
+
+		if _, err := s.rpc.SendRawTransaction(tx, false); err != nil {
+			var rpcErr *btcjson.RPCError
+			if errors.As(err, &rpcErr) {
+				ctx.JSON(http.StatusUnprocessableEntity, gin.H{
+					"code": http.StatusUnprocessableEntity,
+					"msg":  "transaction rejected",
+					"data": model.BroadcastError{
+						RPCCode:    int(rpcErr.Code),
+						RPCMessage: rpcErr.Message,
+					},
+				})
+				return
+			}
+			replyError(ctx, http.StatusBadGateway, err)
+			return
+		}
+		replyData(ctx, reply)
+	}
+}
+
+// checkInputs 检查每个输入在utxo库中存在且未花费，并通过节点gettxout排除内存池中已花费的输入
+// 索引中不存在的输入可能引用内存池中未确认的交易，以节点为准
+// 出错时返回对应的状态码：读库失败为500，节点请求失败为502
+func (s *Server) checkInputs(tx *wire.MsgTx) ([]*model.InputConflict, int, error) {
+	conflicts := make([]*model.InputConflict, 0)
+	for _, in := range tx.TxIn {
+		op := in.PreviousOutPoint
+		conflict := &model.InputConflict{
+			TxID:  op.Hash.String(),
+			Index: int(op.Index),
+		}
+
+		info, err := s.db.GetOutpoint(op.Hash.String(), op.Index)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		if info != nil && info.Spend != nil {
+			conflict.Reason = conflictSpent
+			conflict.SpentBy = fmt.Sprintf("%s:%d", info.Spend.Txid, info.Spend.Index)
+			conflicts = append(conflicts, conflict)
+			continue
+		}
+
+		out, err := s.rpc.GetTxOut(&op.Hash, op.Index, true)
+		if err != nil {
+			return nil, http.StatusBadGateway, err
+		}
+		if out != nil {
+			continue
+		}
+		if info == nil {
+			conflict.Reason = conflictMissing
+		} else {
+			conflict.Reason = conflictMempoolSpent
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts, 0, nil
+}
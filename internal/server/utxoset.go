@@ -0,0 +1,52 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/metrics"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
+)
+
+// getUTXOSetHandle GET /utxoset?compare=true 索引的utxo集合哈希，compare时与节点相同高度的gettxoutsetinfo比较
+func (s *Server) getUTXOSetHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		info, err := s.db.GetUTXOSetInfo()
+		if errors.Is(err, db.ErrUTXOSetUnavailable) {
+			replyError(ctx, http.StatusNotFound, err)
+			return
+		}
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+
+		reply := &model.UTXOSetReply{UTXOSetInfo: info}
+		if ctx.Query("compare") != "true" {
+			if setCacheHeaders(ctx, info.Height) {
+				return
+			}
+			replyData(ctx, reply)
+			return
+		}
+
+		node, err := rpc.GetTxOutSetInfo(s.rpc, info.Height)
+		if err != nil {
+			metrics.RPCErrors.WithLabelValues("gettxoutsetinfo").Inc()
+			replyError(ctx, http.StatusBadGateway, fmt.Errorf("gettxoutsetinfo: %w", err))
+			return
+		}
+		reply.Node = &model.UTXOSetInfo{
+			Height:      node.Height,
+			TxOuts:      node.TxOuts,
+			TotalAmount: fmt.Sprintf("%.8f", node.TotalAmount),
+			MuHash:      node.MuHash,
+		}
+		reply.Diffs = node.Diff(info)
+		replyData(ctx, reply)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/verifier"
+)
+
+// startVerifyHandle POST /admin/verify 启动后台一致性校验 {"repair":true}时同时修复
+func (s *Server) startVerifyHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var req model.VerifyRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		job, err := s.verifier.Start(req.Repair)
+		if errors.Is(err, verifier.ErrRunning) {
+			replyError(ctx, http.StatusConflict, err)
+			return
+		}
+		if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"code": http.StatusAccepted,
+			"data": job,
+		})
+	}
+}
+
+// verifyStatusHandle GET /admin/verify 最近一次校验任务的进度及结果
+func (s *Server) verifyStatusHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		job := s.verifier.Status()
+		if job == nil {
+			replyError(ctx, http.StatusNotFound, fmt.Errorf("no verify job"))
+			return
+		}
+		replyData(ctx, job)
+	}
+}
+
+// cancelVerifyHandle DELETE /admin/verify 取消运行中的校验任务
+func (s *Server) cancelVerifyHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		if !s.verifier.Cancel() {
+			replyError(ctx, http.StatusNotFound, fmt.Errorf("no running verify job"))
+			return
+		}
+		replyData(ctx, s.verifier.Status())
+	}
+}
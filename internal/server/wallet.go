@@ -0,0 +1,294 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/gin-gonic/gin"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/wallet"
+)
+
+var errInvalidParam = errors.New("invalid param")
+
+// coinSelectHandle 选币 从地址集合(或xpub派生的地址)中选出支付target所需的utxo
+func (s *Server) coinSelectHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var req model.CoinSelectRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		res, err := s.selectCoins(&req)
+		if isBadRequest(err) {
+			replyError(ctx, http.StatusBadRequest, err)
+		} else if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+		} else {
+			replyData(ctx, coinSelectReply(res))
+		}
+	}
+}
+
+func (s *Server) selectCoins(req *model.CoinSelectRequest) (*wallet.Result, error) {
+	if req.Target <= 0 {
+		return nil, fmt.Errorf("%w:target must be positive", errInvalidParam)
+	}
+	if req.FeeRate < 0 {
+		return nil, fmt.Errorf("%w:fee_rate must not be negative", errInvalidParam)
+	}
+	target, err := btcutil.NewAmount(req.Target)
+	if err != nil {
+		return nil, fmt.Errorf("%w:target %v", errInvalidParam, err)
+	}
+
+	addresses := make([]string, 0, len(req.Addresses))
+	addresses = append(addresses, req.Addresses...)
+	if len(req.Xpub) > 0 {
+		// 只能按当前是否有utxo判断地址是否使用过，已花费完的地址过多时需通过addresses补充之后的地址
+		derived, err := wallet.DeriveAddresses(req.Xpub, wallet.DefaultGapLimit, s.db.HasUTXO)
+		if err != nil {
+			return nil, fmt.Errorf("%w:xpub %v", errInvalidParam, err)
+		}
+		addresses = append(addresses, derived...)
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("%w:addresses or xpub required", errInvalidParam)
+	}
+
+	coins, err := s.listCoins(addresses, req.MinConf)
+	if err != nil {
+		return nil, err
+	}
+
+	change := coins.first
+	if len(req.ChangeAddress) > 0 {
+		if change, err = wallet.DecodeAddress(req.ChangeAddress); err != nil {
+			return nil, fmt.Errorf("%w:change_address %s", errInvalidParam, req.ChangeAddress)
+		}
+	}
+	output := change
+	if len(req.TargetAddress) > 0 {
+		if output, err = wallet.DecodeAddress(req.TargetAddress); err != nil {
+			return nil, fmt.Errorf("%w:target_address %s", errInvalidParam, req.TargetAddress)
+		}
+	}
+
+	return wallet.SelectCoins(coins.list, &wallet.Params{
+		Target:  int64(target),
+		FeeRate: req.FeeRate,
+		Outputs: []btcutil.Address{output},
+		Change:  change,
+	})
+}
+
+type coinList struct {
+	first btcutil.Address //第一个来源地址 默认作为找零地址
+	list  []*wallet.Coin
+}
+
+// listCoins 读取地址集合下满足确认数的utxo
+func (s *Server) listCoins(addresses []string, minConf int64) (*coinList, error) {
+	sheight, err := s.db.GetStoreHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	coins := &coinList{}
+	seen := make(map[string]struct{}, len(addresses))
+	for _, address := range addresses {
+		if _, ok := seen[address]; ok {
+			continue
+		}
+		seen[address] = struct{}{}
+
+		addr, err := wallet.DecodeAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("%w:address %s", errInvalidParam, address)
+		}
+		if coins.first == nil {
+			coins.first = addr
+		}
+
+		utxos, err := s.db.ListUTXOByAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range utxos {
+			if minConf > 0 && u.Height == 0 {
+				return nil, db.ErrUnknownHeight
+			}
+			if minConf > 0 && sheight-u.Height+1 < minConf {
+				continue
+			}
+			value, err := btcutil.NewAmount(u.Value)
+			if err != nil {
+				return nil, err
+			}
+			coins.list = append(coins.list, &wallet.Coin{
+				TxID:    u.TxID,
+				Index:   uint32(u.Index),
+				Address: addr,
+				Value:   int64(value),
+				Height:  u.Height,
+				Script:  u.Script,
+			})
+		}
+	}
+	return coins, nil
+}
+
+func coinSelectReply(res *wallet.Result) *model.CoinSelectReply {
+	reply := &model.CoinSelectReply{
+		Algorithm: res.Algorithm,
+		Inputs:    make([]*model.Coin, 0, len(res.Inputs)),
+		Total:     formatAmount(res.Total),
+		Fee:       formatAmount(res.Fee),
+		Change:    formatAmount(res.Change),
+		VSize:     res.VSize,
+	}
+	for _, c := range res.Inputs {
+		reply.Inputs = append(reply.Inputs, &model.Coin{
+			TxID:    c.TxID,
+			Index:   int(c.Index),
+			Address: c.Address.EncodeAddress(),
+			Value:   formatAmount(c.Value),
+		})
+	}
+	return reply
+}
+
+func formatAmount(sat int64) string {
+	return fmt.Sprintf("%.8f", btcutil.Amount(sat).ToBTC())
+}
+
+// psbtHandle 根据来源地址的utxo构造未签名PSBT
+func (s *Server) psbtHandle() func(ctx *gin.Context) {
+	return func(ctx *gin.Context) {
+		var req model.PSBTRequest
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			replyError(ctx, http.StatusBadRequest, err)
+			return
+		}
+
+		reply, err := s.buildPSBT(&req)
+		if isBadRequest(err) {
+			replyError(ctx, http.StatusBadRequest, err)
+		} else if err != nil {
+			replyError(ctx, http.StatusInternalServerError, err)
+		} else {
+			replyData(ctx, reply)
+		}
+	}
+}
+
+func (s *Server) buildPSBT(req *model.PSBTRequest) (*model.PSBTReply, error) {
+	if len(req.Addresses) == 0 {
+		return nil, fmt.Errorf("%w:addresses required", errInvalidParam)
+	}
+	if len(req.Outputs) == 0 {
+		return nil, fmt.Errorf("%w:outputs required", errInvalidParam)
+	}
+	if req.FeeRate < 0 {
+		return nil, fmt.Errorf("%w:fee_rate must not be negative", errInvalidParam)
+	}
+
+	outputs := make([]*wallet.TxOut, 0, len(req.Outputs))
+	outAddrs := make([]btcutil.Address, 0, len(req.Outputs))
+	var target int64
+	for _, o := range req.Outputs {
+		addr, err := wallet.DecodeAddress(o.Address)
+		if err != nil {
+			return nil, fmt.Errorf("%w:output address %s", errInvalidParam, o.Address)
+		}
+		value, err := btcutil.NewAmount(o.Value)
+		if err != nil || value < wallet.DustLimit {
+			return nil, fmt.Errorf("%w:output value %v", errInvalidParam, o.Value)
+		}
+		outputs = append(outputs, &wallet.TxOut{Address: addr, Value: int64(value)})
+		outAddrs = append(outAddrs, addr)
+		target += int64(value)
+	}
+
+	coins, err := s.listCoins(req.Addresses, req.MinConf)
+	if err != nil {
+		return nil, err
+	}
+	change := coins.first
+	if len(req.ChangeAddress) > 0 {
+		if change, err = wallet.DecodeAddress(req.ChangeAddress); err != nil {
+			return nil, fmt.Errorf("%w:change_address %s", errInvalidParam, req.ChangeAddress)
+		}
+	}
+
+	res, err := wallet.SelectCoins(coins.list, &wallet.Params{
+		Target:  target,
+		FeeRate: req.FeeRate,
+		Outputs: outAddrs,
+		Change:  change,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packet, err := wallet.BuildPSBT(res, outputs, change, s.prevTxFetcher())
+	if err != nil {
+		return nil, err
+	}
+	b64, err := packet.B64Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	sr := coinSelectReply(res)
+	return &model.PSBTReply{
+		PSBT:   b64,
+		Inputs: sr.Inputs,
+		Fee:    sr.Fee,
+		Change: sr.Change,
+		VSize:  sr.VSize,
+	}, nil
+}
+
+// prevTxFetcher 通过索引记录的utxo高度从节点读取区块获取前序交易，节点无需开启txindex
+// 高度未知的旧数据回退到getrawtransaction；只用于填充non_witness_utxo
+func (s *Server) prevTxFetcher() wallet.PrevTxFunc {
+	blocks := make(map[int64]*wire.MsgBlock)
+	return func(c *wallet.Coin) (*wire.MsgTx, error) {
+		hash, err := chainhash.NewHashFromStr(c.TxID)
+		if err != nil {
+			return nil, err
+		}
+		if c.Height <= 0 {
+			tx, err := s.rpc.GetRawTransaction(hash)
+			if err != nil {
+				return nil, err
+			}
+			return tx.MsgTx(), nil
+		}
+
+		block, ok := blocks[c.Height]
+		if !ok {
+			bhash, err := s.rpc.GetBlockHash(c.Height)
+			if err != nil {
+				return nil, err
+			}
+			if block, err = s.rpc.GetBlock(bhash); err != nil {
+				return nil, err
+			}
+			blocks[c.Height] = block
+		}
+		for _, tx := range block.Transactions {
+			if tx.TxHash() == *hash {
+				return tx, nil
+			}
+		}
+		return nil, fmt.Errorf("tx not found in block %d", c.Height)
+	}
+}
@@ -0,0 +1,196 @@
+package snapshot
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// 以下编码与Bitcoin Core的serialize.h/compressor.cpp一致
+
+// specialScripts 压缩脚本的特殊类型数量 0:P2PKH 1:P2SH 2-5:P2PK
+const specialScripts = 6
+
+var errVarIntOverflow = errors.New("varint overflow")
+
+// readVarInt Core的VARINT(MSB base-128，每个后续字节隐含+1)
+func readVarInt(r io.ByteReader) (uint64, error) {
+	var n uint64
+	for {
+		ch, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if n > (^uint64(0) >> 7) {
+			return 0, errVarIntOverflow
+		}
+		n = (n << 7) | uint64(ch&0x7f)
+		if ch&0x80 == 0 {
+			return n, nil
+		}
+		if n == ^uint64(0) {
+			return 0, errVarIntOverflow
+		}
+		n++
+	}
+}
+
+func writeVarInt(w io.ByteWriter, n uint64) error {
+	var tmp [10]byte
+	l := 0
+	for {
+		tmp[l] = byte(n & 0x7f)
+		if l > 0 {
+			tmp[l] |= 0x80
+		}
+		if n <= 0x7f {
+			break
+		}
+		n = (n >> 7) - 1
+		l++
+	}
+	for ; l >= 0; l-- {
+		if err := w.WriteByte(tmp[l]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressAmount 去掉末尾的0 小额及整数金额占用更少字节
+func compressAmount(n uint64) uint64 {
+	if n == 0 {
+		return 0
+	}
+	e := uint64(0)
+	for n%10 == 0 && e < 9 {
+		n /= 10
+		e++
+	}
+	if e < 9 {
+		d := n % 10
+		n /= 10
+		return 1 + (n*9+d-1)*10 + e
+	}
+	return 1 + (n-1)*10 + 9
+}
+
+func decompressAmount(x uint64) uint64 {
+	if x == 0 {
+		return 0
+	}
+	x--
+	e := x % 10
+	x /= 10
+	var n uint64
+	if e < 9 {
+		d := (x % 9) + 1
+		x /= 9
+		n = x*10 + d
+	} else {
+		n = x + 1
+	}
+	for ; e > 0; e-- {
+		n *= 10
+	}
+	return n
+}
+
+// readScript 读取压缩脚本
+func readScript(r *bufio.Reader) ([]byte, error) {
+	size, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if size < specialScripts {
+		n := 32
+		if size < 2 {
+			n = 20
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return decompressScript(size, buf)
+	}
+
+	size -= specialScripts
+	if size > txscript.MaxScriptSize {
+		// 超长脚本不可花费 Core以OP_RETURN代替
+		if _, err := r.Discard(int(size)); err != nil {
+			return nil, err
+		}
+		return []byte{txscript.OP_RETURN}, nil
+	}
+	script := make([]byte, size)
+	if _, err := io.ReadFull(r, script); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+func decompressScript(kind uint64, data []byte) ([]byte, error) {
+	switch kind {
+	case 0:
+		script := []byte{txscript.OP_DUP, txscript.OP_HASH160, txscript.OP_DATA_20}
+		script = append(script, data...)
+		return append(script, txscript.OP_EQUALVERIFY, txscript.OP_CHECKSIG), nil
+	case 1:
+		script := []byte{txscript.OP_HASH160, txscript.OP_DATA_20}
+		script = append(script, data...)
+		return append(script, txscript.OP_EQUAL), nil
+	case 2, 3:
+		script := []byte{txscript.OP_DATA_33, byte(kind)}
+		script = append(script, data...)
+		return append(script, txscript.OP_CHECKSIG), nil
+	case 4, 5:
+		compressed := append([]byte{byte(kind - 2)}, data...)
+		pub, err := btcec.ParsePubKey(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("decompress pubkey: %w", err)
+		}
+		script := []byte{txscript.OP_DATA_65}
+		script = append(script, pub.SerializeUncompressed()...)
+		return append(script, txscript.OP_CHECKSIG), nil
+	}
+	return nil, fmt.Errorf("unknown special script %d", kind)
+}
+
+// writeScript 写入压缩脚本
+func writeScript(w *bufio.Writer, script []byte) error {
+	if kind, data, ok := compressScript(script); ok {
+		if err := w.WriteByte(kind); err != nil {
+			return err
+		}
+		_, err := w.Write(data)
+		return err
+	}
+	if err := writeVarInt(w, uint64(len(script))+specialScripts); err != nil {
+		return err
+	}
+	_, err := w.Write(script)
+	return err
+}
+
+func compressScript(s []byte) (byte, []byte, bool) {
+	switch {
+	case len(s) == 25 && s[0] == txscript.OP_DUP && s[1] == txscript.OP_HASH160 && s[2] == txscript.OP_DATA_20 &&
+		s[23] == txscript.OP_EQUALVERIFY && s[24] == txscript.OP_CHECKSIG:
+		return 0, s[3:23], true
+	case len(s) == 23 && s[0] == txscript.OP_HASH160 && s[1] == txscript.OP_DATA_20 && s[22] == txscript.OP_EQUAL:
+		return 1, s[2:22], true
+	case len(s) == 35 && s[0] == txscript.OP_DATA_33 && s[34] == txscript.OP_CHECKSIG && (s[1] == 0x02 || s[1] == 0x03):
+		return s[1], s[2:34], true
+	case len(s) == 67 && s[0] == txscript.OP_DATA_65 && s[66] == txscript.OP_CHECKSIG && s[1] == 0x04:
+		// 只压缩有效的公钥，否则无法还原
+		if _, err := btcec.ParsePubKey(s[1:66]); err != nil {
+			return 0, nil, false
+		}
+		return 0x04 | (s[65] & 0x01), s[2:34], true
+	}
+	return 0, nil, false
+}
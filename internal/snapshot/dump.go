@@ -0,0 +1,70 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+// BlockHashFunc 查询指定高度的区块哈希 作为快照的基准区块
+type BlockHashFunc func(height int64) (*chainhash.Hash, error)
+
+// Dump 将索引当前的utxo集合按dumptxoutset v2格式写入w，返回快照元数据及基准高度
+// 需要索引记录锁定脚本，旧版本索引返回db.ErrUTXOSetUnavailable
+func Dump(ctx context.Context, tmdb *db.DB, w io.Writer, network wire.BitcoinNet, blockHash BlockHashFunc, progress Progress) (*Metadata, int64, error) {
+	var (
+		sw     *Writer
+		height int64
+		total  uint64
+	)
+	begin := func(info *model.UTXOSetInfo) error {
+		hash, err := blockHash(info.Height)
+		if err != nil {
+			return fmt.Errorf("block hash at %d: %w", info.Height, err)
+		}
+		height, total = info.Height, info.TxOuts
+		sw, err = NewWriter(w, network, *hash, total)
+		return err
+	}
+	var written uint64
+	err := tmdb.ForEachUnspent(ctx, begin, func(txid string, index uint32, info *db.UtxoInfo) error {
+		hash, err := chainhash.NewHashFromStr(txid)
+		if err != nil {
+			return err
+		}
+		value, err := btcutil.NewAmount(info.Value)
+		if err != nil {
+			return err
+		}
+		if err := sw.Write(&Coin{
+			TxID:     *hash,
+			Index:    index,
+			Height:   info.Height,
+			Coinbase: info.Coinbase,
+			Value:    int64(value),
+			Script:   info.Script,
+		}); err != nil {
+			return err
+		}
+		if written++; progress != nil && written%DefaultBatchSize == 0 {
+			progress(written, total)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := sw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if progress != nil {
+		progress(written, total)
+	}
+	return sw.Metadata(), height, nil
+}
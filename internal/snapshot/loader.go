@@ -0,0 +1,110 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/pkg"
+)
+
+// DefaultBatchSize 每批写入的utxo数量
+const DefaultBatchSize = 100000
+
+// Progress 导入导出进度回调
+type Progress func(loaded, total uint64)
+
+// Load 将快照导入空数据库并把存储高度设置为快照高度，Indexer.Sync从下一个区块继续同步
+// 中途失败时数据库中会残留部分数据，需要清空数据目录后重新导入
+func Load(ctx context.Context, tmdb *db.DB, r *Reader, height int64, batchSize int, progress Progress) error {
+	if height <= 0 {
+		return fmt.Errorf("invalid snapshot height %d", height)
+	}
+	empty, err := tmdb.IsEmpty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return errors.New("database is not empty")
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	total := r.Metadata().CoinsCount
+	var loaded uint64
+	vouts := make([]model.Out, 0, batchSize)
+	flush := func() error {
+		if err := tmdb.StoreSnapshot(vouts, height); err != nil {
+			return err
+		}
+		loaded += uint64(len(vouts))
+		vouts = vouts[:0]
+		if progress != nil {
+			progress(loaded, total)
+		}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if c.Height > height {
+			return fmt.Errorf("coin %s:%d height %d above snapshot height %d", c.TxID, c.Index, c.Height, height)
+		}
+		out, err := coinToOut(c)
+		if err != nil {
+			return err
+		}
+		vouts = append(vouts, out)
+		if len(vouts) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(vouts) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return tmdb.SetStoreHeight(height)
+}
+
+// coinToOut 与索引同步时的处理一致 非标准脚本等没有地址的输出只记录utxo
+func coinToOut(c *Coin) (model.Out, error) {
+	txid := c.TxID.String()
+	ukey, err := db.UtxoKey(txid, c.Index)
+	if err != nil {
+		return model.Out{}, err
+	}
+	var address string
+	switch txscript.GetScriptClass(c.Script) {
+	case txscript.NonStandardTy, txscript.NullDataTy:
+	default:
+		address, _ = pkg.GetAddressByScript(c.Script)
+	}
+	return model.Out{
+		UKey:     ukey,
+		TxID:     txid,
+		Index:    int(c.Index),
+		Address:  address,
+		Value:    btcutil.Amount(c.Value).ToBTC(),
+		Height:   c.Height,
+		Script:   c.Script,
+		Coinbase: c.Coinbase,
+	}, nil
+}
@@ -0,0 +1,160 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Version 当前dumptxoutset格式版本(Bitcoin Core v28+)
+const Version = 2
+
+// magic 快照文件头 v28之前的格式没有文件头
+var magic = []byte{'u', 't', 'x', 'o', 0xff}
+
+// Metadata 快照元数据 Version为0表示v28之前的旧格式
+type Metadata struct {
+	Version      uint16
+	Network      wire.BitcoinNet
+	BaseHash     chainhash.Hash
+	CoinsCount   uint64
+	legacyFormat bool
+}
+
+// Coin 快照中的一个utxo
+type Coin struct {
+	TxID     chainhash.Hash
+	Index    uint32
+	Height   int64
+	Coinbase bool
+	Value    int64 //sat
+	Script   []byte
+}
+
+// Reader 按顺序读取dumptxoutset快照
+// v28+格式按txid分组：txid + CompactSize(数量) + [CompactSize(vout) + Coin]...
+// 旧格式每个utxo：txid + uint32(vout) + Coin
+type Reader struct {
+	r     *bufio.Reader
+	meta  *Metadata
+	read  uint64
+	txid  chainhash.Hash
+	group uint64 //当前txid分组剩余数量
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	meta := &Metadata{}
+
+	head, err := br.Peek(len(magic))
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot header: %w", err)
+	}
+	if bytes.Equal(head, magic) {
+		br.Discard(len(magic))
+		var hdr struct {
+			Version uint16
+			Network uint32
+		}
+		if err := binary.Read(br, binary.LittleEndian, &hdr); err != nil {
+			return nil, fmt.Errorf("read snapshot header: %w", err)
+		}
+		if hdr.Version != Version {
+			return nil, fmt.Errorf("unsupported snapshot version %d", hdr.Version)
+		}
+		meta.Version = hdr.Version
+		// 网络magic按消息头字节顺序存储，小端读取后与wire.MainNet等常量一致
+		meta.Network = wire.BitcoinNet(hdr.Network)
+	} else {
+		meta.legacyFormat = true
+	}
+
+	if _, err := io.ReadFull(br, meta.BaseHash[:]); err != nil {
+		return nil, fmt.Errorf("read base blockhash: %w", err)
+	}
+	if err := binary.Read(br, binary.LittleEndian, &meta.CoinsCount); err != nil {
+		return nil, fmt.Errorf("read coins count: %w", err)
+	}
+	return &Reader{r: br, meta: meta}, nil
+}
+
+func (r *Reader) Metadata() *Metadata {
+	return r.meta
+}
+
+// Next 读取下一个utxo 全部读取完成后返回io.EOF
+func (r *Reader) Next() (*Coin, error) {
+	if r.read >= r.meta.CoinsCount {
+		return nil, io.EOF
+	}
+
+	c := &Coin{}
+	if r.meta.legacyFormat {
+		if _, err := io.ReadFull(r.r, c.TxID[:]); err != nil {
+			return nil, r.unexpected(err)
+		}
+		if err := binary.Read(r.r, binary.LittleEndian, &c.Index); err != nil {
+			return nil, r.unexpected(err)
+		}
+	} else {
+		if r.group == 0 {
+			if _, err := io.ReadFull(r.r, r.txid[:]); err != nil {
+				return nil, r.unexpected(err)
+			}
+			n, err := wire.ReadVarInt(r.r, 0)
+			if err != nil {
+				return nil, r.unexpected(err)
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("empty coin group for %s", r.txid)
+			}
+			r.group = n
+		}
+		index, err := wire.ReadVarInt(r.r, 0)
+		if err != nil {
+			return nil, r.unexpected(err)
+		}
+		if index > uint64(^uint32(0)) {
+			return nil, fmt.Errorf("invalid vout %d for %s", index, r.txid)
+		}
+		c.TxID, c.Index = r.txid, uint32(index)
+		r.group--
+	}
+
+	if err := r.readCoin(c); err != nil {
+		return nil, r.unexpected(err)
+	}
+	r.read++
+	return c, nil
+}
+
+// readCoin VARINT(height*2+coinbase) + VARINT(压缩金额) + 压缩脚本
+func (r *Reader) readCoin(c *Coin) error {
+	code, err := readVarInt(r.r)
+	if err != nil {
+		return err
+	}
+	c.Height = int64(code >> 1)
+	c.Coinbase = code&1 == 1
+
+	amount, err := readVarInt(r.r)
+	if err != nil {
+		return err
+	}
+	c.Value = int64(decompressAmount(amount))
+	c.Script, err = readScript(r.r)
+	return err
+}
+
+func (r *Reader) unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("coin %d/%d: %w", r.read+1, r.meta.CoinsCount, err)
+}
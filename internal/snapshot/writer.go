@@ -0,0 +1,115 @@
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Writer 按dumptxoutset v2格式写入utxo 同一txid的utxo必须连续写入
+type Writer struct {
+	w       *bufio.Writer
+	meta    *Metadata
+	written uint64
+	group   []*Coin //当前txid分组 写入时按vout排序
+}
+
+// NewWriter 写入文件头 CoinsCount须为之后写入的utxo总数
+func NewWriter(w io.Writer, network wire.BitcoinNet, baseHash chainhash.Hash, coinsCount uint64) (*Writer, error) {
+	bw := bufio.NewWriterSize(w, 1<<20)
+	meta := &Metadata{
+		Version:    Version,
+		Network:    network,
+		BaseHash:   baseHash,
+		CoinsCount: coinsCount,
+	}
+
+	hdr := make([]byte, 0, len(magic)+2+4+chainhash.HashSize+8)
+	hdr = append(hdr, magic...)
+	hdr = binary.LittleEndian.AppendUint16(hdr, Version)
+	hdr = binary.LittleEndian.AppendUint32(hdr, uint32(network))
+	hdr = append(hdr, baseHash[:]...)
+	hdr = binary.LittleEndian.AppendUint64(hdr, coinsCount)
+	if _, err := bw.Write(hdr); err != nil {
+		return nil, err
+	}
+	return &Writer{w: bw, meta: meta}, nil
+}
+
+func (w *Writer) Metadata() *Metadata {
+	return w.meta
+}
+
+// Write 缓存到当前txid分组，txid变化时写出上一个分组
+func (w *Writer) Write(c *Coin) error {
+	if len(w.group) > 0 && w.group[0].TxID != c.TxID {
+		if err := w.flushGroup(); err != nil {
+			return err
+		}
+	}
+	if w.written+uint64(len(w.group)) >= w.meta.CoinsCount {
+		return fmt.Errorf("more coins than declared count %d", w.meta.CoinsCount)
+	}
+	w.group = append(w.group, c)
+	return nil
+}
+
+// flushGroup txid + CompactSize(数量) + [CompactSize(vout) + Coin]...
+func (w *Writer) flushGroup() error {
+	if len(w.group) == 0 {
+		return nil
+	}
+	sort.Slice(w.group, func(i, j int) bool { return w.group[i].Index < w.group[j].Index })
+	txid := w.group[0].TxID
+	if _, err := w.w.Write(txid[:]); err != nil {
+		return err
+	}
+	if err := wire.WriteVarInt(w.w, 0, uint64(len(w.group))); err != nil {
+		return err
+	}
+	for _, c := range w.group {
+		if err := wire.WriteVarInt(w.w, 0, uint64(c.Index)); err != nil {
+			return err
+		}
+		if err := writeCoin(w.w, c); err != nil {
+			return fmt.Errorf("coin %s:%d: %w", c.TxID, c.Index, err)
+		}
+	}
+	w.written += uint64(len(w.group))
+	w.group = w.group[:0]
+	return nil
+}
+
+// writeCoin VARINT(height*2+coinbase) + VARINT(压缩金额) + 压缩脚本
+func writeCoin(w *bufio.Writer, c *Coin) error {
+	if c.Height < 0 || c.Value < 0 {
+		return fmt.Errorf("invalid height %d or value %d", c.Height, c.Value)
+	}
+	code := uint64(c.Height) << 1
+	if c.Coinbase {
+		code |= 1
+	}
+	if err := writeVarInt(w, code); err != nil {
+		return err
+	}
+	if err := writeVarInt(w, compressAmount(uint64(c.Value))); err != nil {
+		return err
+	}
+	return writeScript(w, c.Script)
+}
+
+// Close 写出最后一个分组 写入数量与文件头不一致时返回错误
+func (w *Writer) Close() error {
+	if err := w.flushGroup(); err != nil {
+		return err
+	}
+	if w.written != w.meta.CoinsCount {
+		return fmt.Errorf("wrote %d coins, header declares %d", w.written, w.meta.CoinsCount)
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,101 @@
+package verifier
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"go.uber.org/zap"
+)
+
+// ErrRunning 已有校验任务在运行
+var ErrRunning = errors.New("verify job is already running")
+
+// Verifier 后台运行一致性校验任务 同一时间只运行一个任务，保留最近一次任务的状态
+type Verifier struct {
+	db     *db.DB
+	logger *zap.Logger
+
+	mu     sync.Mutex
+	job    *model.VerifyJob
+	cancel context.CancelFunc
+}
+
+func New(db *db.DB, logger *zap.Logger) *Verifier {
+	return &Verifier{db: db, logger: logger}
+}
+
+// Start 启动后台校验任务
+func (v *Verifier) Start(repair bool) (*model.VerifyJob, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.job != nil && v.job.State == model.VerifyRunning {
+		return nil, ErrRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancel = cancel
+	v.job = &model.VerifyJob{
+		State:     model.VerifyRunning,
+		Repair:    repair,
+		StartedAt: time.Now().Unix(),
+	}
+	job := *v.job
+
+	go v.run(ctx, repair)
+	return &job, nil
+}
+
+// Status 最近一次任务的状态 没有任务时返回nil
+func (v *Verifier) Status() *model.VerifyJob {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.job == nil {
+		return nil
+	}
+	job := *v.job
+	return &job
+}
+
+// Cancel 取消运行中的任务
+func (v *Verifier) Cancel() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.job == nil || v.job.State != model.VerifyRunning {
+		return false
+	}
+	v.cancel()
+	return true
+}
+
+func (v *Verifier) run(ctx context.Context, repair bool) {
+	report, err := v.db.Verify(ctx, repair, func(processed, total int64) {
+		v.mu.Lock()
+		v.job.Processed, v.job.Total = processed, total
+		v.mu.Unlock()
+	})
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cancel()
+	v.job.Report = report
+	v.job.FinishedAt = time.Now().Unix()
+	switch {
+	case errors.Is(err, context.Canceled):
+		v.job.State = model.VerifyCanceled
+	case err != nil:
+		v.job.State = model.VerifyFailed
+		v.job.Error = err.Error()
+	default:
+		v.job.State = model.VerifyDone
+	}
+
+	fields := []zap.Field{zap.String("state", v.job.State), zap.Bool("repair", repair), zap.Error(err)}
+	if report != nil {
+		fields = append(fields, zap.Int64("issues", report.IssueCount), zap.Int64("repaired", report.Repaired))
+	}
+	v.logger.Info("Verify::Info", fields...)
+}
@@ -0,0 +1,312 @@
+package wallet
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+)
+
+const (
+	AlgorithmBnB      = "bnb"
+	AlgorithmKnapsack = "knapsack"
+
+	bnbMaxTries        = 100000
+	knapsackIterations = 1000
+)
+
+var ErrInsufficientFunds = errors.New("insufficient funds")
+
+// Coin 参与选币的utxo 金额单位sat
+type Coin struct {
+	TxID    string
+	Index   uint32
+	Address btcutil.Address
+	Value   int64
+	Height  int64
+	Script  []byte //索引记录的锁定脚本 为空时按地址生成
+}
+
+// Params 选币参数
+type Params struct {
+	Target  int64             //支付金额 sat
+	FeeRate float64           //sat/vB
+	Outputs []btcutil.Address //支付输出 用于估算大小
+	Change  btcutil.Address   //找零地址 用于估算找零输出大小
+}
+
+// Result 选币结果
+type Result struct {
+	Algorithm string
+	Inputs    []*Coin
+	Total     int64 //输入总额
+	Fee       int64
+	Change    int64 //0表示无找零输出
+	VSize     int64
+}
+
+// SelectCoins 先使用branch-and-bound寻找无需找零的组合，找不到时使用knapsack并产生找零
+func SelectCoins(coins []*Coin, p *Params) (*Result, error) {
+	// 有效金额 = 金额 - 花费该输入的手续费，不足以支付自身手续费的utxo不参与
+	candidates := make([]*Coin, 0, len(coins))
+	effs := make([]int64, 0, len(coins))
+	sorted := make([]*Coin, len(coins))
+	copy(sorted, coins)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return effectiveValue(sorted[i], p.FeeRate) > effectiveValue(sorted[j], p.FeeRate)
+	})
+	segwit := make([]bool, 0, len(coins))
+	for _, c := range sorted {
+		eff := effectiveValue(c, p.FeeRate)
+		if eff <= 0 {
+			continue
+		}
+		candidates = append(candidates, c)
+		effs = append(effs, eff)
+		segwit = append(segwit, IsSegwit(c.Address))
+	}
+
+	// 基础大小不含marker/flag，选中隔离见证输入时再加上
+	baseVSize := int64(txOverheadVSize)
+	for _, out := range p.Outputs {
+		baseVSize += OutputVSize(out)
+	}
+	segwitFee := Fee(p.FeeRate, baseVSize-txOverheadVSize+txSegwitOverheadVSize) - Fee(p.FeeRate, baseVSize)
+	changeFee := Fee(p.FeeRate, OutputVSize(p.Change))
+	costOfChange := changeFee + Fee(p.FeeRate, InputVSize(p.Change))
+
+	target := p.Target + Fee(p.FeeRate, baseVSize)
+	if idx := selectBnB(effs, segwit, target, segwitFee, costOfChange); idx != nil {
+		return finalize(AlgorithmBnB, pick(candidates, idx), p, false)
+	}
+	idx := selectKnapsack(effs, target+changeFee)
+	if idx != nil && anySegwit(segwit, idx) {
+		// 选中了隔离见证输入 按包含marker/flag的目标重新选择
+		idx = selectKnapsack(effs, target+segwitFee+changeFee)
+	}
+	if idx != nil {
+		return finalize(AlgorithmKnapsack, pick(candidates, idx), p, true)
+	}
+	return nil, ErrInsufficientFunds
+}
+
+func anySegwit(segwit []bool, idx []int) bool {
+	for _, i := range idx {
+		if segwit[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func effectiveValue(c *Coin, feeRate float64) int64 {
+	return c.Value - Fee(feeRate, InputVSize(c.Address))
+}
+
+func pick(coins []*Coin, idx []int) []*Coin {
+	list := make([]*Coin, 0, len(idx))
+	for _, i := range idx {
+		list = append(list, coins[i])
+	}
+	return list
+}
+
+// finalize 计算手续费及找零 找零低于粉尘值时并入手续费
+func finalize(algorithm string, inputs []*Coin, p *Params, withChange bool) (*Result, error) {
+	res := &Result{Algorithm: algorithm, Inputs: inputs}
+	ins := make([]btcutil.Address, 0, len(inputs))
+	for _, c := range inputs {
+		res.Total += c.Value
+		ins = append(ins, c.Address)
+	}
+
+	if withChange {
+		outs := append(append([]btcutil.Address{}, p.Outputs...), p.Change)
+		vsize := TxVSize(ins, outs)
+		fee := Fee(p.FeeRate, vsize)
+		if change := res.Total - p.Target - fee; change >= DustLimit {
+			res.VSize, res.Fee, res.Change = vsize, fee, change
+			return res, nil
+		}
+	}
+
+	res.VSize = TxVSize(ins, p.Outputs)
+	res.Fee = res.Total - p.Target
+	if res.Fee < Fee(p.FeeRate, res.VSize) {
+		return nil, ErrInsufficientFunds
+	}
+	return res, nil
+}
+
+// selectBnB 深度优先搜索金额落在[target, target+costOfChange]内且浪费最小的组合
+// 组合中包含隔离见证输入(segwit[i])时目标金额加上segwitFee；values需按降序排列
+func selectBnB(values []int64, segwit []bool, target, segwitFee, costOfChange int64) []int {
+	var avail, cur int64
+	for _, v := range values {
+		avail += v
+	}
+	if avail < target {
+		return nil
+	}
+
+	var best []bool
+	bestWaste := int64(math.MaxInt64)
+	sel := make([]bool, 0, len(values))
+	nsegwit := 0 //已选中的隔离见证输入数
+	for tries := 0; tries < bnbMaxTries; tries++ {
+		ctarget := target
+		if nsegwit > 0 {
+			ctarget += segwitFee
+		}
+		backtrack := false
+		if cur+avail < ctarget || cur > ctarget+costOfChange {
+			backtrack = true
+		} else if cur >= ctarget {
+			if waste := cur - ctarget; waste <= bestWaste {
+				best = append(best[:0], sel...)
+				bestWaste = waste
+			}
+			backtrack = true
+		}
+
+		if backtrack {
+			// 回退到最后一个选中的utxo 改为不选
+			for len(sel) > 0 && !sel[len(sel)-1] {
+				avail += values[len(sel)-1]
+				sel = sel[:len(sel)-1]
+			}
+			if len(sel) == 0 {
+				break
+			}
+			sel[len(sel)-1] = false
+			cur -= values[len(sel)-1]
+			if segwit[len(sel)-1] {
+				nsegwit--
+			}
+			continue
+		}
+
+		i := len(sel)
+		avail -= values[i]
+		if i > 0 && !sel[i-1] && values[i] == values[i-1] && segwit[i] == segwit[i-1] {
+			// 与上一个金额及类型相同且上一个未选 结果等价 直接跳过
+			sel = append(sel, false)
+		} else {
+			sel = append(sel, true)
+			cur += values[i]
+			if segwit[i] {
+				nsegwit++
+			}
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	idx := make([]int, 0, len(best))
+	for i, ok := range best {
+		if ok {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// selectKnapsack 参考Bitcoin Core的KnapsackSolver 目标金额已包含找零输出手续费
+// values需按降序排列
+func selectKnapsack(values []int64, target int64) []int {
+	lowestLarger := -1
+	smaller := make([]int, 0, len(values))
+	var totalSmaller int64
+	for i, v := range values {
+		if v == target {
+			return []int{i}
+		}
+		if v < target+DustLimit {
+			smaller = append(smaller, i)
+			totalSmaller += v
+		} else if lowestLarger < 0 || v < values[lowestLarger] {
+			lowestLarger = i
+		}
+	}
+
+	if totalSmaller == target {
+		return smaller
+	}
+	if totalSmaller < target {
+		if lowestLarger < 0 {
+			return nil
+		}
+		return []int{lowestLarger}
+	}
+
+	sv := make([]int64, len(smaller))
+	for i, idx := range smaller {
+		sv[i] = values[idx]
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	best, bestValue := approximateBestSubset(rnd, sv, totalSmaller, target)
+	if bestValue != target && totalSmaller >= target+DustLimit {
+		best, bestValue = approximateBestSubset(rnd, sv, totalSmaller, target+DustLimit)
+	}
+
+	// 组合无法凑出足够找零时 使用单个更大的utxo
+	if lowestLarger >= 0 &&
+		((bestValue != target && bestValue < target+DustLimit) || values[lowestLarger] <= bestValue) {
+		return []int{lowestLarger}
+	}
+
+	idx := make([]int, 0, len(best))
+	for i, ok := range best {
+		if ok {
+			idx = append(idx, smaller[i])
+		}
+	}
+	return idx
+}
+
+// approximateBestSubset 随机两轮逼近不小于target的最小组合
+func approximateBestSubset(rnd *rand.Rand, values []int64, total int64, target int64) ([]bool, int64) {
+	best := make([]bool, len(values))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := total
+
+	included := make([]bool, len(values))
+	for rep := 0; rep < knapsackIterations && bestValue != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		var sum int64
+		reached := false
+		for pass := 0; pass < 2 && !reached; pass++ {
+			for i, v := range values {
+				var take bool
+				if pass == 0 {
+					take = rnd.Intn(2) == 1
+				} else {
+					take = !included[i]
+				}
+				if !take {
+					continue
+				}
+				sum += v
+				included[i] = true
+				if sum >= target {
+					reached = true
+					if sum < bestValue {
+						bestValue = sum
+						copy(best, included)
+					}
+					sum -= v
+					included[i] = false
+				}
+			}
+		}
+	}
+	return best, bestValue
+}
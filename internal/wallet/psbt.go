@@ -0,0 +1,105 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// sequenceRBF 允许手续费替换
+const sequenceRBF = wire.MaxTxInSequenceNum - 2
+
+// TxOut 支付输出 金额单位sat
+type TxOut struct {
+	Address btcutil.Address
+	Value   int64
+}
+
+// PrevTxFunc 获取输入引用的前序交易 用于填充non_witness_utxo
+type PrevTxFunc func(coin *Coin) (*wire.MsgTx, error)
+
+// BuildPSBT 根据选币结果构造未签名的BIP-174 PSBT，签名方无需访问节点
+// witness_utxo由索引记录的锁定脚本及金额生成(P2SH按P2SH-P2WPKH处理，与大小估算一致)；
+// 非隔离见证输入必须携带non_witness_utxo，通过prevTx获取，失败时返回错误；
+// segwit v0及P2SH输入尽量同时携带(防止手续费欺骗攻击)，节点已裁剪等原因获取失败时省略；taproot不需要
+func BuildPSBT(res *Result, outputs []*TxOut, change btcutil.Address, prevTx PrevTxFunc) (*psbt.Packet, error) {
+	tx := wire.NewMsgTx(2)
+	for _, c := range res.Inputs {
+		hash, err := chainhash.NewHashFromStr(c.TxID)
+		if err != nil {
+			return nil, err
+		}
+		in := wire.NewTxIn(wire.NewOutPoint(hash, c.Index), nil, nil)
+		in.Sequence = sequenceRBF
+		tx.AddTxIn(in)
+	}
+	for _, out := range outputs {
+		script, err := txscript.PayToAddrScript(out.Address)
+		if err != nil {
+			return nil, err
+		}
+		tx.AddTxOut(wire.NewTxOut(out.Value, script))
+	}
+	if res.Change > 0 {
+		script, err := txscript.PayToAddrScript(change)
+		if err != nil {
+			return nil, err
+		}
+		tx.AddTxOut(wire.NewTxOut(res.Change, script))
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, c := range res.Inputs {
+		script := c.Script
+		if len(script) == 0 {
+			if script, err = txscript.PayToAddrScript(c.Address); err != nil {
+				return nil, err
+			}
+		}
+		pin := &packet.Inputs[i]
+		switch txscript.GetScriptClass(script) {
+		case txscript.WitnessV1TaprootTy:
+			pin.WitnessUtxo = wire.NewTxOut(c.Value, script)
+			continue
+		case txscript.WitnessV0PubKeyHashTy, txscript.WitnessV0ScriptHashTy, txscript.ScriptHashTy:
+			pin.WitnessUtxo = wire.NewTxOut(c.Value, script)
+		}
+
+		prev, err := fetchPrevTx(prevTx, c)
+		if err != nil {
+			if pin.WitnessUtxo != nil {
+				continue
+			}
+			return nil, fmt.Errorf("prev tx %s:%d: %w", c.TxID, c.Index, err)
+		}
+		if prev.TxHash().String() != c.TxID || int(c.Index) >= len(prev.TxOut) {
+			return nil, fmt.Errorf("prev tx mismatch %s:%d", c.TxID, c.Index)
+		}
+		prevOut := prev.TxOut[c.Index]
+		if prevOut.Value != c.Value || !bytes.Equal(prevOut.PkScript, script) {
+			return nil, fmt.Errorf("prev tx output mismatch %s:%d", c.TxID, c.Index)
+		}
+		pin.NonWitnessUtxo = prev
+	}
+
+	if err := packet.SanityCheck(); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func fetchPrevTx(prevTx PrevTxFunc, c *Coin) (*wire.MsgTx, error) {
+	if prevTx == nil {
+		return nil, fmt.Errorf("no prev tx source")
+	}
+	return prevTx(c)
+}
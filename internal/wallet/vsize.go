@@ -0,0 +1,99 @@
+package wallet
+
+import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// 各脚本类型的虚拟大小估算(vbytes，向上取整)
+const (
+	txOverheadVSize       = 10 //version + locktime + 输入输出数量
+	txSegwitOverheadVSize = 11 //额外的marker/flag
+
+	p2pkhInputVSize  = 148
+	p2shInputVSize   = 91 //按P2SH-P2WPKH估算
+	p2wpkhInputVSize = 68
+	p2wshInputVSize  = 105 //按2-of-3多签估算
+	p2trInputVSize   = 58  //key path
+
+	p2pkhOutputVSize  = 34
+	p2shOutputVSize   = 32
+	p2wpkhOutputVSize = 31
+	p2wshOutputVSize  = 43
+	p2trOutputVSize   = 43
+
+	// DustLimit 低于该值的找零并入手续费
+	DustLimit = 546
+)
+
+// DecodeAddress 解析主网地址
+func DecodeAddress(address string) (btcutil.Address, error) {
+	return btcutil.DecodeAddress(address, &chaincfg.MainNetParams)
+}
+
+// InputVSize 花费该地址utxo的输入大小
+func InputVSize(addr btcutil.Address) int64 {
+	switch addr.(type) {
+	case *btcutil.AddressScriptHash:
+		return p2shInputVSize
+	case *btcutil.AddressWitnessPubKeyHash:
+		return p2wpkhInputVSize
+	case *btcutil.AddressWitnessScriptHash:
+		return p2wshInputVSize
+	case *btcutil.AddressTaproot:
+		return p2trInputVSize
+	}
+	return p2pkhInputVSize
+}
+
+// OutputVSize 支付到该地址的输出大小
+func OutputVSize(addr btcutil.Address) int64 {
+	switch addr.(type) {
+	case *btcutil.AddressScriptHash:
+		return p2shOutputVSize
+	case *btcutil.AddressWitnessPubKeyHash:
+		return p2wpkhOutputVSize
+	case *btcutil.AddressWitnessScriptHash:
+		return p2wshOutputVSize
+	case *btcutil.AddressTaproot:
+		return p2trOutputVSize
+	}
+	return p2pkhOutputVSize
+}
+
+// IsSegwit 是否为隔离见证输入(P2SH按P2SH-P2WPKH处理)
+func IsSegwit(addr btcutil.Address) bool {
+	switch addr.(type) {
+	case *btcutil.AddressPubKeyHash, *btcutil.AddressPubKey:
+		return false
+	}
+	return true
+}
+
+// TxVSize 估算交易虚拟大小
+func TxVSize(inputs []btcutil.Address, outputs []btcutil.Address) int64 {
+	vsize := int64(txOverheadVSize)
+	for _, in := range inputs {
+		if IsSegwit(in) {
+			vsize = txSegwitOverheadVSize
+			break
+		}
+	}
+	for _, in := range inputs {
+		vsize += InputVSize(in)
+	}
+	for _, out := range outputs {
+		vsize += OutputVSize(out)
+	}
+	return vsize
+}
+
+// Fee 按费率(sat/vB)计算手续费 向上取整
+func Fee(feeRate float64, vsize int64) int64 {
+	fee := feeRate * float64(vsize)
+	n := int64(fee)
+	if float64(n) < fee {
+		n++
+	}
+	return n
+}
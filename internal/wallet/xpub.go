@@ -0,0 +1,101 @@
+package wallet
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/hdkeychain"
+	"github.com/btcsuite/btcd/chaincfg"
+)
+
+// DefaultGapLimit BIP44 连续未使用地址数量
+const DefaultGapLimit = 20
+
+// 扩展公钥版本 决定派生的地址类型
+var (
+	xpubVersion = []byte{0x04, 0x88, 0xb2, 0x1e} //P2PKH
+	ypubVersion = []byte{0x04, 0x9d, 0x7c, 0xb2} //P2SH-P2WPKH
+	zpubVersion = []byte{0x04, 0xb2, 0x47, 0x46} //P2WPKH
+)
+
+// DeriveAddresses 从账户层级的扩展公钥派生外部(0)及找零(1)链地址，
+// 直到连续gapLimit个地址未被使用 used用于判断地址是否有utxo
+// 索引不记录地址历史，utxo已全部花费的地址视为未使用，连续gapLimit个这样的地址之后的地址不会被派生
+func DeriveAddresses(xpub string, gapLimit int, used func(address string) (bool, error)) ([]string, error) {
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if key.IsPrivate() {
+		return nil, fmt.Errorf("private extended key is not accepted")
+	}
+	version := key.Version()
+	if !bytes.Equal(version, xpubVersion) && !bytes.Equal(version, ypubVersion) && !bytes.Equal(version, zpubVersion) {
+		return nil, fmt.Errorf("unsupported extended key version:%x", version)
+	}
+
+	addresses := make([]string, 0, gapLimit*2)
+	for _, branch := range []uint32{0, 1} {
+		chain, err := key.Derive(branch)
+		if err != nil {
+			return nil, err
+		}
+		gap := 0
+		for i := uint32(0); gap < gapLimit; i++ {
+			child, err := chain.Derive(i)
+			if err != nil {
+				return nil, err
+			}
+			addr, err := childAddress(child, version)
+			if err != nil {
+				return nil, err
+			}
+			ok, err := used(addr)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				gap++
+				continue
+			}
+			gap = 0
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses, nil
+}
+
+func childAddress(child *hdkeychain.ExtendedKey, version []byte) (string, error) {
+	pub, err := child.ECPubKey()
+	if err != nil {
+		return "", err
+	}
+	hash := btcutil.Hash160(pub.SerializeCompressed())
+	params := &chaincfg.MainNetParams
+
+	switch {
+	case bytes.Equal(version, zpubVersion):
+		addr, err := btcutil.NewAddressWitnessPubKeyHash(hash, params)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+	case bytes.Equal(version, ypubVersion):
+		witness, err := btcutil.NewAddressWitnessPubKeyHash(hash, params)
+		if err != nil {
+			return "", err
+		}
+		script := append([]byte{0x00, 0x14}, witness.WitnessProgram()...)
+		addr, err := btcutil.NewAddressScriptHash(script, params)
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+	}
+	addr, err := btcutil.NewAddressPubKeyHash(hash, params)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
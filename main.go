@@ -2,81 +2,180 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/wx-shi/utxo-indexer/internal/config"
 	"github.com/wx-shi/utxo-indexer/internal/db"
 	"github.com/wx-shi/utxo-indexer/internal/indexer"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
 	"github.com/wx-shi/utxo-indexer/internal/server"
+	"github.com/wx-shi/utxo-indexer/internal/snapshot"
 	"github.com/wx-shi/utxo-indexer/pkg"
 	"go.uber.org/zap"
 )
 
-var (
-	flagconf string
-)
+type command struct {
+	usage string
+	run   func(args []string) error
+}
 
-func init() {
-	flag.StringVar(&flagconf, "conf", "./config.yaml", "config path, eg: -conf config.yaml")
+var commands = map[string]*command{
+	"serve":         {"同步索引并启动HTTP服务(默认)", serveCmd("serve", true, true)},
+	"index-only":    {"只同步索引，不启动HTTP服务", serveCmd("index-only", true, false)},
+	"api-only":      {"只读打开数据库并启动HTTP服务，不同步索引", serveCmd("api-only", false, true)},
+	"reindex":       {"reindex --from <height> 回滚到from-1后重新同步", reindexCmd},
+	"rollback":      {"rollback --to <height> 回滚索引到指定高度", rollbackCmd},
+	"verify":        {"verify [--repair] 校验地址余额与utxo集合是否一致", verifyCmd},
+	"stats":         {"stats [--top N] 输出各存储的记录数、磁盘占用及utxo最多的地址", statsCmd},
+	"compact":       {"压缩数据库(服务运行时使用POST /admin/compact)", compactCmd},
+	"prune":         {"prune模式下删除超过prune_depth的已花费记录(archive切换到prune后执行一次)", pruneCmd},
+	"check-utxoset": {"比较索引的utxo集合哈希与节点gettxoutsetinfo muhash", checkUTXOSetCmd},
+	"export":        {"export --out <file> 导出索引数据库(压缩并带校验和)", exportCmd},
+	"import":        {"import --in <file> 将导出文件导入空数据目录", importCmd},
+	"load-snapshot": {"load-snapshot --file <path> [--height N] 从dumptxoutset快照初始化索引", loadSnapshotCmd},
+	"dump-snapshot": {"dump-snapshot --out <path> 按dumptxoutset格式导出当前utxo集合", dumpSnapshotCmd},
 }
 
 func main() {
-	flag.Parse()
+	// 兼容旧用法 ./utxo-indexer -conf config.yaml
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	width := 0
+	for name := range commands {
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "Usage: utxo-indexer <command> [-conf config.yaml] [flags]\n\nCommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-*s %s\n", width, name, commands[name].usage)
+	}
+}
 
+// app 各命令共用的配置、日志及数据库
+type app struct {
+	cfg    *config.Config
+	logger *zap.Logger
+	db     *db.DB
+}
+
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	conf := fs.String("conf", "./config.yaml", "config path, eg: -conf config.yaml")
+	return fs, conf
+}
+
+// openApp 加载配置并打开数据库
+func openApp(confPath string, readOnly bool) (*app, error) {
 	// Load configuration
-	cfg, err := config.LoadConfig(flagconf)
+	cfg, err := config.LoadConfig(confPath)
 	if err != nil {
-		fmt.Printf("Error loading config: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("loading config: %w", err)
 	}
 
 	// Initialize logger
-	logger, _ := pkg.NewLogger(cfg.LogLevel)
-	defer logger.Sync()
-
-	// Initialize BadgerDB
-	tmdb, err := db.NewDB(cfg.DB, logger)
+	logger, err := pkg.NewLogger(cfg.LogLevel)
 	if err != nil {
-		logger.Fatal("Error initializing DB", zap.Error(err))
+		return nil, err
 	}
-	defer func() {
-		if err := tmdb.Close(); err != nil {
-			logger.Fatal("DB::Close", zap.Error(err))
-		}
-	}()
 
-	// Initialize Bitcoin JSON-RPC client
-	btcClient, err := rpcclient.New(&rpcclient.ConnConfig{
-		Host:         cfg.RPC.URL,
-		User:         cfg.RPC.User,
-		Pass:         cfg.RPC.Password,
-		HTTPPostMode: true, // Bitcoin core only supports HTTP POST mode
-		DisableTLS:   true, // Bitcoin core does not provide TLS by default
-	}, nil)
+	dbConf := *cfg.DB
+	dbConf.ReadOnly = dbConf.ReadOnly || readOnly
+	tmdb, err := db.NewDB(&dbConf, logger)
 	if err != nil {
-		logger.Fatal("Error initializing Bitcoin RPC client", zap.Error(err))
+		return nil, fmt.Errorf("initializing DB: %w", err)
+	}
+	return &app{cfg: cfg, logger: logger, db: tmdb}, nil
+}
+
+func (a *app) close() {
+	if err := a.db.Close(); err != nil {
+		a.logger.Error("DB::Close", zap.Error(err))
+	}
+	a.logger.Sync()
+}
+
+func serveCmd(name string, withIndexer, withServer bool) func(args []string) error {
+	return func(args []string) error {
+		fs, conf := newFlagSet(name)
+		fs.Parse(args)
+
+		a, err := openApp(*conf, !withIndexer)
+		if err != nil {
+			return err
+		}
+		defer a.close()
+		if withIndexer {
+			// 回滚中断时余额处于中间状态，需先完成回滚
+			if to, ok, err := a.db.PendingRollback(); err != nil {
+				return err
+			} else if ok {
+				return fmt.Errorf("%w: rerun rollback --to %d", db.ErrRollbackPending, to)
+			}
+		}
+		return a.serve(withIndexer, withServer)
 	}
+}
+
+func (a *app) serve(withIndexer, withServer bool) error {
+	logger := a.logger
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Setup signal handling for graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
+	// Initialize Bitcoin JSON-RPC clients
+	btcClient, err := rpc.NewPool(a.cfg.RPC, logger)
+	if err != nil {
+		return fmt.Errorf("initializing Bitcoin RPC client: %w", err)
+	}
+	btcClient.Start(ctx)
+	defer btcClient.Shutdown()
+
 	// Start UTXO indexer
-	indexer := indexer.NewIndexer(ctx, cfg.Indexer, logger, btcClient, tmdb)
-	indexer.Sync()
+	var idx *indexer.Indexer
+	if withIndexer {
+		idx = indexer.NewIndexer(ctx, a.cfg.Indexer, logger, btcClient, a.db)
+		idx.Sync()
+	}
 
 	// Start HTTP server
-	httpServer := server.NewServer(cfg.Server, logger, tmdb, btcClient)
-	httpServer.Run()
+	var httpServer *server.Server
+	if withServer {
+		httpServer = server.NewServer(a.cfg.Server, logger, a.db, btcClient)
+		httpServer.Run()
+	}
 
 	// Wait for signal
 	<-sigCh
@@ -84,13 +183,439 @@ func main() {
 
 	// Shutdown context
 	cancel()
-	<-indexer.Finish //确保没在存储时退出程序
+	if idx != nil {
+		<-idx.Finish //确保没在存储时退出程序
+	}
 
 	// Shutdown HTTP server
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer shutdownCancel()
+	if httpServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("shutting down HTTP server: %w", err)
+		}
+	}
+	return nil
+}
+
+func reindexCmd(args []string) error {
+	fs, conf := newFlagSet("reindex")
+	from := fs.Int64("from", -1, "reindex from this height, 0 rebuilds from genesis")
+	fs.Parse(args)
+	if *from < 0 {
+		return fmt.Errorf("--from is required")
+	}
+
+	a, err := openApp(*conf, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if *from <= 1 {
+		a.logger.Info("Reindex::Reset")
+		if err := a.db.Reset(); err != nil {
+			return err
+		}
+	} else {
+		res, err := a.db.Rollback(*from - 1)
+		if err != nil {
+			return err
+		}
+		a.logRollback(res)
+	}
+	return a.serve(true, false)
+}
+
+func rollbackCmd(args []string) error {
+	fs, conf := newFlagSet("rollback")
+	to := fs.Int64("to", -1, "rollback the index to this height")
+	fs.Parse(args)
+	if *to < 0 {
+		return fmt.Errorf("--to is required")
+	}
+
+	a, err := openApp(*conf, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	res, err := a.db.Rollback(*to)
+	if err != nil {
+		return err
+	}
+	a.logRollback(res)
+	return printJSON(res)
+}
+
+func (a *app) logRollback(res *model.RollbackResult) {
+	a.logger.Info("Rollback::Info",
+		zap.Int64("from", res.From),
+		zap.Int64("to", res.To),
+		zap.Int("deleted", res.Deleted),
+		zap.Int("restored", res.Restored))
+	if res.UnknownSpendHeight > 0 {
+		a.logger.Warn("Rollback::UnknownSpendHeight",
+			zap.Int("count", res.UnknownSpendHeight),
+			zap.String("hint", "records indexed before spend heights were stored stay spent, use reindex --from 0 to rebuild"))
+	}
+}
+
+func verifyCmd(args []string) error {
+	fs, conf := newFlagSet("verify")
+	repair := fs.Bool("repair", false, "remove invalid utxo set members and recompute balances")
+	fs.Parse(args)
+
+	a, err := openApp(*conf, !*repair)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	var last time.Time
+	report, err := a.db.Verify(context.Background(), *repair, func(processed, total int64) {
+		if time.Since(last) < 10*time.Second && processed != total {
+			return
+		}
+		last = time.Now()
+		a.logger.Info("Verify::Progress", zap.Int64("processed", processed), zap.Int64("total", total))
+	})
+	if err != nil {
+		return err
+	}
+	if err := printJSON(report); err != nil {
+		return err
+	}
+	if report.IssueCount > 0 && !*repair {
+		return fmt.Errorf("%d issues found", report.IssueCount)
+	}
+	return nil
+}
+
+func statsCmd(args []string) error {
+	fs, conf := newFlagSet("stats")
+	top := fs.Int("top", 10, "number of addresses with the most utxos to list, 0 to skip")
+	fs.Parse(args)
+
+	a, err := openApp(*conf, true)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	stats, err := a.db.Stats(*top)
+	if err != nil {
+		return err
+	}
+	return printJSON(stats)
+}
+
+func compactCmd(args []string) error {
+	fs, conf := newFlagSet("compact")
+	fs.Parse(args)
+
+	a, err := openApp(*conf, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	before, err := a.db.DiskSize()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := a.db.Compact(); err != nil {
+		return err
+	}
+	after, err := a.db.DiskSize()
+	if err != nil {
+		return err
+	}
+	a.logger.Info("Compact::Info", zap.Any("size_before", before), zap.Any("size_after", after), zap.Duration("ttl", time.Since(start)))
+	return nil
+}
+
+func pruneCmd(args []string) error {
+	fs, conf := newFlagSet("prune")
+	fs.Parse(args)
+
+	a, err := openApp(*conf, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
 
-	if err := httpServer.Shutdown(shutdownCtx); err != nil {
-		logger.Fatal("Error shutting down HTTP server", zap.Error(err))
+	start := time.Now()
+	n, err := a.db.PruneAll()
+	if err != nil {
+		return err
+	}
+	a.logger.Info("Prune::Info", zap.Int("deleted", n), zap.Duration("ttl", time.Since(start)))
+	return nil
+}
+
+func checkUTXOSetCmd(args []string) error {
+	fs, conf := newFlagSet("check-utxoset")
+	fs.Parse(args)
+
+	a, err := openApp(*conf, true)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	info, err := a.db.GetUTXOSetInfo()
+	if err != nil {
+		return err
+	}
+	btcClient, err := rpc.NewPool(a.cfg.RPC, a.logger)
+	if err != nil {
+		return fmt.Errorf("initializing Bitcoin RPC client: %w", err)
+	}
+	defer btcClient.Shutdown()
+
+	node, err := rpc.GetTxOutSetInfo(btcClient, info.Height)
+	if err != nil {
+		return fmt.Errorf("gettxoutsetinfo: %w", err)
+	}
+	diffs := node.Diff(info)
+	if err := printJSON(map[string]interface{}{
+		"index": info,
+		"node":  node,
+		"diffs": diffs,
+	}); err != nil {
+		return err
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("utxo set mismatch at height %d", info.Height)
+	}
+	return nil
+}
+
+func loadSnapshotCmd(args []string) error {
+	fs, conf := newFlagSet("load-snapshot")
+	file := fs.String("file", "", "snapshot file written by bitcoin-cli dumptxoutset")
+	height := fs.Int64("height", 0, "snapshot base height, queried from the node by base blockhash when omitted")
+	batch := fs.Int("batch", snapshot.DefaultBatchSize, "utxos per write batch")
+	fs.Parse(args)
+	if *file == "" {
+		return fmt.Errorf("--file is required")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := snapshot.NewReader(f)
+	if err != nil {
+		return err
+	}
+	meta := r.Metadata()
+	if meta.Version > 0 && meta.Network != wire.MainNet {
+		return fmt.Errorf("snapshot network %s is not mainnet", meta.Network)
+	}
+
+	a, err := openApp(*conf, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if *height <= 0 {
+		if *height, err = a.snapshotHeight(meta.BaseHash.String()); err != nil {
+			return fmt.Errorf("query snapshot height: %w", err)
+		}
+	}
+	a.logger.Info("LoadSnapshot::Start",
+		zap.String("base", meta.BaseHash.String()),
+		zap.Int64("height", *height),
+		zap.Uint64("coins", meta.CoinsCount))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	start, last := time.Now(), time.Now()
+	err = snapshot.Load(ctx, a.db, r, *height, *batch, func(loaded, total uint64) {
+		if time.Since(last) < 10*time.Second && loaded != total {
+			return
+		}
+		last = time.Now()
+		a.logger.Info("LoadSnapshot::Progress", zap.Uint64("loaded", loaded), zap.Uint64("total", total))
+	})
+	if err != nil {
+		return err
+	}
+	a.logger.Info("LoadSnapshot::Done", zap.Int64("height", *height), zap.Duration("ttl", time.Since(start)))
+	return nil
+}
+
+func dumpSnapshotCmd(args []string) error {
+	fs, conf := newFlagSet("dump-snapshot")
+	out := fs.String("out", "", "snapshot file path")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("--out is required")
+	}
+
+	a, err := openApp(*conf, true)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	btcClient, err := rpc.NewPool(a.cfg.RPC, a.logger)
+	if err != nil {
+		return fmt.Errorf("initializing Bitcoin RPC client: %w", err)
+	}
+	defer btcClient.Shutdown()
+
+	tmp := *out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	var last time.Time
+	meta, height, err := snapshot.Dump(ctx, a.db, f, wire.MainNet, btcClient.GetBlockHash, func(written, total uint64) {
+		if time.Since(last) < 10*time.Second && written != total {
+			return
+		}
+		last = time.Now()
+		a.logger.Info("DumpSnapshot::Progress", zap.Uint64("written", written), zap.Uint64("total", total))
+	})
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"base_hash":     meta.BaseHash.String(),
+		"base_height":   height,
+		"coins_written": meta.CoinsCount,
+		"path":          *out,
+	})
+}
+
+// snapshotHeight 通过getblockheader查询快照基准区块高度
+func (a *app) snapshotHeight(hash string) (int64, error) {
+	btcClient, err := rpc.NewPool(a.cfg.RPC, a.logger)
+	if err != nil {
+		return 0, err
+	}
+	defer btcClient.Shutdown()
+
+	param, _ := json.Marshal(hash)
+	raw, err := btcClient.RawRequest("getblockheader", []json.RawMessage{param})
+	if err != nil {
+		return 0, err
+	}
+	var header struct {
+		Height int64 `json:"height"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return 0, err
+	}
+	return header.Height, nil
+}
+
+func exportCmd(args []string) error {
+	fs, conf := newFlagSet("export")
+	out := fs.String("out", "", "export file path")
+	fs.Parse(args)
+	if *out == "" {
+		return fmt.Errorf("--out is required")
+	}
+
+	a, err := openApp(*conf, true)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	// 先写临时文件，完成后重命名，避免留下不完整的导出文件
+	tmp := *out + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
 	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	info, err := a.db.Export(ctx, f, a.recordProgress("Export::Progress"))
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		return err
+	}
+	return printJSON(info)
+}
+
+func importCmd(args []string) error {
+	fs, conf := newFlagSet("import")
+	in := fs.String("in", "", "file written by the export command")
+	fs.Parse(args)
+	if *in == "" {
+		return fmt.Errorf("--in is required")
+	}
+
+	f, err := os.Open(*in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	a, err := openApp(*conf, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	info, err := a.db.Import(ctx, f, a.recordProgress("Import::Progress"))
+	if err != nil {
+		return err
+	}
+	return printJSON(info)
+}
+
+// recordProgress 每10秒输出一次进度
+func (a *app) recordProgress(msg string) db.ExportProgress {
+	var last time.Time
+	return func(records int64) {
+		if time.Since(last) < 10*time.Second {
+			return
+		}
+		last = time.Now()
+		a.logger.Info(msg, zap.Int64("records", records))
+	}
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
 }
@@ -16,7 +16,11 @@ func GetAddressByScriptPubKeyResult(sp btcjson.ScriptPubKeyResult) (string, erro
 	if err != nil {
 		return "", err
 	}
+	return GetAddressByScript(script)
+}
 
+// GetAddressByScript 从锁定脚本获取地址
+func GetAddressByScript(script []byte) (string, error) {
 	// 解析脚本
 	_, addresses, _, err := txscript.ExtractPkScriptAddrs(script, &chaincfg.MainNetParams)
 	if err != nil {
@@ -28,5 +32,4 @@ func GetAddressByScriptPubKeyResult(sp btcjson.ScriptPubKeyResult) (string, erro
 	}
 
 	return "", fmt.Errorf("unable to extract address from scriptPubKeyResult")
-
 }
@@ -21,7 +21,8 @@ func LogMiddleware(logger *zap.Logger) gin.HandlerFunc {
 
 		c.Next()
 		duration := time.Since(start)
-		if c.Writer.Status() != http.StatusOK {
+
+		if c.Writer.Status() >= http.StatusInternalServerError {
 			logger.Error(path,
 				zap.Int("status", c.Writer.Status()),
 				zap.String("method", c.Request.Method),
@@ -40,14 +41,30 @@ func LogMiddleware(logger *zap.Logger) gin.HandlerFunc {
 	}
 }
 
-// CORSMiddleware 跨域中间件
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware 跨域中间件 allowOrigins为空时允许所有来源
+func CORSMiddleware(allowOrigins []string) gin.HandlerFunc {
+	allowed := make(map[string]struct{}, len(allowOrigins))
+	for _, origin := range allowOrigins {
+		allowed[origin] = struct{}{}
+	}
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		if len(allowed) == 0 {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Writer.Header().Add("Vary", "Origin")
+			origin := c.GetHeader("Origin")
+			if _, ok := allowed[origin]; ok {
+				c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+		}
 		c.Writer.Header().Set("Access-Control-Max-Age", "86400")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, UPDATE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max, If-None-Match, X-API-Key, X-Admin-Key")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, ETag, Cache-Control, Retry-After")
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
 		c.Next()
 	}
 }
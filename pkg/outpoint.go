@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TxidSize 交易哈希字节数
+const TxidSize = 32
+
+// EncodeOutpoint 二进制outpoint key: prefix + txid(32字节) + uvarint(vout)
+// txid按十六进制显示顺序解码，key的排序与txid字符串一致；prefix可为空
+func EncodeOutpoint(prefix []byte, txid string, vout uint32) ([]byte, error) {
+	if len(txid) != TxidSize*2 {
+		return nil, fmt.Errorf("invalid txid:%s", txid)
+	}
+	key := make([]byte, len(prefix)+TxidSize, len(prefix)+TxidSize+binary.MaxVarintLen32)
+	copy(key, prefix)
+	if _, err := hex.Decode(key[len(prefix):], []byte(txid)); err != nil {
+		return nil, fmt.Errorf("invalid txid:%s", txid)
+	}
+	return binary.AppendUvarint(key, uint64(vout)), nil
+}
+
+// DecodeOutpoint 解析EncodeOutpoint生成的key prefix需与编码时一致
+func DecodeOutpoint(prefix []byte, key []byte) (string, uint32, error) {
+	if !bytes.HasPrefix(key, prefix) || len(key) < len(prefix)+TxidSize+1 {
+		return "", 0, fmt.Errorf("invalid outpoint key:%x", key)
+	}
+	rest := key[len(prefix)+TxidSize:]
+	vout, n := binary.Uvarint(rest)
+	if n != len(rest) || vout > 0xffffffff {
+		return "", 0, fmt.Errorf("invalid outpoint key:%x", key)
+	}
+	return hex.EncodeToString(key[len(prefix) : len(prefix)+TxidSize]), uint32(vout), nil
+}
+
+// ParseOutpoint 解析txid:vout格式的outpoint
+func ParseOutpoint(s string) (string, uint32, error) {
+	txid, index, ok := strings.Cut(s, ":")
+	if !ok || len(txid) != TxidSize*2 {
+		return "", 0, fmt.Errorf("invalid outpoint:%s", s)
+	}
+	vout, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid outpoint:%s", s)
+	}
+	return txid, uint32(vout), nil
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// NewServerTLSConfig 服务端TLS配置 clientCAFile不为空时校验客户端证书，
+// requireClientCert为true时客户端必须提供证书(mTLS)
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if len(clientCAFile) > 0 {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if requireClientCert {
+		return nil, fmt.Errorf("client_ca_file is required to verify client certificates")
+	}
+	return conf, nil
+}
+
+// NewClientTLSConfig 客户端TLS配置 caFile为空时使用系统根证书，certFile/keyFile用于mTLS
+func NewClientTLSConfig(caFile, certFile, keyFile, serverName string) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+	if len(caFile) > 0 {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = pool
+	}
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
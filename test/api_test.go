@@ -2,12 +2,14 @@ package test
 
 import (
 	"encoding/json"
-	"fmt"
+	"net/http"
 	"testing"
 
+	"github.com/btcsuite/btcd/wire"
 	"github.com/guonaihong/gout"
 	"github.com/shopspring/decimal"
 	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/test/mocknode"
 )
 
 type commonRepley struct {
@@ -16,16 +18,32 @@ type commonRepley struct {
 	Msg  string          `json:"msg,omitempty"`
 }
 
+// newTestAPI 模拟节点上地址2有25个utxo，索引同步完成后返回HTTP服务地址
+func newTestAPI(t testing.TB) string {
+	script := addressScript(t, testAddress2)
+	chain := mocknode.NewChain(addressScript(t, testAddress))
+	cb := chain.Mine().Transactions[0]
+	outs := make([]*wire.TxOut, 0, 25)
+	for i := 0; i < 25; i++ {
+		outs = append(outs, wire.NewTxOut(btc(1)+int64(i), script))
+	}
+	chain.Mine(mocknode.Spend([]wire.OutPoint{mocknode.OutPoint(cb, 0)}, outs...))
+
+	p := newPipeline(t, mocknode.New(t, chain))
+	p.waitHeight(2)
+	return p.api.URL
+}
+
 func TestApiUTXO(t *testing.T) {
+	baseURL := newTestAPI(t)
 	utxoLen := 0
 	totalSize := 0
 	balance := decimal.Decimal{}
 	balance2 := decimal.Decimal{}
 	for i := 0; ; i++ {
-		ur, err := getUtxo("14cZMQk89mRYQkDEj8Rn25AnGoBi5H6uer", i, 100)
+		ur, err := getUtxo(baseURL, testAddress2, i, 10)
 		if err != nil {
-			fmt.Println(err)
-			return
+			t.Fatal(err)
 		}
 		if len(ur.Utxos) == 0 {
 			totalSize = ur.TotalSize
@@ -41,23 +59,28 @@ func TestApiUTXO(t *testing.T) {
 		}
 	}
 
-	fmt.Println(utxoLen, totalSize)
-	fmt.Println(balance.StringFixed(8), balance2.StringFixed(8))
+	if utxoLen != 25 || totalSize != 25 {
+		t.Fatalf("paged %d utxos, total_size %d", utxoLen, totalSize)
+	}
+	if !balance.Equal(balance2) || balance2.StringFixed(8) != "25.00000300" {
+		t.Fatalf("paged balance %s, reply balance %s", balance.StringFixed(8), balance2.StringFixed(8))
+	}
 }
 
 func BenchmarkApiHeight(b *testing.B) {
+	baseURL := newTestAPI(b)
+	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_, err := getHeight()
+		_, err := getHeight(baseURL)
 		if err != nil {
 			b.Error(err)
 		}
 	}
 }
 
-func getHeight() (int64, error) {
+func getHeight(baseURL string) (int64, error) {
 	reply := &commonRepley{}
-	if err := gout.POST("http://192.168.0.21:3000/height").BindJSON(reply).Do(); err != nil {
-		fmt.Println(err)
+	if err := gout.POST(baseURL + "/height").BindJSON(reply).Do(); err != nil {
 		return 0, err
 	}
 	hr := &model.HeightReply{}
@@ -65,21 +88,111 @@ func getHeight() (int64, error) {
 	return hr.StoreHeight, err
 }
 
-func getUtxo(address string, page, pageSize int) (*model.UTXOReply, error) {
+func getUtxo(baseURL, address string, page, pageSize int) (*model.UTXOReply, error) {
 	reply := &commonRepley{}
 	req := &model.UTXORequest{
 		Address:  address,
 		Page:     page,
 		PageSize: pageSize,
 	}
-	if err := gout.POST("http://192.168.0.21:3000/utxo").SetJSON(req).BindJSON(reply).Do(); err != nil {
-		fmt.Println(err)
+	if err := gout.POST(baseURL + "/utxo").SetJSON(req).BindJSON(reply).Do(); err != nil {
 		return nil, err
 	}
 	ur := &model.UTXOReply{}
 	if err := json.Unmarshal(reply.Data, ur); err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 	return ur, nil
 }
+
+// apiGet GET请求 etag非空时带If-None-Match
+func apiGet(t *testing.T, url, etag string) (*http.Response, *commonRepley) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reply := &commonRepley{}
+	if resp.StatusCode != http.StatusNotModified {
+		if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp, reply
+}
+
+func TestApiGetRoutes(t *testing.T) {
+	chain := mocknode.NewChain(addressScript(t, testAddress))
+	cb := chain.Mine().Transactions[0]
+	node := mocknode.New(t, chain)
+	p := newPipeline(t, node)
+	p.waitHeight(1)
+	p.stop()
+	base := p.api.URL
+
+	// /height的ETag包含节点高度，节点出新块后不返回304
+	resp, reply := apiGet(t, base+"/height", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("height status %d etag %q", resp.StatusCode, etag)
+	}
+	if resp, _ := apiGet(t, base+"/height", etag); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", resp.StatusCode)
+	}
+	node.Update(func(c *mocknode.Chain) { c.Mine() })
+	resp, reply = apiGet(t, base+"/height", etag)
+	hr := &model.HeightReply{}
+	if err := json.Unmarshal(reply.Data, hr); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || hr.StoreHeight != 1 || hr.NodeHeight != 2 {
+		t.Fatalf("height after new node block: status %d %+v", resp.StatusCode, hr)
+	}
+
+	// 地址及outpoint按存储高度缓存
+	addrURL := base + "/address/" + testAddress + "/utxo"
+	resp, reply = apiGet(t, addrURL, "")
+	ur := &model.UTXOReply{}
+	if err := json.Unmarshal(reply.Data, ur); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") != `"h1"` || ur.TotalSize != 1 {
+		t.Fatalf("address utxo status %d etag %q %+v", resp.StatusCode, resp.Header.Get("ETag"), ur)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "public, max-age=30" {
+		t.Fatalf("unexpected Cache-Control without auth %q", cc)
+	}
+	if resp, _ := apiGet(t, addrURL, `W/"h1"`); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for weak etag, got %d", resp.StatusCode)
+	}
+	if resp, _ := apiGet(t, addrURL, `"h0"`); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 for stale etag, got %d", resp.StatusCode)
+	}
+
+	txid := cb.TxHash().String()
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/outpoint/" + txid + "/0", http.StatusOK},
+		{"/outpoint/" + txid + "/1", http.StatusNotFound},
+		{"/outpoint/" + txid + "/x", http.StatusBadRequest},
+		{"/outpoint/1234/0", http.StatusBadRequest},
+		{"/address/invalid/utxo", http.StatusBadRequest},
+		{"/address/" + testAddress + "/utxo?page_size=x", http.StatusBadRequest},
+		{"/address/" + testAddress + "/utxo?cursor=bad", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if resp, reply := apiGet(t, base+c.path, ""); resp.StatusCode != c.status || reply.Code != c.status {
+			t.Fatalf("%s: status %d code %d, want %d", c.path, resp.StatusCode, reply.Code, c.status)
+		}
+	}
+}
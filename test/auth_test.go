@@ -0,0 +1,195 @@
+package test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/server"
+	"go.uber.org/zap"
+)
+
+const testOutpointPath = "/outpoint/282b861e411dc3b61aa06e9e13abf49bce5c571e21a19c37f738244cee33b778/0"
+
+func newAuthServer(t *testing.T) http.Handler {
+	return newAuthHandler(newMemDB(t), 0)
+}
+
+func newAuthHandler(mdb *db.DB, keyTTL int) http.Handler {
+	s := server.NewServer(&config.ServerConfig{
+		Auth: &config.AuthConfig{
+			Enabled:  true,
+			AdminKey: "admin",
+			KeyTTL:   keyTTL,
+			Keys: []*config.APIKeyConfig{
+				{Key: "static", Name: "static", Rate: 1000, Burst: 1000, Quota: 2},
+			},
+		},
+	}, zap.NewNop(), mdb, nil)
+	return s.Handler()
+}
+
+func doRequest(h http.Handler, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthQuota(t *testing.T) {
+	h := newAuthServer(t)
+
+	if w := doRequest(h, http.MethodGet, testOutpointPath, nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without key, got %d", w.Code)
+	}
+	if w := doRequest(h, http.MethodGet, "/healthz", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected public healthz, got %d", w.Code)
+	}
+
+	key := map[string]string{"X-API-Key": "static"}
+	for i := 0; i < 2; i++ {
+		if w := doRequest(h, http.MethodGet, testOutpointPath, key); w.Code != http.StatusNotFound {
+			t.Fatalf("request %d: expected 404, got %d", i, w.Code)
+		}
+	}
+	if w := doRequest(h, http.MethodGet, testOutpointPath, key); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected quota exceeded, got %d", w.Code)
+	}
+}
+
+// TestAuthCacheControl 认证后的响应不允许共享缓存
+func TestAuthCacheControl(t *testing.T) {
+	h := newAuthServer(t)
+	w := doRequest(h, http.MethodGet, "/address/"+testAddress+"/utxo", map[string]string{"X-API-Key": "static"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("address utxo: %d %s", w.Code, w.Body.String())
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "private, max-age=30" {
+		t.Fatalf("expected private Cache-Control with auth, got %q", cc)
+	}
+	if vary := w.Header().Get("Vary"); !strings.Contains(vary, "X-API-Key") || !strings.Contains(vary, "Authorization") {
+		t.Fatalf("unexpected Vary %q", vary)
+	}
+}
+
+func TestAuthAdminKeys(t *testing.T) {
+	h := newAuthServer(t)
+	admin := map[string]string{"X-Admin-Key": "admin"}
+
+	if w := doRequest(h, http.MethodPost, "/admin/keys", nil); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without admin key, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/keys", jsonBody(t, map[string]interface{}{"name": "partner", "rate": 1, "burst": 1}))
+	req.Header.Set("X-Admin-Key", "admin")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("create key: %d %s", w.Code, w.Body.String())
+	}
+	reply := &commonRepley{}
+	if err := json.Unmarshal(w.Body.Bytes(), reply); err != nil {
+		t.Fatal(err)
+	}
+	created := struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}{}
+	if err := json.Unmarshal(reply.Data, &created); err != nil {
+		t.Fatal(err)
+	}
+
+	key := map[string]string{"Authorization": "Bearer " + created.Key}
+	if w := doRequest(h, http.MethodGet, testOutpointPath, key); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with created key, got %d", w.Code)
+	}
+	if w := doRequest(h, http.MethodGet, testOutpointPath, key); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected rate limit, got %d", w.Code)
+	}
+
+	if w := doRequest(h, http.MethodDelete, "/admin/keys/"+created.ID, admin); w.Code != http.StatusOK {
+		t.Fatalf("revoke key: %d", w.Code)
+	}
+	if w := doRequest(h, http.MethodGet, testOutpointPath, key); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after revoke, got %d", w.Code)
+	}
+}
+
+// TestAuthKeyTTL 共享数据库的两个实例：一个实例吊销的key在另一个实例key_ttl后失效，不存在的key缓存后重新读取
+func TestAuthKeyTTL(t *testing.T) {
+	mdb := newMemDB(t)
+	h1, h2 := newAuthHandler(mdb, 1), newAuthHandler(mdb, 1)
+	admin := map[string]string{"X-Admin-Key": "admin"}
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/keys", jsonBody(t, map[string]interface{}{"name": "partner"}))
+	req.Header.Set("X-Admin-Key", "admin")
+	w := httptest.NewRecorder()
+	h1.ServeHTTP(w, req)
+	reply := &commonRepley{}
+	if err := json.Unmarshal(w.Body.Bytes(), reply); err != nil {
+		t.Fatal(err)
+	}
+	created := struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}{}
+	if err := json.Unmarshal(reply.Data, &created); err != nil {
+		t.Fatal(err)
+	}
+	key := map[string]string{"X-API-Key": created.Key}
+	for _, h := range []http.Handler{h1, h2} {
+		if w := doRequest(h, http.MethodGet, testOutpointPath, key); w.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 with created key, got %d", w.Code)
+		}
+	}
+
+	if w := doRequest(h1, http.MethodDelete, "/admin/keys/"+created.ID, admin); w.Code != http.StatusOK {
+		t.Fatalf("revoke key: %d", w.Code)
+	}
+	if w := doRequest(h1, http.MethodGet, testOutpointPath, key); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after revoke, got %d", w.Code)
+	}
+	// 另一个实例在ttl内仍使用缓存
+	if w := doRequest(h2, http.MethodGet, testOutpointPath, key); w.Code != http.StatusNotFound {
+		t.Fatalf("expected cached key within ttl, got %d", w.Code)
+	}
+
+	late := map[string]string{"X-API-Key": "late"}
+	if w := doRequest(h2, http.MethodGet, testOutpointPath, late); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for unknown key, got %d", w.Code)
+	}
+	sum := sha256.Sum256([]byte("late"))
+	if err := mdb.PutAPIKey(hex.EncodeToString(sum[:]), &db.ApiKey{Name: "late"}); err != nil {
+		t.Fatal(err)
+	}
+	if w := doRequest(h2, http.MethodGet, testOutpointPath, late); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected cached missing key, got %d", w.Code)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if w := doRequest(h2, http.MethodGet, testOutpointPath, key); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 after ttl, got %d", w.Code)
+	}
+	if w := doRequest(h2, http.MethodGet, testOutpointPath, late); w.Code != http.StatusNotFound {
+		t.Fatalf("expected key added by other instance after ttl, got %d", w.Code)
+	}
+}
+
+func jsonBody(t *testing.T, v interface{}) *bytes.Reader {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(b)
+}
@@ -0,0 +1,73 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"go.uber.org/zap"
+)
+
+func TestWriteCache(t *testing.T) {
+	for _, mode := range []string{db.ModeArchive, db.ModePrune} {
+		t.Run(mode, func(t *testing.T) {
+			conf := &config.DBConfig{DBType: db.MemDBBackend, Mode: mode, PruneDepth: 1}
+			direct, err := db.NewDB(conf, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer direct.Close()
+			cached, err := db.NewDB(conf, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cached.Close()
+			cached.SetCache(1000, 0)
+
+			storeSpendChain(t, direct)
+			storeSpendChain(t, cached)
+
+			// 刷新前数据库仍为初始状态
+			if sheight, err := cached.GetStoreHeight(); err != nil || sheight != 0 {
+				t.Fatalf("store height before flush %d %v", sheight, err)
+			}
+			if outpointExists(t, cached, "a3") {
+				t.Fatal("a3:0 should not be visible before flush")
+			}
+			if err := cached.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if sheight, err := cached.GetStoreHeight(); err != nil || sheight != 4 {
+				t.Fatalf("store height after flush %d %v", sheight, err)
+			}
+			want, err := direct.GetUTXOSetInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cached.GetUTXOSetInfo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != *want {
+				t.Fatalf("utxo set mismatch: got %+v want %+v", got, want)
+			}
+			for _, addr := range []string{testAddress, testAddress2} {
+				wb, wn := balanceOf(t, direct, addr)
+				gb, gn := balanceOf(t, cached, addr)
+				if wb != gb || wn != gn {
+					t.Fatalf("%s balance %s/%d want %s/%d", addr, gb, gn, wb, wn)
+				}
+			}
+			// 同一缓存内产生并花费的记录 archive模式保留，prune模式超过保留深度不写入
+			for _, txid := range []string{"c1", "a2"} {
+				if exists := outpointExists(t, cached, txid); exists != (mode == db.ModeArchive) {
+					t.Fatalf("%s:0 exists=%v in %s mode", txid, exists, mode)
+				}
+			}
+			if !outpointExists(t, cached, "a3") {
+				t.Fatal("a3:0 should be stored")
+			}
+		})
+	}
+}
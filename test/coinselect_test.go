@@ -0,0 +1,119 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/wx-shi/utxo-indexer/internal/wallet"
+)
+
+const testAddress = "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"
+
+func testCoins(t *testing.T, values ...int64) []*wallet.Coin {
+	addr, err := wallet.DecodeAddress(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := make([]*wallet.Coin, 0, len(values))
+	for i, v := range values {
+		coins = append(coins, &wallet.Coin{TxID: "tx", Index: uint32(i), Address: addr, Value: v})
+	}
+	return coins
+}
+
+func TestSelectCoinsBnB(t *testing.T) {
+	coins := testCoins(t, 100000, 50000, 30000, 20000)
+	addr := coins[0].Address
+	// 50000+30000 的有效金额恰好支付 target+基础手续费，无需找零
+	feeRate := 1.0
+	inputFee := wallet.Fee(feeRate, wallet.InputVSize(addr))
+	base := wallet.TxVSize(nil, []btcutil.Address{addr}) + 1 //隔离见证marker/flag
+	target := 80000 - 2*inputFee - wallet.Fee(feeRate, base)
+
+	res, err := wallet.SelectCoins(coins, &wallet.Params{
+		Target:  target,
+		FeeRate: feeRate,
+		Outputs: []btcutil.Address{addr},
+		Change:  addr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Algorithm != wallet.AlgorithmBnB || res.Change != 0 || res.Total != 80000 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+// TestSelectCoinsLegacyOverhead 候选中有隔离见证utxo但只选中非隔离见证输入时，不计算marker/flag
+func TestSelectCoinsLegacyOverhead(t *testing.T) {
+	legacy, err := wallet.DecodeAddress(testAddress2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	coins := append(testCoins(t, 200000),
+		&wallet.Coin{TxID: "legacy", Index: 0, Address: legacy, Value: 50000},
+		&wallet.Coin{TxID: "legacy", Index: 1, Address: legacy, Value: 30000})
+	out := coins[0].Address
+	feeRate := 1.0
+	ins := []btcutil.Address{legacy, legacy}
+	vsize := wallet.TxVSize(ins, []btcutil.Address{out})
+	target := 80000 - wallet.Fee(feeRate, vsize)
+
+	res, err := wallet.SelectCoins(coins, &wallet.Params{
+		Target:  target,
+		FeeRate: feeRate,
+		Outputs: []btcutil.Address{out},
+		Change:  out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Algorithm != wallet.AlgorithmBnB || res.Total != 80000 || res.Change != 0 || res.VSize != vsize {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	for _, c := range res.Inputs {
+		if c.TxID != "legacy" {
+			t.Fatalf("unexpected input %+v", c)
+		}
+	}
+}
+
+func TestSelectCoinsKnapsack(t *testing.T) {
+	coins := testCoins(t, 100000, 50000, 30000)
+	addr := coins[0].Address
+
+	res, err := wallet.SelectCoins(coins, &wallet.Params{
+		Target:  60000,
+		FeeRate: 2,
+		Outputs: []btcutil.Address{addr},
+		Change:  addr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Algorithm != wallet.AlgorithmKnapsack || res.Change < wallet.DustLimit {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if res.Total != 60000+res.Fee+res.Change {
+		t.Fatalf("unbalanced result %+v", res)
+	}
+	if res.Fee < wallet.Fee(2, res.VSize) {
+		t.Fatalf("fee below rate %+v", res)
+	}
+}
+
+func TestSelectCoinsInsufficient(t *testing.T) {
+	coins := testCoins(t, 1000, 2000)
+	addr := coins[0].Address
+
+	_, err := wallet.SelectCoins(coins, &wallet.Params{
+		Target:  5000,
+		FeeRate: 1,
+		Outputs: []btcutil.Address{addr},
+		Change:  addr,
+	})
+	if !errors.Is(err, wallet.ErrInsufficientFunds) {
+		t.Fatalf("expected insufficient funds, got %v", err)
+	}
+}
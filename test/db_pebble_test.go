@@ -0,0 +1,9 @@
+//go:build pebbledb
+
+package test
+
+import tmdb "github.com/cosmos/cosmos-db"
+
+func init() {
+	testBackends = append(testBackends, string(tmdb.PebbleDBBackend))
+}
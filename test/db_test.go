@@ -1,104 +1,199 @@
 package test
 
-//
-//var (
-//	logger, _   = pkg.NewLogger("info")
-//	badgerDB, _ = db.NewBadgerDB(&config.BadgerDBConfig{
-//		Directory: "../tmp",
-//	}, logger)
-//)
-//
-//func TestDBHeight(t *testing.T) {
-//	defer logger.Sync()
-//	defer badgerDB.Close()
-//
-//	height, err := badgerDB.GetStoreHeight()
-//	t.Logf("%d %v", height, err)
-//}
-//
-//func TestDBUtxo(t *testing.T) {
-//	info := &db.UtxoInfo{}
-//	err := badgerDB.View(func(txn *badger.Txn) error {
-//
-//		item, err := txn.Get([]byte("u:282b861e411dc3b61aa06e9e13abf49bce5c571e21a19c37f738244cee33b778:0"))
-//		if err != nil && err != badger.ErrKeyNotFound {
-//			return err
-//		}
-//
-//		if err != badger.ErrKeyNotFound {
-//			val, err := item.ValueCopy(nil)
-//			if err != nil {
-//				return err
-//			}
-//			return proto.UnmarshalMerge(val, info)
-//		}
-//
-//		return nil
-//	})
-//	t.Logf("debug:%+v %v", info, err)
-//}
-//
-//func TestDBAmount(t *testing.T) {
-//	badgerDB.View(func(txn *badger.Txn) error {
-//		it := txn.NewIterator(badger.DefaultIteratorOptions)
-//		defer it.Close()
-//		prefix := []byte("ab:")
-//		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-//			item := it.Item()
-//			k := item.Key()
-//			a1 := decimal.Decimal{}
-//			err := item.Value(func(v []byte) error {
-//				pf, err := strconv.ParseFloat(string(v), 64)
-//				if err != nil {
-//					return err
-//				}
-//				a1 = decimal.NewFromFloat(pf)
-//				return nil
-//			})
-//			if err != nil {
-//				return err
-//			}
-//
-//			addr := strings.Split(string(k), ":")[1]
-//			aukey := "au:" + addr
-//			auitem, err := txn.Get([]byte(aukey))
-//			if err != nil {
-//				return err
-//			}
-//			auval, err := auitem.ValueCopy(nil)
-//			if err != nil {
-//				return err
-//			}
-//			ls := &db.StringSet{}
-//			if err := proto.Unmarshal(auval, ls); err != nil {
-//				return err
-//			}
-//
-//			amount := decimal.Decimal{}
-//			for _, uk := range ls.Members {
-//				ui, err := txn.Get([]byte(uk))
-//				if err != nil {
-//					return err
-//				}
-//				uv, err := ui.ValueCopy(nil)
-//				if err != nil {
-//					return err
-//				}
-//				u := &db.UtxoInfo{}
-//				if err := proto.Unmarshal(uv, u); err != nil {
-//					return err
-//				}
-//				if u.Spend != nil {
-//					continue
-//				}
-//				amount = amount.Add(decimal.NewFromFloat(u.Value))
-//			}
-//
-//			if a1.StringFixed(8) != amount.StringFixed(8) {
-//				t.Fatalf("%s 余额异常", addr)
-//			}
-//			t.Logf("%s %s %s\n", addr, a1.StringFixed(8), amount.StringFixed(8))
-//		}
-//		return nil
-//	})
-//}
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"go.uber.org/zap"
+)
+
+// testBackends 存储后端测试覆盖的类型 pebbledb需要-tags pebbledb
+var testBackends = []string{db.MemDBBackend, string(tmdb.GoLevelDBBackend)}
+
+func openBackend(t *testing.T, backend string) *db.DB {
+	ldb, err := db.NewDB(&config.DBConfig{DBType: backend, Dir: t.TempDir()}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ldb.Close() })
+	return ldb
+}
+
+func TestBackends(t *testing.T) {
+	for _, backend := range testBackends {
+		t.Run(backend, func(t *testing.T) {
+			testStoreBlocks(t, openBackend(t, backend))
+		})
+	}
+}
+
+func TestStoreOptions(t *testing.T) {
+	for _, backend := range testBackends {
+		if backend == db.MemDBBackend {
+			continue
+		}
+		t.Run(backend, func(t *testing.T) {
+			conf := &config.DBConfig{
+				DBType:    backend,
+				Dir:       t.TempDir(),
+				StoreDirs: map[string]string{"utxo": t.TempDir()},
+				Options: map[string]*config.BackendOptions{
+					backend: {BlockCacheMB: 16, WriteBufferMB: 8, Compression: "snappy", BloomBits: 10, MaxOpenFiles: 100},
+				},
+			}
+			ldb, err := db.NewDB(conf, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ldb.Close()
+			testStoreBlocks(t, ldb)
+
+			// utxo存储在store_dirs指定的目录 其余存储在dir
+			for dir, name := range map[string]string{conf.StoreDirs["utxo"]: "utxo.db", conf.Dir: "balance.db"} {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(conf.Dir, "utxo.db")); !os.IsNotExist(err) {
+				t.Fatalf("utxo.db should not be created in dir: %v", err)
+			}
+		})
+	}
+
+	for _, conf := range []*config.DBConfig{
+		{DBType: string(tmdb.GoLevelDBBackend), Options: map[string]*config.BackendOptions{"goleveldb": {Compression: "brotli"}}},
+		{DBType: string(tmdb.GoLevelDBBackend), StoreDirs: map[string]string{"utxos": "x"}},
+	} {
+		conf.Dir = t.TempDir()
+		if ldb, err := db.NewDB(conf, zap.NewNop()); err == nil {
+			ldb.Close()
+			t.Fatalf("expected error for %+v", conf)
+		}
+	}
+}
+
+// testStoreBlocks 存储合成区块后检查余额、地址utxo及花费记录
+func testStoreBlocks(t *testing.T, ldb *db.DB) {
+	// 区块1: coinbase支付给地址1，另一笔交易产生地址2及无地址的输出
+	if err := ldb.Store(nil, []model.Out{
+		testOut("c1", 0, testAddress, 50, 1),
+		testOut("a1", 0, testAddress2, 1.5, 1),
+		testOut("a1", 1, testAddress2, 0.25, 1),
+		testOut("a1", 2, "", 0.1, 1),
+	}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if balance, n := balanceOf(t, ldb, testAddress2); balance != "1.75000000" || n != 2 {
+		t.Fatalf("block 1: address2 balance %s count %d", balance, n)
+	}
+
+	// 区块2: a2花费c1:0及无地址的a1:2，支付给地址2并找零；a3在同一区块内花费a2:1
+	if err := ldb.Store([]model.In{
+		testIn("c1", 0, "a2", 2),
+		testIn("a1", 2, "a2", 2),
+		testIn("a2", 1, "a3", 2),
+	}, []model.Out{
+		testOut("a2", 0, testAddress2, 20, 2),
+		testOut("a2", 1, testAddress, 30, 2),
+		testOut("a3", 0, testAddress, 29.9, 2),
+	}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	sheight, err := ldb.GetStoreHeight()
+	if err != nil || sheight != 2 {
+		t.Fatalf("unexpected store height %d %v", sheight, err)
+	}
+	if balance, n := balanceOf(t, ldb, testAddress); balance != "29.90000000" || n != 1 {
+		t.Fatalf("address1 balance %s count %d", balance, n)
+	}
+	if balance, n := balanceOf(t, ldb, testAddress2); balance != "21.75000000" || n != 3 {
+		t.Fatalf("address2 balance %s count %d", balance, n)
+	}
+	reply, err := ldb.GetUTXOByAddress(&model.UTXORequest{Address: testAddress, PageSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Utxos) != 1 || reply.Utxos[0].TxID != testTxid("a3") || reply.Utxos[0].Index != 0 {
+		t.Fatalf("unexpected address1 utxos %+v", reply.Utxos)
+	}
+
+	// 已花费的记录保留花费信息
+	for _, key := range []string{"c1:0", "a1:2", "a2:1"} {
+		txid, index := key[:len(key)-2], uint32(key[len(key)-1]-'0')
+		info, err := ldb.GetOutpoint(testTxid(txid), index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info == nil || info.Spend == nil || info.Spend.Height != 2 {
+			t.Fatalf("%s should be spent at height 2, got %+v", key, info)
+		}
+	}
+	info, err := ldb.GetOutpoint(testTxid("a2"), 0)
+	if err != nil || info == nil || info.Spend != nil || info.Height != 2 {
+		t.Fatalf("a2:0 should be unspent, got %+v %v", info, err)
+	}
+	if ok, err := ldb.HasUTXO(testAddress); err != nil || !ok {
+		t.Fatalf("address1 should have utxos %v %v", ok, err)
+	}
+
+	// 花光地址1的utxo后删除地址记录
+	if err := ldb.Store([]model.In{testIn("a3", 0, "a4", 3)}, []model.Out{
+		testOut("a4", 0, testAddress2, 29.8, 3),
+	}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := ldb.HasUTXO(testAddress); err != nil || ok {
+		t.Fatalf("address1 should have no utxos %v %v", ok, err)
+	}
+	if balance, n := balanceOf(t, ldb, testAddress2); balance != "51.55000000" || n != 4 {
+		t.Fatalf("address2 balance %s count %d", balance, n)
+	}
+}
+
+// TestCursorStable 翻页期间存储新块，游标之后的页面不包含快照高度之后的utxo，也不跳过已有utxo
+func TestCursorStable(t *testing.T) {
+	mdb := newMemDB(t)
+	var outs []model.Out
+	for i := 0; i < 4; i++ {
+		outs = append(outs, testOut("aa", i, testAddress, 1, 1))
+	}
+	if err := mdb.Store(nil, outs, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	page1, err := mdb.GetUTXOByAddress(&model.UTXORequest{Address: testAddress, PageSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page1.Utxos) != 2 || page1.NextCursor == "" {
+		t.Fatalf("unexpected first page %+v", page1)
+	}
+
+	// 区块2: 花费第一页的aa:0，产生排在游标前后的新utxo
+	if err := mdb.Store([]model.In{testIn("aa", 0, "ff", 2)}, []model.Out{
+		testOut("00", 0, testAddress, 1, 2),
+		testOut("ff", 0, testAddress, 1, 2),
+	}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	page2, err := mdb.GetUTXOByAddress(&model.UTXORequest{Address: testAddress, PageSize: 2, Cursor: page1.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page2.Utxos) != 2 || page2.NextCursor != "" ||
+		page2.Utxos[0].TxID != testTxid("aa") || page2.Utxos[0].Index != 2 ||
+		page2.Utxos[1].TxID != testTxid("aa") || page2.Utxos[1].Index != 3 {
+		t.Fatalf("unexpected second page %+v", page2.Utxos)
+	}
+	// total_size只计快照高度及之前仍未花费的utxo
+	if page2.TotalSize != 3 {
+		t.Fatalf("unexpected total size %d", page2.TotalSize)
+	}
+}
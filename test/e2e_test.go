@@ -0,0 +1,185 @@
+package test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/indexer"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
+	"github.com/wx-shi/utxo-indexer/internal/server"
+	"github.com/wx-shi/utxo-indexer/internal/wallet"
+	"github.com/wx-shi/utxo-indexer/test/mocknode"
+	"go.uber.org/zap"
+)
+
+// pipeline 连接模拟节点的索引器及HTTP服务
+type pipeline struct {
+	t      testing.TB
+	db     *db.DB
+	pool   *rpc.Pool
+	cancel context.CancelFunc
+	idx    *indexer.Indexer
+	api    *httptest.Server
+}
+
+func newPipeline(t testing.TB, node *mocknode.Node) *pipeline {
+	pool, err := rpc.NewPool(node.Config(), zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Shutdown)
+	mdb, err := db.NewDB(&config.DBConfig{DBType: db.MemDBBackend}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mdb.Close() })
+
+	p := &pipeline{t: t, db: mdb, pool: pool}
+	srv := server.NewServer(&config.ServerConfig{MaxLag: 3}, zap.NewNop(), mdb, pool)
+	p.api = httptest.NewServer(srv.Handler())
+	t.Cleanup(p.api.Close)
+	p.start()
+	t.Cleanup(p.stop)
+	return p
+}
+
+func (p *pipeline) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.idx = indexer.NewIndexer(ctx, &config.IndexerConfig{BatchSize: 1000, BlockChanBuf: 10, CacheSize: 1000}, zap.NewNop(), p.pool, p.db)
+	p.idx.Sync()
+}
+
+// stop 等待存储完成后停止索引器
+func (p *pipeline) stop() {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	<-p.idx.Finish
+	p.cancel = nil
+}
+
+// waitHeight 等待存储高度达到height
+func (p *pipeline) waitHeight(height int64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		sheight, err := p.db.GetStoreHeight()
+		if err != nil {
+			p.t.Fatal(err)
+		}
+		if sheight == height {
+			return
+		}
+		if time.Now().After(deadline) {
+			p.t.Fatalf("timeout waiting for height %d, store height %d", height, sheight)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func addressScript(t testing.TB, address string) []byte {
+	addr, err := wallet.DecodeAddress(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func btc(v float64) int64 {
+	amount, _ := btcutil.NewAmount(v)
+	return int64(amount)
+}
+
+func TestEndToEnd(t *testing.T) {
+	script1, script2 := addressScript(t, testAddress), addressScript(t, testAddress2)
+	opReturn, _ := txscript.NullDataScript([]byte("utxo-indexer"))
+
+	// 区块1: coinbase支付给地址1
+	// 区块2: tx2花费cb1，支付给地址2、找零给地址1，附带OP_RETURN
+	// 区块3: tx3花费tx2的找零支付给地址2
+	chain := mocknode.NewChain(script1)
+	cb1 := chain.Mine().Transactions[0]
+	tx2 := mocknode.Spend([]wire.OutPoint{mocknode.OutPoint(cb1, 0)},
+		wire.NewTxOut(btc(20), script2),
+		wire.NewTxOut(btc(29.9999), script1),
+		wire.NewTxOut(0, opReturn))
+	chain.Mine(tx2)
+	tx3 := mocknode.Spend([]wire.OutPoint{mocknode.OutPoint(tx2, 1)}, wire.NewTxOut(btc(29.9998), script2))
+	chain.Mine(tx3)
+
+	node := mocknode.New(t, chain)
+	p := newPipeline(t, node)
+	p.waitHeight(3)
+
+	if h, err := getHeight(p.api.URL); err != nil || h != 3 {
+		t.Fatalf("api height %d %v", h, err)
+	}
+	assertBalance(t, p.api.URL, testAddress, "100.00000000", 2)
+	assertBalance(t, p.api.URL, testAddress2, "49.99980000", 2)
+	info, err := p.db.GetOutpoint(tx2.TxHash().String(), 1)
+	if err != nil || info == nil || info.Spend == nil || info.Spend.Txid != tx3.TxHash().String() {
+		t.Fatalf("tx2:1 should be spent by tx3, got %+v %v", info, err)
+	}
+	if info, err := p.db.GetOutpoint(tx2.TxHash().String(), 2); err != nil || info != nil {
+		t.Fatalf("op_return output should not be indexed, got %+v %v", info, err)
+	}
+
+	// 同步完成后节点出新块
+	node.Update(func(c *mocknode.Chain) { c.Mine() })
+	p.waitHeight(4)
+	assertBalance(t, p.api.URL, testAddress, "150.00000000", 3)
+
+	// 重组: 从高度2分叉，新分支中tx3'将tx2的找零支付回地址1
+	// 索引器不检测重组，回滚到分叉点后重新同步
+	var fork *mocknode.Chain
+	node.Update(func(c *mocknode.Chain) { fork = c.Fork(2) })
+	tx3b := mocknode.Spend([]wire.OutPoint{mocknode.OutPoint(tx2, 1)}, wire.NewTxOut(btc(29.9998), script1))
+	fork.Mine(tx3b)
+	fork.MineEmpty(2)
+	node.SetChain(fork)
+
+	p.stop()
+	if _, err := p.db.Rollback(2); err != nil {
+		t.Fatal(err)
+	}
+	p.start()
+	p.waitHeight(5)
+
+	// 地址1: cb2 + 新分支cb3~cb5 + tx3'
+	assertBalance(t, p.api.URL, testAddress, "229.99980000", 5)
+	assertBalance(t, p.api.URL, testAddress2, "20.00000000", 1)
+	if info, err := p.db.GetOutpoint(tx3.TxHash().String(), 0); err != nil || info != nil {
+		t.Fatalf("stale tx3 output should be removed, got %+v %v", info, err)
+	}
+	report, err := p.db.Verify(context.Background(), false, nil)
+	if err != nil || report.IssueCount != 0 {
+		t.Fatalf("verify after reorg %+v %v", report, err)
+	}
+	set, err := p.db.GetUTXOSetInfo()
+	if err != nil || set.TxOuts != 6 || set.Height != 5 {
+		t.Fatalf("unexpected utxo set %+v %v", set, err)
+	}
+}
+
+func assertBalance(t *testing.T, baseURL, address, balance string, count int) {
+	t.Helper()
+	ur, err := getUtxo(baseURL, address, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ur.Balance != balance || ur.TotalSize != count {
+		t.Fatalf("address %s balance %s count %d, want %s %d", address, ur.Balance, ur.TotalSize, balance, count)
+	}
+}
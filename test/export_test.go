@@ -0,0 +1,163 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+func TestExportImport(t *testing.T) {
+	src := newMemDB(t)
+	if err := src.Store(nil, []model.Out{
+		testOut("aa", 0, testAddress, 1, 1),
+		testOut("aa", 1, testAddress2, 2, 1),
+	}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Store([]model.In{testIn("aa", 0, "bb", 2)}, []model.Out{
+		testOut("bb", 0, testAddress2, 0.5, 2),
+	}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.PutAPIKey("id", &db.ApiKey{Name: "replica"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	exported, err := src.Export(context.Background(), &buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.StoreHeight != 2 || exported.Records == 0 {
+		t.Fatalf("unexpected export %+v", exported)
+	}
+
+	dst := newMemDB(t)
+	imported, err := dst.Import(context.Background(), bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *imported != *exported {
+		t.Fatalf("import %+v, export %+v", imported, exported)
+	}
+	want, err := src.Stats(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := dst.Stats(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StoreHeight != 2 || got.Unspent != want.Unspent || got.Spent != want.Spent ||
+		got.UnspentValue != want.UnspentValue || got.Addresses != want.Addresses || got.APIKeys != 1 {
+		t.Fatalf("stats mismatch %+v, want %+v", got, want)
+	}
+	if balance, n := balanceOf(t, dst, testAddress2); balance != "2.50000000" || n != 2 {
+		t.Fatalf("unexpected balance %s %d", balance, n)
+	}
+
+	// 数据目录非空时拒绝导入
+	if _, err := dst.Import(context.Background(), bytes.NewReader(buf.Bytes()), nil); err != db.ErrNotEmpty {
+		t.Fatalf("expected ErrNotEmpty, got %v", err)
+	}
+
+	// 修改未压缩数据中的一个字节后校验和不一致
+	gz, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)/2] ^= 0xff
+	var corrupt bytes.Buffer
+	zw := gzip.NewWriter(&corrupt)
+	zw.Write(raw)
+	zw.Close()
+	// 校验失败时删除已写入的记录，可以直接重新导入
+	retry := newMemDB(t)
+	_, err = retry.Import(context.Background(), &corrupt, nil)
+	if err == nil || !strings.Contains(err.Error(), "checksum") || !strings.Contains(err.Error(), "imported records removed") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if stats, err := retry.Stats(0); err != nil || stats.StoreHeight != 0 || stats.Unspent != 0 || stats.Addresses != 0 || stats.APIKeys != 0 {
+		t.Fatalf("expected empty database after failed import, got %+v %v", stats, err)
+	}
+	if _, err := retry.Import(context.Background(), bytes.NewReader(buf.Bytes()), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blockingWriter 第一次写入时通知并阻塞，直到release关闭
+type blockingWriter struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+	buf     bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() {
+		close(w.started)
+		<-w.release
+	})
+	return w.buf.Write(p)
+}
+
+// TestExportDuringStore 导出不阻塞同步，导出的数据为开始时的快照
+func TestExportDuringStore(t *testing.T) {
+	src := newMemDB(t)
+	if err := src.Store(nil, []model.Out{testOut("aa", 0, testAddress, 1, 1)}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	type result struct {
+		info *model.ExportInfo
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		info, err := src.Export(context.Background(), w, nil)
+		done <- result{info, err}
+	}()
+	<-w.started
+
+	stored := make(chan error, 1)
+	go func() {
+		stored <- src.Store(nil, []model.Out{testOut("bb", 0, testAddress, 2, 2)}, 2)
+	}()
+	select {
+	case err := <-stored:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("store blocked by export")
+	}
+	close(w.release)
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.info.StoreHeight != 1 {
+		t.Fatalf("expected export at height 1, got %d", res.info.StoreHeight)
+	}
+	dst := newMemDB(t)
+	if _, err := dst.Import(context.Background(), &w.buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if balance, n := balanceOf(t, dst, testAddress); balance != "1.00000000" || n != 1 {
+		t.Fatalf("unexpected balance in export %s %d", balance, n)
+	}
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
+	"github.com/wx-shi/utxo-indexer/internal/server"
+	"go.uber.org/zap"
+)
+
+// heightNode 只实现getblockcount的节点 delay模拟节点无响应
+type heightNode struct {
+	rpc.Node
+	height int64
+	err    error
+	delay  time.Duration
+}
+
+func (n *heightNode) GetBlockCount() (int64, error) {
+	time.Sleep(n.delay)
+	return n.height, n.err
+}
+
+func TestHealthProbes(t *testing.T) {
+	mdb := newMemDB(t)
+	testStoreBlocks(t, mdb) // 存储高度3
+
+	cases := []struct {
+		name   string
+		node   *heightNode
+		status int
+		check  string
+		errMsg string
+	}{
+		{"in sync", &heightNode{height: 5}, http.StatusOK, "lag", ""},
+		{"lagging", &heightNode{height: 7}, http.StatusServiceUnavailable, "lag", "lags node by 4 blocks"},
+		{"node unreachable", &heightNode{err: errors.New("connection refused")}, http.StatusServiceUnavailable, "rpc", "connection refused"},
+		{"node timeout", &heightNode{height: 3, delay: 5 * time.Second}, http.StatusServiceUnavailable, "rpc", "timeout after 3s"},
+	}
+	for _, c := range cases {
+		h := server.NewServer(&config.ServerConfig{MaxLag: 2}, zap.NewNop(), mdb, c.node).Handler()
+
+		// 存活检查只依赖数据库
+		if w := doRequest(h, http.MethodGet, "/healthz", nil); w.Code != http.StatusOK {
+			t.Fatalf("%s: healthz %d", c.name, w.Code)
+		}
+
+		start := time.Now()
+		w := doRequest(h, http.MethodGet, "/readyz", nil)
+		if elapsed := time.Since(start); elapsed > 4*time.Second {
+			t.Fatalf("%s: readyz took %s", c.name, elapsed)
+		}
+		if w.Code != c.status {
+			t.Fatalf("%s: expected %d, got %d %s", c.name, c.status, w.Code, w.Body.String())
+		}
+		reply := &model.HealthReply{}
+		if err := json.Unmarshal(w.Body.Bytes(), reply); err != nil {
+			t.Fatal(err)
+		}
+		check := reply.Checks[c.check]
+		if check == nil || check.OK != (c.errMsg == "") || !strings.Contains(check.Error, c.errMsg) {
+			t.Fatalf("%s: unexpected %s check %+v", c.name, c.check, check)
+		}
+		if c.check == "lag" && (check.StoreHeight != 3 || check.NodeHeight != c.node.height || check.MaxLag != 2) {
+			t.Fatalf("%s: unexpected lag check %+v", c.name, check)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/wx-shi/utxo-indexer/test/mocknode"
+)
+
+// scrapeMetrics 抓取/metrics 返回 序列(名称及标签) -> 值
+func scrapeMetrics(t *testing.T, baseURL string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(baseURL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scrape metrics: %d", resp.StatusCode)
+	}
+	series := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		idx := strings.LastIndexByte(line, ' ')
+		v, err := strconv.ParseFloat(line[idx+1:], 64)
+		if err != nil {
+			t.Fatalf("parse %q: %v", line, err)
+		}
+		series[line[:idx]] = v
+	}
+	return series
+}
+
+// TestMetrics 同步及请求后高度、批量大小及接口耗时序列随之变化
+func TestMetrics(t *testing.T) {
+	chain := mocknode.NewChain(addressScript(t, testAddress))
+	chain.MineEmpty(3)
+	node := mocknode.New(t, chain)
+	p := newPipeline(t, node)
+
+	before := scrapeMetrics(t, p.api.URL)
+	p.waitHeight(3)
+	p.stop()
+	p.pool.Check()
+
+	const heightCount = `utxo_indexer_http_request_duration_seconds_count{method="GET",route="/height",status="200"}`
+	for i := 0; i < 2; i++ {
+		if resp, err := http.Get(p.api.URL + "/height"); err != nil {
+			t.Fatal(err)
+		} else {
+			resp.Body.Close()
+		}
+	}
+	after := scrapeMetrics(t, p.api.URL)
+
+	for name, want := range map[string]float64{
+		"utxo_indexer_store_height": 3,
+		"utxo_indexer_scan_height":  3,
+		"utxo_indexer_node_height":  3,
+	} {
+		if got, ok := after[name]; !ok || got != want {
+			t.Fatalf("%s: expected %v, got %v (present %v)", name, want, got, ok)
+		}
+	}
+	if after["utxo_indexer_store_batch_size_count"] <= before["utxo_indexer_store_batch_size_count"] {
+		t.Fatalf("store_batch_size not observed")
+	}
+	if after["utxo_indexer_store_batch_size_sum"] < before["utxo_indexer_store_batch_size_sum"]+3 {
+		t.Fatalf("store_batch_size sum should include 3 coinbase outputs")
+	}
+	if after["utxo_indexer_store_duration_seconds_count"] <= before["utxo_indexer_store_duration_seconds_count"] {
+		t.Fatalf("store_duration_seconds not observed")
+	}
+	if got := after[heightCount] - before[heightCount]; got != 2 {
+		t.Fatalf("expected 2 /height requests recorded, got %v", got)
+	}
+	if after[`utxo_indexer_rpc_endpoint_up{endpoint="`+node.URL()+`"}`] != 1 {
+		t.Fatalf("rpc_endpoint_up not set for %s", node.URL())
+	}
+}
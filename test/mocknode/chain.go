@@ -0,0 +1,129 @@
+// Package mocknode 模拟比特币节点RPC 用于端到端测试
+// Chain按脚本构造区块(可分叉)，Node通过HTTP JSON-RPC提供区块数据，切换活跃链即模拟重组
+package mocknode
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// Subsidy 每个区块coinbase的奖励
+const Subsidy = 50 * btcutil.SatoshiPerBitcoin
+
+const genesisTime = 1231006505
+
+// Chain 测试链 blocks[0]为创世区块
+type Chain struct {
+	blocks []*wire.MsgBlock
+	miner  []byte //coinbase锁定脚本
+	tag    byte   //分叉编号 写入coinbase使不同分支同高度的区块不同
+	forks  *byte  //同一条链派生的分支共用计数
+}
+
+// NewChain 创建只有创世区块的链 coinbase支付给miner脚本
+func NewChain(miner []byte) *Chain {
+	c := &Chain{miner: miner, forks: new(byte)}
+	c.blocks = append(c.blocks, c.newBlock(chainhash.Hash{}, 0, nil))
+	return c
+}
+
+// Height 链的最新高度
+func (c *Chain) Height() int64 {
+	return int64(len(c.blocks) - 1)
+}
+
+// Block 指定高度的区块 超出范围返回nil
+func (c *Chain) Block(height int64) *wire.MsgBlock {
+	if height < 0 || height > c.Height() {
+		return nil
+	}
+	return c.blocks[height]
+}
+
+func (c *Chain) Tip() *wire.MsgBlock {
+	return c.blocks[len(c.blocks)-1]
+}
+
+// Mine 在链末尾添加一个区块 包含coinbase及txs
+func (c *Chain) Mine(txs ...*wire.MsgTx) *wire.MsgBlock {
+	block := c.newBlock(c.Tip().BlockHash(), c.Height()+1, txs)
+	c.blocks = append(c.blocks, block)
+	return block
+}
+
+// MineEmpty 连续添加n个只有coinbase的区块
+func (c *Chain) MineEmpty(n int) {
+	for i := 0; i < n; i++ {
+		c.Mine()
+	}
+}
+
+// Fork 从height处分叉 返回共享0..height区块的新分支
+func (c *Chain) Fork(height int64) *Chain {
+	*c.forks++
+	return &Chain{
+		blocks: append([]*wire.MsgBlock{}, c.blocks[:height+1]...),
+		miner:  c.miner,
+		tag:    *c.forks,
+		forks:  c.forks,
+	}
+}
+
+func (c *Chain) newBlock(prev chainhash.Hash, height int64, txs []*wire.MsgTx) *wire.MsgBlock {
+	block := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:   4,
+			PrevBlock: prev,
+			Bits:      0x207fffff,
+			Timestamp: time.Unix(genesisTime+height*600, 0),
+			Nonce:     uint32(height),
+		},
+	}
+	block.AddTransaction(Coinbase(height, c.tag, c.miner, Subsidy))
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	// 不校验默克尔根 只需要随交易变化使区块哈希唯一
+	var ids bytes.Buffer
+	for _, tx := range block.Transactions {
+		hash := tx.TxHash()
+		ids.Write(hash[:])
+	}
+	block.Header.MerkleRoot = chainhash.DoubleHashH(ids.Bytes())
+	return block
+}
+
+// Coinbase 高度及分叉编号写入解锁脚本(BIP34) 保证txid唯一
+func Coinbase(height int64, tag byte, script []byte, value int64) *wire.MsgTx {
+	sig, _ := txscript.NewScriptBuilder().AddInt64(height).AddData([]byte{tag}).Script()
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), sig, nil))
+	tx.AddTxOut(wire.NewTxOut(value, script))
+	return tx
+}
+
+// Spend 构造花费prevs的交易 不签名
+func Spend(prevs []wire.OutPoint, outs ...*wire.TxOut) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	for i := range prevs {
+		tx.AddTxIn(wire.NewTxIn(&prevs[i], nil, nil))
+	}
+	for _, out := range outs {
+		tx.AddTxOut(out)
+	}
+	return tx
+}
+
+// OutPoint tx的第index个输出
+func OutPoint(tx *wire.MsgTx, index uint32) wire.OutPoint {
+	return *wire.NewOutPoint(ptr(tx.TxHash()), index)
+}
+
+func ptr(h chainhash.Hash) *chainhash.Hash {
+	return &h
+}
@@ -0,0 +1,405 @@
+package mocknode
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+)
+
+const (
+	User     = "btc"
+	Password = "btc"
+)
+
+type blockEntry struct {
+	block  *wire.MsgBlock
+	height int64
+}
+
+// Node 模拟节点 支持getblockcount、getblockhash、getblock(verbosity 0/1/2)、getblockheader、getrawtransaction、
+// getnetworkinfo、gettxout、sendrawtransaction
+// 重组后旧分支的区块仍可按哈希查询(与Bitcoin Core一致，confirmations为-1)
+type Node struct {
+	srv     *httptest.Server
+	mu      sync.RWMutex
+	chain   *Chain
+	known   map[chainhash.Hash]blockEntry
+	mempool []*wire.MsgTx
+}
+
+// New 启动模拟节点 测试结束时关闭
+func New(t testing.TB, chain *Chain) *Node {
+	n := &Node{
+		known: make(map[chainhash.Hash]blockEntry),
+	}
+	n.SetChain(chain)
+	n.srv = httptest.NewServer(http.HandlerFunc(n.serve))
+	t.Cleanup(n.srv.Close)
+	return n
+}
+
+// URL host:port 与rpc.url配置格式一致
+func (n *Node) URL() string {
+	return strings.TrimPrefix(n.srv.URL, "http://")
+}
+
+// Config 连接该节点的RPC配置
+func (n *Node) Config() *config.BitcoinRPCConfig {
+	return &config.BitcoinRPCConfig{URL: n.URL(), User: User, Password: Password}
+}
+
+// SetChain 切换活跃链 切换到分叉链即模拟重组
+func (n *Node) SetChain(c *Chain) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.chain = c
+	n.index(c)
+}
+
+// Update 修改活跃链(如出新块) 节点运行期间修改链需通过该方法，避免与请求并发读写
+func (n *Node) Update(fn func(c *Chain)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	fn(n.chain)
+	n.index(n.chain)
+}
+
+// AddMempool 向内存池加入交易(不做校验)
+func (n *Node) AddMempool(tx *wire.MsgTx) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.mempool = append(n.mempool, tx)
+}
+
+// Mempool 内存池中的交易
+func (n *Node) Mempool() []*wire.MsgTx {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]*wire.MsgTx(nil), n.mempool...)
+}
+
+func (n *Node) index(c *Chain) {
+	for h, b := range c.blocks {
+		n.known[b.BlockHash()] = blockEntry{block: b, height: int64(h)}
+	}
+}
+
+type request struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func (n *Node) serve(w http.ResponseWriter, r *http.Request) {
+	if u, p, ok := r.BasicAuth(); !ok || u != User || p != Password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var (
+		result interface{}
+		rpcErr *btcjson.RPCError
+	)
+	if req.Method == "sendrawtransaction" {
+		n.mu.Lock()
+		result, rpcErr = n.sendRawTransaction(&req)
+		n.mu.Unlock()
+	} else {
+		n.mu.RLock()
+		result, rpcErr = n.handle(&req)
+		n.mu.RUnlock()
+	}
+
+	reply := map[string]interface{}{"result": result, "error": rpcErr, "id": req.ID}
+	if rpcErr != nil {
+		reply["result"] = nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func (n *Node) handle(req *request) (interface{}, *btcjson.RPCError) {
+	switch req.Method {
+	case "getblockcount":
+		return n.chain.Height(), nil
+	case "getnetworkinfo":
+		// rpcclient广播前据此判断sendrawtransaction参数格式
+		return map[string]interface{}{"version": 250000, "subversion": "/Satoshi:25.0.0/"}, nil
+	case "getblockhash":
+		var height int64
+		if err := param(req, 0, &height); err != nil {
+			return nil, err
+		}
+		block := n.chain.Block(height)
+		if block == nil {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCOutOfRange, "Block height out of range")
+		}
+		return block.BlockHash().String(), nil
+	case "getblock":
+		entry, err := n.blockParam(req)
+		if err != nil {
+			return nil, err
+		}
+		verbosity := 1
+		if len(req.Params) > 1 {
+			if err := param(req, 1, &verbosity); err != nil {
+				return nil, err
+			}
+		}
+		if verbosity == 0 {
+			var buf bytes.Buffer
+			entry.block.Serialize(&buf)
+			return hex.EncodeToString(buf.Bytes()), nil
+		}
+		return n.verboseBlock(entry, verbosity), nil
+	case "getblockheader":
+		entry, err := n.blockParam(req)
+		if err != nil {
+			return nil, err
+		}
+		block := n.verboseBlock(entry, 1)
+		return map[string]interface{}{
+			"hash":              block.Hash,
+			"confirmations":     block.Confirmations,
+			"height":            block.Height,
+			"previousblockhash": block.PreviousHash,
+			"merkleroot":        block.MerkleRoot,
+			"time":              block.Time,
+		}, nil
+	case "getrawtransaction":
+		var txid string
+		if err := param(req, 0, &txid); err != nil {
+			return nil, err
+		}
+		for _, block := range n.chain.blocks {
+			for _, tx := range block.Transactions {
+				if tx.TxHash().String() == txid {
+					var buf bytes.Buffer
+					tx.Serialize(&buf)
+					return hex.EncodeToString(buf.Bytes()), nil
+				}
+			}
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No such mempool or blockchain transaction")
+	case "gettxout":
+		var (
+			txid          string
+			vout          uint32
+			includeMempool = true
+		)
+		if err := param(req, 0, &txid); err != nil {
+			return nil, err
+		}
+		if err := param(req, 1, &vout); err != nil {
+			return nil, err
+		}
+		if len(req.Params) > 2 {
+			if err := param(req, 2, &includeMempool); err != nil {
+				return nil, err
+			}
+		}
+		hash, err := chainhash.NewHashFromStr(txid)
+		if err != nil {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, err.Error())
+		}
+		return n.txOut(wire.OutPoint{Hash: *hash, Index: vout}, includeMempool), nil
+	}
+	return nil, btcjson.NewRPCError(btcjson.ErrRPCMethodNotFound.Code, "Method not found")
+}
+
+// txOut 活跃链(及内存池)中未花费的输出 不存在或已花费时返回nil(JSON null)
+func (n *Node) txOut(op wire.OutPoint, includeMempool bool) *btcjson.GetTxOutResult {
+	var (
+		out      *wire.TxOut
+		height   int64 = -1
+		coinbase bool
+	)
+	spent := func(tx *wire.MsgTx) bool {
+		for _, in := range tx.TxIn {
+			if in.PreviousOutPoint == op {
+				return true
+			}
+		}
+		return false
+	}
+	for h := int64(0); h <= n.chain.Height(); h++ {
+		for i, tx := range n.chain.Block(h).Transactions {
+			if tx.TxHash() == op.Hash && int(op.Index) < len(tx.TxOut) {
+				out, height, coinbase = tx.TxOut[op.Index], h, i == 0
+			}
+			if spent(tx) {
+				return nil
+			}
+		}
+	}
+	if includeMempool {
+		for _, tx := range n.mempool {
+			if tx.TxHash() == op.Hash && int(op.Index) < len(tx.TxOut) {
+				out = tx.TxOut[op.Index]
+			}
+			if spent(tx) {
+				return nil
+			}
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	res := &btcjson.GetTxOutResult{
+		BestBlock:    n.chain.Tip().BlockHash().String(),
+		Value:        btcutil.Amount(out.Value).ToBTC(),
+		ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(out.PkScript)},
+		Coinbase:     coinbase,
+	}
+	if height >= 0 {
+		res.Confirmations = n.chain.Height() - height + 1
+	}
+	return res
+}
+
+// sendRawTransaction 输入均未花费时加入内存池，否则返回与Bitcoin Core一致的拒绝错误
+func (n *Node) sendRawTransaction(req *request) (interface{}, *btcjson.RPCError) {
+	var s string
+	if err := param(req, 0, &s); err != nil {
+		return nil, err
+	}
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCDeserialization, "TX decode failed")
+	}
+	tx := wire.NewMsgTx(wire.TxVersion)
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCDeserialization, "TX decode failed")
+	}
+	for _, in := range tx.TxIn {
+		if n.txOut(in.PreviousOutPoint, true) == nil {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCVerify, "bad-txns-inputs-missingorspent")
+		}
+	}
+	n.mempool = append(n.mempool, tx)
+	return tx.TxHash().String(), nil
+}
+
+func param(req *request, i int, v interface{}) *btcjson.RPCError {
+	if i >= len(req.Params) {
+		return btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "missing parameter")
+	}
+	if err := json.Unmarshal(req.Params[i], v); err != nil {
+		return btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, err.Error())
+	}
+	return nil
+}
+
+func (n *Node) blockParam(req *request) (blockEntry, *btcjson.RPCError) {
+	var s string
+	if err := param(req, 0, &s); err != nil {
+		return blockEntry{}, err
+	}
+	hash, err := chainhash.NewHashFromStr(s)
+	if err != nil {
+		return blockEntry{}, btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, err.Error())
+	}
+	entry, ok := n.known[*hash]
+	if !ok {
+		return blockEntry{}, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+	}
+	return entry, nil
+}
+
+func (n *Node) verboseBlock(entry blockEntry, verbosity int) *btcjson.GetBlockVerboseTxResult {
+	block, hash := entry.block, entry.block.BlockHash()
+	res := &btcjson.GetBlockVerboseTxResult{
+		Hash:          hash.String(),
+		Confirmations: -1,
+		Size:          int32(block.SerializeSize()),
+		StrippedSize:  int32(block.SerializeSizeStripped()),
+		Height:        entry.height,
+		Version:       block.Header.Version,
+		VersionHex:    fmt.Sprintf("%08x", block.Header.Version),
+		MerkleRoot:    block.Header.MerkleRoot.String(),
+		Time:          block.Header.Timestamp.Unix(),
+		Nonce:         block.Header.Nonce,
+		Bits:          fmt.Sprintf("%08x", block.Header.Bits),
+		Difficulty:    1,
+	}
+	if entry.height > 0 {
+		res.PreviousHash = block.Header.PrevBlock.String()
+	}
+	if active := n.chain.Block(entry.height); active != nil && active.BlockHash() == hash {
+		res.Confirmations = n.chain.Height() - entry.height + 1
+		if next := n.chain.Block(entry.height + 1); next != nil {
+			res.NextHash = next.BlockHash().String()
+		}
+	}
+	if verbosity >= 2 {
+		for _, tx := range block.Transactions {
+			res.Tx = append(res.Tx, rawTx(tx))
+		}
+	}
+	return res
+}
+
+// rawTx getblock verbosity 2中的交易
+func rawTx(tx *wire.MsgTx) btcjson.TxRawResult {
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	res := btcjson.TxRawResult{
+		Hex:      hex.EncodeToString(buf.Bytes()),
+		Txid:     tx.TxHash().String(),
+		Hash:     tx.WitnessHash().String(),
+		Size:     int32(tx.SerializeSize()),
+		Version:  uint32(tx.Version),
+		LockTime: tx.LockTime,
+	}
+	for _, in := range tx.TxIn {
+		if in.PreviousOutPoint.Index == wire.MaxPrevOutIndex && in.PreviousOutPoint.Hash == (chainhash.Hash{}) {
+			res.Vin = append(res.Vin, btcjson.Vin{
+				Coinbase: hex.EncodeToString(in.SignatureScript),
+				Sequence: in.Sequence,
+			})
+			continue
+		}
+		res.Vin = append(res.Vin, btcjson.Vin{
+			Txid:      in.PreviousOutPoint.Hash.String(),
+			Vout:      in.PreviousOutPoint.Index,
+			ScriptSig: &btcjson.ScriptSig{Hex: hex.EncodeToString(in.SignatureScript)},
+			Sequence:  in.Sequence,
+		})
+	}
+	for i, out := range tx.TxOut {
+		class, addrs, reqSigs, _ := txscript.ExtractPkScriptAddrs(out.PkScript, &chaincfg.MainNetParams)
+		spk := btcjson.ScriptPubKeyResult{
+			Hex:     hex.EncodeToString(out.PkScript),
+			Type:    class.String(),
+			ReqSigs: int32(reqSigs),
+		}
+		for _, addr := range addrs {
+			spk.Addresses = append(spk.Addresses, addr.EncodeAddress())
+		}
+		res.Vout = append(res.Vout, btcjson.Vout{
+			Value:        btcutil.Amount(out.Value).ToBTC(),
+			N:            uint32(i),
+			ScriptPubKey: spk,
+		})
+	}
+	return res
+}
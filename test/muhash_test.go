@@ -0,0 +1,46 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/wx-shi/utxo-indexer/internal/muhash"
+)
+
+func muhashFromInt(i byte) *muhash.MuHash3072 {
+	m := muhash.New()
+	data := make([]byte, 32)
+	data[0] = i
+	m.Insert(data)
+	return m
+}
+
+// 与Bitcoin Core crypto_tests.cpp muhash_tests中的向量一致
+func TestMuHash3072(t *testing.T) {
+	acc := muhashFromInt(0)
+	acc.Combine(muhashFromInt(1))
+	data := make([]byte, 32)
+	data[0] = 2
+	acc.Remove(data)
+
+	out := chainhash.Hash(acc.Finalize())
+	if out.String() != "10d312b100cbd32ada024a6646e40d3482fcff103668d2625f10002a607d5863" {
+		t.Fatalf("unexpected muhash %s", out)
+	}
+
+	// 顺序无关 插入后删除恢复原值
+	a, b := muhash.New(), muhash.New()
+	a.Insert([]byte("x"))
+	a.Insert([]byte("y"))
+	b.Insert([]byte("y"))
+	b.Insert([]byte("z"))
+	b.Insert([]byte("x"))
+	b.Remove([]byte("z"))
+	if a.Finalize() != b.Finalize() {
+		t.Fatal("muhash should be order independent")
+	}
+	num, den := b.Bytes()
+	if muhash.FromBytes(num, den).Finalize() != a.Finalize() {
+		t.Fatal("muhash state roundtrip failed")
+	}
+}
@@ -0,0 +1,123 @@
+package test
+
+import (
+	"errors"
+	"testing"
+
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"go.uber.org/zap"
+)
+
+// storeSpendChain c1:0在区块2被a2花费，a2:0在区块3被a3花费，区块4为空
+func storeSpendChain(t *testing.T, ldb *db.DB) {
+	blocks := []struct {
+		vins  []model.In
+		vouts []model.Out
+	}{
+		{nil, []model.Out{testOut("c1", 0, testAddress, 50, 1)}},
+		{[]model.In{testIn("c1", 0, "a2", 2)}, []model.Out{testOut("a2", 0, testAddress2, 50, 2)}},
+		{[]model.In{testIn("a2", 0, "a3", 3)}, []model.Out{testOut("a3", 0, testAddress, 50, 3)}},
+		{nil, nil},
+	}
+	for i, b := range blocks {
+		if err := ldb.Store(b.vins, b.vouts, int64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func outpointExists(t *testing.T, ldb *db.DB, txid string) bool {
+	info, err := ldb.GetOutpoint(testTxid(txid), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info != nil
+}
+
+func TestPrune(t *testing.T) {
+	ldb, err := db.NewDB(&config.DBConfig{DBType: db.MemDBBackend, Mode: db.ModePrune, PruneDepth: 2}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	storeSpendChain(t, ldb)
+
+	// 高度4保留深度2: 区块2花费的记录删除，区块3花费的保留
+	if outpointExists(t, ldb, "c1") {
+		t.Fatal("c1:0 spent at height 2 should be pruned")
+	}
+	if !outpointExists(t, ldb, "a2") || !outpointExists(t, ldb, "a3") {
+		t.Fatal("a2:0 and a3:0 should be kept")
+	}
+	stats, err := ldb.Stats(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Mode != db.ModePrune || stats.PrunedHeight != 2 || stats.Spent != 1 || stats.Unspent != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 不能回滚到已删除的高度以下
+	if _, err := ldb.Rollback(1); !errors.Is(err, db.ErrPruned) {
+		t.Fatalf("expected ErrPruned, got %v", err)
+	}
+	if _, err := ldb.Rollback(2); err != nil {
+		t.Fatal(err)
+	}
+	if balance, n := balanceOf(t, ldb, testAddress2); balance != "50.00000000" || n != 1 {
+		t.Fatalf("address2 balance %s count %d after rollback", balance, n)
+	}
+
+	// 回滚后重新花费 花费高度索引按新的高度删除
+	if err := ldb.Store([]model.In{testIn("a2", 0, "b3", 3)}, []model.Out{testOut("b3", 0, testAddress, 50, 3)}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := ldb.Store(nil, nil, 5); err != nil {
+		t.Fatal(err)
+	}
+	if outpointExists(t, ldb, "a2") || !outpointExists(t, ldb, "b3") {
+		t.Fatal("a2:0 spent at height 3 should be pruned at height 5")
+	}
+}
+
+func TestPruneAll(t *testing.T) {
+	conf := &config.DBConfig{DBType: string(tmdb.GoLevelDBBackend), Dir: t.TempDir()}
+	ldb, err := db.NewDB(conf, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeSpendChain(t, ldb)
+
+	// archive模式保留全部已花费记录
+	if !outpointExists(t, ldb, "c1") || !outpointExists(t, ldb, "a2") {
+		t.Fatal("archive mode should keep spent records")
+	}
+	if _, err := ldb.PruneAll(); err == nil {
+		t.Fatal("PruneAll should fail in archive mode")
+	}
+	ldb.Close()
+
+	// 切换到prune模式后遍历删除
+	conf.Mode, conf.PruneDepth = db.ModePrune, 1
+	ldb, err = db.NewDB(conf, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	n, err := ldb.PruneAll()
+	if err != nil || n != 2 {
+		t.Fatalf("PruneAll deleted %d %v", n, err)
+	}
+	if outpointExists(t, ldb, "c1") || outpointExists(t, ldb, "a2") || !outpointExists(t, ldb, "a3") {
+		t.Fatal("unexpected records after PruneAll")
+	}
+	if _, err := ldb.Rollback(2); !errors.Is(err, db.ErrPruned) {
+		t.Fatalf("expected ErrPruned, got %v", err)
+	}
+	if balance, n := balanceOf(t, ldb, testAddress); balance != "50.00000000" || n != 1 {
+		t.Fatalf("address1 balance %s count %d", balance, n)
+	}
+}
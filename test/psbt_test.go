@@ -0,0 +1,168 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/wallet"
+	"github.com/wx-shi/utxo-indexer/test/mocknode"
+)
+
+func TestBuildPSBT(t *testing.T) {
+	addr, err := wallet.DecodeAddress(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := wire.NewMsgTx(2)
+	prev.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	prev.AddTxOut(wire.NewTxOut(100000, script))
+
+	coins := []*wallet.Coin{{TxID: prev.TxHash().String(), Index: 0, Address: addr, Value: 100000, Height: 1}}
+	outputs := []*wallet.TxOut{{Address: addr, Value: 40000}}
+	res, err := wallet.SelectCoins(coins, &wallet.Params{
+		Target:  40000,
+		FeeRate: 1,
+		Outputs: []btcutil.Address{addr},
+		Change:  addr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := wallet.BuildPSBT(res, outputs, addr, func(c *wallet.Coin) (*wire.MsgTx, error) {
+		return prev, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b64, err := packet.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := psbt.NewFromRawBytes(strings.NewReader(b64), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := decoded.Inputs[0]
+	if in.WitnessUtxo == nil || in.WitnessUtxo.Value != 100000 || in.NonWitnessUtxo == nil {
+		t.Fatalf("input utxo not populated %+v", in)
+	}
+	if len(decoded.UnsignedTx.TxOut) != 2 {
+		t.Fatalf("expected payment and change outputs, got %d", len(decoded.UnsignedTx.TxOut))
+	}
+	fee, err := decoded.GetTxFee()
+	if err != nil || int64(fee) != res.Fee {
+		t.Fatalf("fee mismatch %v %v", fee, err)
+	}
+}
+
+const testP2SHAddress = "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"
+
+func postPSBT(t *testing.T, baseURL string, from string) (int, *psbt.Packet) {
+	t.Helper()
+	body, _ := json.Marshal(&model.PSBTRequest{
+		Addresses: []string{from},
+		Outputs:   []*model.PSBTOutput{{Address: testAddress, Value: 1}},
+		FeeRate:   1,
+	})
+	resp, err := http.Post(baseURL+"/psbt", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reply := &commonRepley{}
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != reply.Code {
+		t.Fatalf("status %d differs from code %d", resp.StatusCode, reply.Code)
+	}
+	if reply.Code != http.StatusOK {
+		return reply.Code, nil
+	}
+	pr := &model.PSBTReply{}
+	if err := json.Unmarshal(reply.Data, pr); err != nil {
+		t.Fatal(err)
+	}
+	packet, err := psbt.NewFromRawBytes(strings.NewReader(pr.PSBT), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply.Code, packet
+}
+
+// TestApiPSBT witness_utxo由索引数据生成，节点只用于非隔离见证输入的non_witness_utxo
+func TestApiPSBT(t *testing.T) {
+	chain := mocknode.NewChain(addressScript(t, testAddress))
+	cb := chain.Mine().Transactions[0]
+	tx := mocknode.Spend([]wire.OutPoint{mocknode.OutPoint(cb, 0)},
+		wire.NewTxOut(btc(10), addressScript(t, testAddress2)),
+		wire.NewTxOut(btc(10), addressScript(t, testP2SHAddress)),
+		wire.NewTxOut(btc(29), addressScript(t, testAddress)))
+	chain.Mine(tx)
+	node := mocknode.New(t, chain)
+	p := newPipeline(t, node)
+	p.waitHeight(2)
+	p.stop()
+
+	cases := []struct {
+		from       string
+		witness    bool
+		nonWitness bool
+	}{
+		{testAddress2, false, true},
+		{testP2SHAddress, true, true},
+		{testAddress, true, true},
+	}
+	resp, err := http.Post(p.api.URL+"/psbt", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for malformed body, got %d", resp.StatusCode)
+	}
+	// 来源地址没有utxo
+	if code, _ := postPSBT(t, p.api.URL, "1BoatSLRHtKNngkdXEeobR76b53LETtpyT"); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for insufficient funds, got %d", code)
+	}
+	for _, c := range cases {
+		code, packet := postPSBT(t, p.api.URL, c.from)
+		if code != http.StatusOK {
+			t.Fatalf("%s: code %d", c.from, code)
+		}
+		for _, in := range packet.Inputs {
+			if (in.WitnessUtxo != nil) != c.witness || (in.NonWitnessUtxo != nil) != c.nonWitness {
+				t.Fatalf("%s: witness_utxo %v non_witness_utxo %v", c.from, in.WitnessUtxo != nil, in.NonWitnessUtxo != nil)
+			}
+			if in.WitnessUtxo != nil && !bytes.Equal(in.WitnessUtxo.PkScript, addressScript(t, c.from)) {
+				t.Fatalf("%s: unexpected witness_utxo script %x", c.from, in.WitnessUtxo.PkScript)
+			}
+		}
+	}
+
+	// 节点不再提供这些区块(如已裁剪)：隔离见证输入只携带witness_utxo，非隔离见证输入无法构造
+	fork := chain.Fork(0)
+	fork.MineEmpty(2)
+	node.SetChain(fork)
+	code, packet := postPSBT(t, p.api.URL, testP2SHAddress)
+	if code != http.StatusOK || packet.Inputs[0].WitnessUtxo == nil || packet.Inputs[0].NonWitnessUtxo != nil {
+		t.Fatalf("p2sh input without node: code %d %+v", code, packet)
+	}
+	if code, _ := postPSBT(t, p.api.URL, testAddress2); code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for legacy input without prev tx, got %d", code)
+	}
+}
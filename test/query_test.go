@@ -0,0 +1,130 @@
+package test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+// utxoNames 以testTxid之前的名称表示utxo 如aa:0
+func utxoNames(utxos []*model.UTXO) string {
+	names := make([]string, 0, len(utxos))
+	for _, u := range utxos {
+		names = append(names, fmt.Sprintf("%s:%d", strings.TrimLeft(u.TxID, "0"), u.Index))
+	}
+	return strings.Join(names, ",")
+}
+
+func TestQueryFilterSort(t *testing.T) {
+	mdb := newMemDB(t)
+	blocks := [][]model.Out{
+		{testOut("aa", 0, testAddress, 1, 1)},
+		{testOut("aa", 1, testAddress, 5, 2)},
+		{testOut("bb", 0, testAddress, 0.001, 3), testOut("cc", 0, testAddress, 3, 3)},
+	}
+	for i, outs := range blocks {
+		if err := mdb.Store(nil, outs, int64(i+1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 存储高度3 确认数: aa:0为3，aa:1为2，bb:0、cc:0为1
+	cases := []struct {
+		name string
+		req  model.UTXORequest
+		want string
+	}{
+		{"min_value", model.UTXORequest{MinValue: 1}, "aa:0,aa:1,cc:0"},
+		{"max_value", model.UTXORequest{MaxValue: 3}, "aa:0,bb:0,cc:0"},
+		{"min_max_value", model.UTXORequest{MinValue: 1, MaxValue: 3}, "aa:0,cc:0"},
+		{"dust_threshold", model.UTXORequest{DustThreshold: 0.01}, "aa:0,aa:1,cc:0"},
+		{"min_conf", model.UTXORequest{MinConf: 2}, "aa:0,aa:1"},
+		{"value_desc", model.UTXORequest{SortBy: db.SortByValue, Order: db.OrderDesc}, "aa:1,cc:0,aa:0,bb:0"},
+		{"value_asc", model.UTXORequest{SortBy: db.SortByValue}, "bb:0,aa:0,cc:0,aa:1"},
+		{"age_asc", model.UTXORequest{SortBy: db.SortByAge, Order: db.OrderAsc}, "bb:0,cc:0,aa:1,aa:0"},
+		{"age_desc", model.UTXORequest{SortBy: db.SortByAge, Order: db.OrderDesc}, "aa:0,aa:1,bb:0,cc:0"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := c.req
+			req.Address, req.PageSize = testAddress, 10
+			reply, err := mdb.GetUTXOByAddress(&req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := utxoNames(reply.Utxos); got != c.want || reply.TotalSize != len(reply.Utxos) {
+				t.Fatalf("got %s total %d, want %s", got, reply.TotalSize, c.want)
+			}
+		})
+	}
+
+	for _, req := range []model.UTXORequest{
+		{MinValue: 3, MaxValue: 1},
+		{SortBy: "size"},
+		{SortBy: db.SortByValue, Order: "up"},
+	} {
+		req.Address, req.PageSize = testAddress, 10
+		if _, err := mdb.GetUTXOByAddress(&req); !errors.Is(err, db.ErrInvalidQuery) {
+			t.Fatalf("expected ErrInvalidQuery for %+v, got %v", req, err)
+		}
+	}
+
+	// 排序翻页期间存储新块 游标之后不包含快照之后的utxo
+	req := &model.UTXORequest{Address: testAddress, PageSize: 2, SortBy: db.SortByValue, Order: db.OrderDesc}
+	page1, err := mdb.GetUTXOByAddress(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := utxoNames(page1.Utxos); got != "aa:1,cc:0" || page1.NextCursor == "" {
+		t.Fatalf("first page %s cursor %q", got, page1.NextCursor)
+	}
+	if err := mdb.Store(nil, []model.Out{testOut("dd", 0, testAddress, 10, 4), testOut("ee", 0, testAddress, 2, 4)}, 4); err != nil {
+		t.Fatal(err)
+	}
+	req.Cursor = page1.NextCursor
+	page2, err := mdb.GetUTXOByAddress(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := utxoNames(page2.Utxos); got != "aa:0,bb:0" || page2.NextCursor != "" {
+		t.Fatalf("second page %s cursor %q", got, page2.NextCursor)
+	}
+
+	// 翻页时排序或过滤条件变化 游标无效
+	for _, change := range []func(r *model.UTXORequest){
+		func(r *model.UTXORequest) { r.Order = db.OrderAsc },
+		func(r *model.UTXORequest) { r.SortBy = "" },
+		func(r *model.UTXORequest) { r.MinValue = 1 },
+	} {
+		changed := *req
+		change(&changed)
+		if _, err := mdb.GetUTXOByAddress(&changed); !errors.Is(err, db.ErrInvalidCursor) {
+			t.Fatalf("expected ErrInvalidCursor for %+v, got %v", changed, err)
+		}
+	}
+}
+
+// TestQueryUnknownHeight 未记录创建高度的utxo不能按确认数过滤或按时间排序
+func TestQueryUnknownHeight(t *testing.T) {
+	mdb := newMemDB(t)
+	if err := mdb.Store(nil, []model.Out{
+		testOut("aa", 0, testAddress, 1, 0),
+		testOut("bb", 0, testAddress, 2, 1),
+	}, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []model.UTXORequest{{MinConf: 1}, {SortBy: db.SortByAge}} {
+		req.Address, req.PageSize = testAddress, 10
+		if _, err := mdb.GetUTXOByAddress(&req); !errors.Is(err, db.ErrUnknownHeight) || !errors.Is(err, db.ErrInvalidQuery) {
+			t.Fatalf("expected ErrUnknownHeight for %+v, got %v", req, err)
+		}
+	}
+	reply, err := mdb.GetUTXOByAddress(&model.UTXORequest{Address: testAddress, PageSize: 10, SortBy: db.SortByValue})
+	if err != nil || utxoNames(reply.Utxos) != "aa:0,bb:0" {
+		t.Fatalf("sort by value %+v %v", reply, err)
+	}
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"go.uber.org/zap"
+)
+
+const testAddress2 = "1BvBMSEYstWetqTFn5Au4m4GFg7xJaNVN2"
+
+func newMemDB(t *testing.T) *db.DB {
+	mdb, err := db.NewDB(&config.DBConfig{DBType: "memdb"}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mdb.Close() })
+	return mdb
+}
+
+// testTxid 短名称左侧补0为64位十六进制txid
+func testTxid(name string) string {
+	if len(name) >= 64 {
+		return name
+	}
+	return strings.Repeat("0", 64-len(name)) + name
+}
+
+func testUKey(txid string, index int) string {
+	ukey, err := db.UtxoKey(testTxid(txid), uint32(index))
+	if err != nil {
+		panic(err)
+	}
+	return ukey
+}
+
+func testOut(txid string, index int, address string, value float64, height int64) model.Out {
+	return model.Out{
+		UKey:    testUKey(txid, index),
+		TxID:    testTxid(txid),
+		Index:   index,
+		Address: address,
+		Value:   value,
+		Height:  height,
+	}
+}
+
+func testIn(txid string, index int, spendTx string, height int64) model.In {
+	return model.In{
+		UKey:  testUKey(txid, index),
+		TxID:  testTxid(txid),
+		Index: index,
+		Spend: &model.Spend{TxID: spendTx, Index: 0, Height: height},
+	}
+}
+
+func balanceOf(t *testing.T, mdb *db.DB, address string) (string, int) {
+	reply, err := mdb.GetUTXOByAddress(&model.UTXORequest{Address: address, PageSize: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply.Balance, reply.TotalSize
+}
+
+func TestRollback(t *testing.T) {
+	mdb := newMemDB(t)
+
+	if err := mdb.Store(nil, []model.Out{
+		testOut("aa", 0, testAddress, 1, 1),
+		testOut("aa", 1, testAddress2, 2, 1),
+	}, 1); err != nil {
+		t.Fatal(err)
+	}
+	// 区块2花费aa:0 并支付给地址2
+	if err := mdb.Store([]model.In{testIn("aa", 0, "bb", 2)}, []model.Out{
+		testOut("bb", 0, testAddress2, 0.5, 2),
+		testOut("bb", 1, testAddress, 0.4, 2),
+	}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if bal, n := balanceOf(t, mdb, testAddress2); bal != "2.50000000" || n != 2 {
+		t.Fatalf("unexpected state before rollback %s %d", bal, n)
+	}
+
+	res, err := mdb.Rollback(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Deleted != 2 || res.Restored != 1 {
+		t.Fatalf("unexpected rollback result %+v", res)
+	}
+	if bal, n := balanceOf(t, mdb, testAddress); bal != "1.00000000" || n != 1 {
+		t.Fatalf("address 1 after rollback %s %d", bal, n)
+	}
+	if bal, n := balanceOf(t, mdb, testAddress2); bal != "2.00000000" || n != 1 {
+		t.Fatalf("address 2 after rollback %s %d", bal, n)
+	}
+	if h, _ := mdb.GetStoreHeight(); h != 1 {
+		t.Fatalf("store height %d", h)
+	}
+	report, err := mdb.Verify(context.Background(), false, nil)
+	if err != nil || report.IssueCount > 0 {
+		t.Fatalf("verify %+v %v", report, err)
+	}
+}
+
+// 回滚记录数超过单批上限时分批写入，结果与一次写入一致
+func TestRollbackBatches(t *testing.T) {
+	mdb := newMemDB(t)
+
+	outs := make([]model.Out, 0, 12000)
+	for i := 0; i < 12000; i++ {
+		outs = append(outs, testOut("cc", i, testAddress, 0.0001, 1))
+	}
+	if err := mdb.Store(nil, outs, 1); err != nil {
+		t.Fatal(err)
+	}
+	ins := make([]model.In, 0, 6000)
+	for i := 0; i < 6000; i++ {
+		ins = append(ins, testIn("cc", i, "dd", 2))
+	}
+	if err := mdb.Store(ins, []model.Out{testOut("dd", 0, testAddress2, 0.6, 2)}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := mdb.Rollback(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Deleted != 1 || res.Restored != 6000 {
+		t.Fatalf("unexpected rollback result %+v", res)
+	}
+	if bal, n := balanceOf(t, mdb, testAddress); bal != "1.20000000" || n != 12000 {
+		t.Fatalf("address 1 after rollback %s %d", bal, n)
+	}
+	if bal, n := balanceOf(t, mdb, testAddress2); bal != "0.00000000" || n != 0 {
+		t.Fatalf("address 2 after rollback %s %d", bal, n)
+	}
+	if _, ok, err := mdb.PendingRollback(); err != nil || ok {
+		t.Fatalf("rollback still pending %v %v", ok, err)
+	}
+	report, err := mdb.Verify(context.Background(), false, nil)
+	if err != nil || report.IssueCount > 0 {
+		t.Fatalf("verify %+v %v", report, err)
+	}
+}
@@ -0,0 +1,124 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
+	"go.uber.org/zap"
+)
+
+// fakeNode 只实现getblockcount/getblockhash的节点
+func fakeNode(t *testing.T, height int64, hash string, user, pass string) *httptest.Server {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			ID     interface{} `json:"id"`
+			Method string      `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var result interface{}
+		switch req.Method {
+		case "getblockcount":
+			result = height
+		case "getblockhash":
+			result = hash
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result, "error": nil, "id": req.ID})
+	}))
+	t.Cleanup(node.Close)
+	return node
+}
+
+func nodeURL(node *httptest.Server) string {
+	return strings.TrimPrefix(node.URL, "http://")
+}
+
+func TestRPCPoolFailover(t *testing.T) {
+	hash := strings.Repeat("11", 32)
+	primary := fakeNode(t, 100, hash, "btc", "btc")
+	backup := fakeNode(t, 100, hash, "btc", "btc")
+	forked := fakeNode(t, 100, strings.Repeat("22", 32), "btc", "btc")
+
+	cookie := filepath.Join(t.TempDir(), ".cookie")
+	if err := os.WriteFile(cookie, []byte("__cookie__:secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cookieNode := fakeNode(t, 100, hash, "__cookie__", "secret")
+
+	pool, err := rpc.NewPool(&config.BitcoinRPCConfig{
+		URL:      nodeURL(primary),
+		User:     "btc",
+		Password: "btc",
+		Endpoints: []*config.RPCEndpoint{
+			{URL: nodeURL(forked), User: "btc", Password: "btc"},
+			{URL: nodeURL(backup), User: "btc", Password: "btc"},
+			{URL: nodeURL(cookieNode), CookieFile: cookie},
+		},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Shutdown()
+	pool.Check()
+
+	primary.Close()
+	if h, err := pool.GetBlockCount(); err != nil || h != 100 {
+		t.Fatalf("failover failed %d %v", h, err)
+	}
+	// 分叉节点哈希与多数节点不一致，不再参与请求
+	pool.Check()
+	backup.Close()
+	got, err := pool.GetBlockHash(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != hash {
+		t.Fatalf("expected hash from consistent node, got %s", got)
+	}
+}
+
+// 无响应的节点在健康检查超时后不再参与请求
+func TestRPCPoolCheckTimeout(t *testing.T) {
+	hash := strings.Repeat("11", 32)
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(hung.Close)
+	t.Cleanup(func() { close(release) })
+	backup := fakeNode(t, 100, hash, "btc", "btc")
+
+	pool, err := rpc.NewPool(&config.BitcoinRPCConfig{
+		URL:       nodeURL(hung),
+		User:      "btc",
+		Password:  "btc",
+		Endpoints: []*config.RPCEndpoint{{URL: nodeURL(backup), User: "btc", Password: "btc"}},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Shutdown()
+
+	start := time.Now()
+	pool.Check()
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("check took %s", d)
+	}
+	if h, err := pool.GetBlockCount(); err != nil || h != 100 {
+		t.Fatalf("expected backup node %d %v", h, err)
+	}
+}
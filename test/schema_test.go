@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// setRawKey 绕过DB直接写入utxo存储
+func setRawKey(t *testing.T, dir, key string, val []byte) {
+	setRawKeys(t, dir, "utxo", map[string][]byte{key: val})
+}
+
+func setRawKeys(t *testing.T, dir, name string, kvs map[string][]byte) {
+	raw, err := tmdb.NewDB(name, tmdb.GoLevelDBBackend, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for key, val := range kvs {
+		if err := raw.SetSync([]byte(key), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, m proto.Message) []byte {
+	b, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestSchemaVersion(t *testing.T) {
+	conf := &config.DBConfig{DBType: string(tmdb.GoLevelDBBackend), Dir: t.TempDir()}
+
+	// 引入版本记录之前的数据库打开时记录为版本1
+	setRawKey(t, conf.Dir, db.StoreHeight, pkg.Int64ToBytes(100))
+	ldb, err := db.NewDB(conf, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := ldb.GetSchemaVersion()
+	if err != nil || version != db.SchemaVersion {
+		t.Fatalf("unexpected schema version %d %v", version, err)
+	}
+	ldb.Close()
+
+	// 更新版本程序创建的数据库拒绝打开
+	setRawKey(t, conf.Dir, "s:schema", pkg.Int64ToBytes(db.SchemaVersion+1))
+	if _, err := db.NewDB(conf, zap.NewNop()); !errors.Is(err, db.ErrUnknownSchema) {
+		t.Fatalf("expected ErrUnknownSchema, got %v", err)
+	}
+}
+
+// TestMigrateBinaryKeys 版本1(u:txid:index字符串key)的数据库打开时迁移到二进制key
+func TestMigrateBinaryKeys(t *testing.T) {
+	conf := &config.DBConfig{DBType: string(tmdb.GoLevelDBBackend), Dir: t.TempDir(), Mode: db.ModePrune, PruneDepth: 1}
+	aa, bb, cc := testTxid("aa"), testTxid("bb"), testTxid("cc")
+	spendIndex := make([]byte, 0, 80)
+	spendIndex = append(spendIndex, "p:"...)
+	spendIndex = binary.BigEndian.AppendUint64(spendIndex, 2)
+	spendIndex = append(spendIndex, "u:"+aa+":0"...)
+
+	// aa:0在区块2被花费，aa:1、bb:0未花费；cc:0为中断前已迁移的记录
+	setRawKeys(t, conf.Dir, "utxo", map[string][]byte{
+		"s:schema":         pkg.Int64ToBytes(1),
+		db.StoreHeight:     pkg.Int64ToBytes(2),
+		"u:" + aa + ":0":   mustMarshal(t, &db.UtxoInfo{Address: testAddress, Value: 1, Height: 1, Spend: &db.Spend{Txid: bb, Height: 2}}),
+		"u:" + aa + ":1":   mustMarshal(t, &db.UtxoInfo{Address: testAddress2, Value: 2, Height: 1}),
+		"u:" + bb + ":0":   mustMarshal(t, &db.UtxoInfo{Address: testAddress2, Value: 0.5, Height: 2}),
+		testUKey("cc", 0):  mustMarshal(t, &db.UtxoInfo{Address: testAddress2, Value: 3, Height: 2}),
+		string(spendIndex): {},
+	})
+	setRawKeys(t, conf.Dir, "address_utxo", map[string][]byte{
+		"au:" + testAddress2: mustMarshal(t, &db.OutpointSet{Members: [][]byte{
+			[]byte("u:" + aa + ":1"), []byte("u:" + bb + ":0"), []byte(testUKey("cc", 0)[2:]),
+		}}),
+	})
+	setRawKeys(t, conf.Dir, "balance", map[string][]byte{"ab:" + testAddress2: []byte("5.50000000")})
+
+	ldb, err := db.NewDB(conf, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	if version, err := ldb.GetSchemaVersion(); err != nil || version != db.SchemaVersion {
+		t.Fatalf("unexpected schema version %d %v", version, err)
+	}
+
+	info, err := ldb.GetOutpoint(aa, 0)
+	if err != nil || info == nil || info.Spend == nil || info.Spend.Height != 2 {
+		t.Fatalf("aa:0 should be migrated as spent, got %+v %v", info, err)
+	}
+	reply, err := ldb.GetUTXOByAddress(&model.UTXORequest{Address: testAddress2, PageSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Balance != "5.50000000" || len(reply.Utxos) != 3 ||
+		reply.Utxos[0].TxID != aa || reply.Utxos[0].Index != 1 || reply.Utxos[2].TxID != cc {
+		t.Fatalf("unexpected address utxos %s %+v", reply.Balance, reply.Utxos)
+	}
+	report, err := ldb.Verify(context.Background(), false, nil)
+	if err != nil || report.IssueCount != 0 {
+		t.Fatalf("verify after migration %+v %v", report, err)
+	}
+
+	// 花费高度索引同样迁移 区块3时删除区块2花费的aa:0
+	if err := ldb.Store(nil, nil, 3); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := ldb.GetOutpoint(aa, 0); err != nil || info != nil {
+		t.Fatalf("aa:0 should be pruned, got %+v %v", info, err)
+	}
+}
+
+// TestMigrateLegacyHeights 版本2数据库中未记录创建高度的未花费utxo在迁移时统计
+func TestMigrateLegacyHeights(t *testing.T) {
+	conf := &config.DBConfig{DBType: string(tmdb.GoLevelDBBackend), Dir: t.TempDir()}
+	setRawKeys(t, conf.Dir, "utxo", map[string][]byte{
+		"s:schema":        pkg.Int64ToBytes(2),
+		db.StoreHeight:    pkg.Int64ToBytes(2),
+		testUKey("aa", 0): mustMarshal(t, &db.UtxoInfo{Address: testAddress, Value: 1}),
+		testUKey("aa", 1): mustMarshal(t, &db.UtxoInfo{Address: testAddress, Value: 2}),
+		testUKey("aa", 2): mustMarshal(t, &db.UtxoInfo{Address: testAddress, Value: 3, Spend: &db.Spend{Txid: testTxid("bb"), Height: 2}}),
+		testUKey("bb", 0): mustMarshal(t, &db.UtxoInfo{Address: testAddress, Value: 3, Height: 2}),
+	})
+
+	ldb, err := db.NewDB(conf, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	if version, err := ldb.GetSchemaVersion(); err != nil || version != db.SchemaVersion {
+		t.Fatalf("unexpected schema version %d %v", version, err)
+	}
+	stats, err := ldb.Stats(0)
+	if err != nil || stats.NoHeight != 2 {
+		t.Fatalf("expected 2 utxos without height, got %+v %v", stats, err)
+	}
+
+	// reindex --from 0清空索引时一并清除
+	if err := ldb.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := ldb.GetNoHeightCount(); err != nil || n != 0 {
+		t.Fatalf("no height count after reset %d %v", n, err)
+	}
+}
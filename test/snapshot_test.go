@@ -0,0 +1,125 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/snapshot"
+)
+
+// testdata/utxo-snapshot.dat 为v2格式的合成快照，基准高度3，包含6个utxo
+// 覆盖P2PKH、P2SH、压缩/非压缩P2PK等特殊脚本及原始脚本
+func TestLoadSnapshot(t *testing.T) {
+	f, err := os.Open("testdata/utxo-snapshot.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := snapshot.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := r.Metadata()
+	if meta.Version != snapshot.Version || meta.Network != wire.MainNet || meta.CoinsCount != 6 {
+		t.Fatalf("unexpected metadata %+v", meta)
+	}
+
+	mdb := newMemDB(t)
+	var loaded uint64
+	if err := snapshot.Load(context.Background(), mdb, r, 3, 4, func(n, total uint64) { loaded = n }); err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 6 {
+		t.Fatalf("expected 6 coins loaded, got %d", loaded)
+	}
+	sheight, err := mdb.GetStoreHeight()
+	if err != nil || sheight != 3 {
+		t.Fatalf("unexpected store height %d %v", sheight, err)
+	}
+	info, err := mdb.GetUTXOSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TxOuts != 6 || info.TotalAmount != "61.73566789" || info.Height != 3 {
+		t.Fatalf("unexpected utxo set %+v", info)
+	}
+
+	h160 := make([]byte, 20)
+	for i := range h160 {
+		h160[i] = byte(i)
+	}
+	p2pkh, _ := btcutil.NewAddressPubKeyHash(h160, &chaincfg.MainNetParams)
+	p2sh, _ := btcutil.NewAddressScriptHashFromHash(h160, &chaincfg.MainNetParams)
+	p2wpkh, _ := btcutil.NewAddressWitnessPubKeyHash(h160, &chaincfg.MainNetParams)
+	// 私钥1对应的公钥即生成元G
+	_, g := btcec.PrivKeyFromBytes([]byte{1})
+	compressed, _ := btcutil.NewAddressPubKey(g.SerializeCompressed(), &chaincfg.MainNetParams)
+	uncompressed, _ := btcutil.NewAddressPubKey(g.SerializeUncompressed(), &chaincfg.MainNetParams)
+
+	for address, want := range map[string]string{
+		p2pkh.EncodeAddress():        "50.00000000",
+		p2sh.EncodeAddress():         "0.00100000",
+		p2wpkh.EncodeAddress():       "1.23456789",
+		compressed.EncodeAddress():   "0.50000000",
+		uncompressed.EncodeAddress(): "10.00000000",
+	} {
+		if balance, n := balanceOf(t, mdb, address); balance != want || n != 1 {
+			t.Fatalf("address %s balance %s count %d, want %s", address, balance, n, want)
+		}
+	}
+
+	// 已有数据时拒绝导入
+	f2, err := os.Open("testdata/utxo-snapshot.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f2.Close()
+	r2, err := snapshot.NewReader(f2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Load(context.Background(), mdb, r2, 3, 0, nil); err == nil {
+		t.Fatal("expected error loading into non-empty database")
+	}
+}
+
+// 导入合成快照后再导出，结果应与原文件完全一致
+func TestDumpSnapshot(t *testing.T) {
+	data, err := os.ReadFile("testdata/utxo-snapshot.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := snapshot.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := r.Metadata().BaseHash
+	mdb := newMemDB(t)
+	if err := snapshot.Load(context.Background(), mdb, r, 3, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	meta, height, err := snapshot.Dump(context.Background(), mdb, &buf, wire.MainNet, func(h int64) (*chainhash.Hash, error) {
+		if h != 3 {
+			t.Fatalf("unexpected base height %d", h)
+		}
+		return &base, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 3 || meta.CoinsCount != 6 {
+		t.Fatalf("unexpected dump %+v height %d", meta, height)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("dump differs from source snapshot\n%x\n%x", buf.Bytes(), data)
+	}
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	tmdb "github.com/cosmos/cosmos-db"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/server"
+	"go.uber.org/zap"
+)
+
+func decodeData(t *testing.T, body []byte, v interface{}) {
+	reply := struct {
+		Data interface{} `json:"data"`
+	}{Data: v}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAdminStatsCompact(t *testing.T) {
+	ldb, err := db.NewDB(&config.DBConfig{DBType: string(tmdb.GoLevelDBBackend), Dir: t.TempDir()}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ldb.Close()
+	testStoreBlocks(t, ldb)
+	h := server.NewServer(&config.ServerConfig{
+		Auth: &config.AuthConfig{AdminKey: "admin"},
+	}, zap.NewNop(), ldb, nil).Handler()
+	admin := map[string]string{"X-Admin-Key": "admin"}
+
+	w := doRequest(h, http.MethodGet, "/admin/stats?top=1", admin)
+	if w.Code != http.StatusOK {
+		t.Fatalf("stats status %d %s", w.Code, w.Body)
+	}
+	var stats model.DBStats
+	decodeData(t, w.Body.Bytes(), &stats)
+	if n := stats.Keys["utxo"]["u:"]; n == 0 || n != stats.Spent+stats.Unspent {
+		t.Fatalf("u: keys %d, spent %d unspent %d", n, stats.Spent, stats.Unspent)
+	}
+	if stats.Keys["balance"]["ab:"] != 1 || stats.Keys["address_utxo"]["au:"] != 1 || stats.Keys["utxo"]["s:"] == 0 {
+		t.Fatalf("unexpected key counts %+v", stats.Keys)
+	}
+	if len(stats.TopAddresses) != 1 || stats.TopAddresses[0] != (model.AddressUtxoCount{Address: testAddress2, Utxos: 4}) {
+		t.Fatalf("unexpected top addresses %+v", stats.TopAddresses)
+	}
+	for _, name := range db.StoreNames() {
+		if stats.DiskSize[name] <= 0 {
+			t.Fatalf("disk size of %s not reported: %+v", name, stats.DiskSize)
+		}
+	}
+
+	if w := doRequest(h, http.MethodGet, "/admin/compact", admin); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before compact, got %d", w.Code)
+	}
+	if w := doRequest(h, http.MethodPost, "/admin/compact", admin); w.Code != http.StatusAccepted {
+		t.Fatalf("compact status %d %s", w.Code, w.Body)
+	}
+	// 压缩期间继续存储
+	if err := ldb.Store(nil, []model.Out{testOut("b4", 0, testAddress, 1, 4)}, 4); err != nil {
+		t.Fatal(err)
+	}
+	var job model.CompactJob
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		decodeData(t, doRequest(h, http.MethodGet, "/admin/compact", admin).Body.Bytes(), &job)
+		if job.State != model.CompactRunning || time.Now().After(deadline) {
+			break
+		}
+	}
+	if job.State != model.CompactDone || len(job.Done) != 3 || len(job.SizeAfter) != 3 {
+		t.Fatalf("unexpected compact job %+v", job)
+	}
+	if balance, n := balanceOf(t, ldb, testAddress); balance != "1.00000000" || n != 1 {
+		t.Fatalf("address1 balance %s count %d after compact", balance, n)
+	}
+
+	// memdb不支持压缩
+	mh := newAuthServer(t)
+	if w := doRequest(mh, http.MethodPost, "/admin/compact", admin); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for memdb, got %d", w.Code)
+	}
+}
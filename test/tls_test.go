@@ -0,0 +1,90 @@
+package test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/wx-shi/utxo-indexer/internal/config"
+	"github.com/wx-shi/utxo-indexer/internal/rpc"
+	"github.com/wx-shi/utxo-indexer/pkg"
+	"go.uber.org/zap"
+)
+
+// writeTestCert 生成自签名证书 同时用作CA、服务端及客户端证书
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	cert, key, err := btcutil.NewTLSCertPair("utxo-indexer test", time.Now().Add(time.Hour), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "test.crt"), filepath.Join(dir, "test.key")
+	if err := os.WriteFile(certFile, cert, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestRPCMutualTLS(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	serverTLS, err := pkg.NewServerTLSConfig(certFile, keyFile, certFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result":791173,"error":null,"id":1}`))
+	}))
+	node.TLS = serverTLS
+	node.StartTLS()
+	defer node.Close()
+	addr := node.Listener.Addr().String()
+
+	// 未出示客户端证书时握手失败
+	plain, err := pkg.NewClientTLSConfig(certFile, "", "", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn, err := tls.Dial("tcp", addr, plain); err == nil {
+		if err = conn.Handshake(); err == nil {
+			_, err = conn.Read(make([]byte, 1))
+		}
+		conn.Close()
+		if err == nil {
+			t.Fatal("expected handshake failure without client cert")
+		}
+	}
+
+	client, err := rpc.NewClient(&config.RPCEndpoint{
+		URL:      addr,
+		User:     "btc",
+		Password: "btc",
+		TLS: &config.RPCTLS{
+			Enabled:    true,
+			CAFile:     certFile,
+			CertFile:   certFile,
+			KeyFile:    keyFile,
+			ServerName: "localhost",
+		},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	height, err := client.GetBlockCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 791173 {
+		t.Fatalf("unexpected height %d", height)
+	}
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/test/mocknode"
+)
+
+func postBroadcast(t *testing.T, baseURL string, tx *wire.MsgTx) (int, *model.BroadcastReply) {
+	t.Helper()
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	body, _ := json.Marshal(&model.BroadcastRequest{Hex: hex.EncodeToString(buf.Bytes())})
+	resp, err := http.Post(baseURL+"/tx/broadcast", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reply := &commonRepley{}
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Code != resp.StatusCode {
+		t.Fatalf("code %d status %d", reply.Code, resp.StatusCode)
+	}
+	br := &model.BroadcastReply{}
+	if len(reply.Data) > 0 {
+		if err := json.Unmarshal(reply.Data, br); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reply.Code, br
+}
+
+func TestApiBroadcast(t *testing.T) {
+	chain := mocknode.NewChain(addressScript(t, testAddress))
+	cb := chain.Mine().Transactions[0]
+	tx := mocknode.Spend([]wire.OutPoint{mocknode.OutPoint(cb, 0)},
+		wire.NewTxOut(btc(10), addressScript(t, testAddress2)),
+		wire.NewTxOut(btc(39), addressScript(t, testAddress)))
+	chain.Mine(tx)
+	node := mocknode.New(t, chain)
+	p := newPipeline(t, node)
+	p.waitHeight(2)
+	p.stop()
+
+	spend := func(op wire.OutPoint) *wire.MsgTx {
+		return mocknode.Spend([]wire.OutPoint{op}, wire.NewTxOut(btc(1), addressScript(t, testAddress2)))
+	}
+	expectConflict := func(op wire.OutPoint, reason, spentBy string) {
+		t.Helper()
+		code, reply := postBroadcast(t, p.api.URL, spend(op))
+		if code != http.StatusConflict || len(reply.Conflicts) != 1 {
+			t.Fatalf("%s: code %d %+v", op, code, reply)
+		}
+		c := reply.Conflicts[0]
+		if c.TxID != op.Hash.String() || c.Index != int(op.Index) || c.Reason != reason || c.SpentBy != spentBy {
+			t.Fatalf("%s: unexpected conflict %+v", op, c)
+		}
+	}
+
+	// 已在索引区块中花费
+	expectConflict(mocknode.OutPoint(cb, 0), "spent", fmt.Sprintf("%s:0", tx.TxHash()))
+	// 索引及节点中都不存在
+	expectConflict(wire.OutPoint{Hash: chainhash.Hash{1}, Index: 0}, "missing", "")
+	// 节点内存池中已花费
+	node.AddMempool(spend(mocknode.OutPoint(tx, 0)))
+	expectConflict(mocknode.OutPoint(tx, 0), "mempool_spent", "")
+
+	ok := spend(mocknode.OutPoint(tx, 1))
+	code, reply := postBroadcast(t, p.api.URL, ok)
+	if code != http.StatusOK || reply.TxID != ok.TxHash().String() || len(reply.Conflicts) != 0 {
+		t.Fatalf("broadcast: code %d %+v", code, reply)
+	}
+	mempool := node.Mempool()
+	if len(mempool) != 2 || mempool[1].TxHash() != ok.TxHash() {
+		t.Fatalf("broadcast tx not in mempool")
+	}
+	// 广播后再次花费同一输入
+	expectConflict(mocknode.OutPoint(tx, 1), "mempool_spent", "")
+}
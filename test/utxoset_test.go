@@ -0,0 +1,76 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+	"github.com/wx-shi/utxo-indexer/internal/muhash"
+)
+
+// coreTxOutSer 按Bitcoin Core的TxOutSer序列化
+func coreTxOutSer(t *testing.T, txid string, index uint32, height int64, coinbase bool, value int64, script []byte) []byte {
+	hash, err := chainhash.NewHashFromStr(txid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.Write(hash[:])
+	binary.Write(&buf, binary.LittleEndian, index)
+	code := uint32(height) << 1
+	if coinbase {
+		code |= 1
+	}
+	binary.Write(&buf, binary.LittleEndian, code)
+	binary.Write(&buf, binary.LittleEndian, value)
+	wire.WriteVarBytes(&buf, 0, script)
+	return buf.Bytes()
+}
+
+func TestUTXOSetHash(t *testing.T) {
+	mdb := newMemDB(t)
+	txid := "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"
+	p2pk := append([]byte{0x41}, bytes.Repeat([]byte{0x04}, 65)...)
+	p2pk = append(p2pk, 0xac)
+
+	cb := testOut(txid, 0, testAddress, 50, 1)
+	cb.Script, cb.Coinbase = p2pk, true
+	// 没有地址的输出也计入utxo集合
+	bare := testOut(txid, 1, "", 0.001, 1)
+	bare.Script = []byte{0x51}
+	if err := mdb.Store(nil, []model.Out{cb, bare}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := muhash.New()
+	expected.Insert(coreTxOutSer(t, txid, 0, 1, true, 5000000000, p2pk))
+	expected.Insert(coreTxOutSer(t, txid, 1, 1, false, 100000, []byte{0x51}))
+	want := chainhash.Hash(expected.Finalize()).String()
+
+	info, err := mdb.GetUTXOSetInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MuHash != want || info.TxOuts != 2 || info.TotalAmount != "50.00100000" || info.Height != 1 {
+		t.Fatalf("unexpected utxo set %+v, want muhash %s", info, want)
+	}
+
+	spend := testOut("bb", 0, testAddress2, 49, 2)
+	spend.Script = []byte{0x00, 0x14}
+	if err := mdb.Store([]model.In{testIn(txid, 0, "bb", 2), testIn(txid, 1, "bb", 2)}, []model.Out{spend}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = mdb.GetUTXOSetInfo(); err != nil || info.TxOuts != 1 || info.TotalAmount != "49.00000000" {
+		t.Fatalf("unexpected utxo set after spend %+v %v", info, err)
+	}
+
+	if _, err := mdb.Rollback(1); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = mdb.GetUTXOSetInfo(); err != nil || info.MuHash != want || info.TxOuts != 2 {
+		t.Fatalf("unexpected utxo set after rollback %+v %v", info, err)
+	}
+}
@@ -0,0 +1,96 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/wx-shi/utxo-indexer/internal/db"
+	"github.com/wx-shi/utxo-indexer/internal/model"
+)
+
+func TestVerifyRepair(t *testing.T) {
+	mdb := newMemDB(t)
+
+	if err := mdb.Store(nil, []model.Out{
+		testOut("aa", 0, testAddress, 1, 1),
+		testOut("aa", 1, testAddress, 2, 1),
+	}, 1); err != nil {
+		t.Fatal(err)
+	}
+	// 重复txid覆盖aa:0，地址1的utxo集合及余额残留旧记录
+	if err := mdb.Store(nil, []model.Out{testOut("aa", 0, testAddress2, 5, 2)}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var last int64
+	report, err := mdb.Verify(context.Background(), false, func(processed, total int64) {
+		last = total
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != 4 || report.Addresses != 2 || report.Balances != 2 {
+		t.Fatalf("unexpected progress %d %+v", last, report)
+	}
+	types := make(map[string]int)
+	for _, issue := range report.Issues {
+		types[issue.Type]++
+	}
+	if types[db.IssueAddressMismatch] != 1 || types[db.IssueBalanceMismatch] != 1 || report.IssueCount != 2 {
+		t.Fatalf("unexpected issues %+v", report.Issues)
+	}
+
+	if report, err = mdb.Verify(context.Background(), true, nil); err != nil || report.Repaired != 1 {
+		t.Fatalf("repair %+v %v", report, err)
+	}
+	if bal, n := balanceOf(t, mdb, testAddress); bal != "2.00000000" || n != 1 {
+		t.Fatalf("address 1 after repair %s %d", bal, n)
+	}
+	if report, err = mdb.Verify(context.Background(), false, nil); err != nil || report.IssueCount != 0 {
+		t.Fatalf("verify after repair %+v %v", report, err)
+	}
+}
+
+// TestVerifyRepairWithCache 启用写回缓存时，批次之间Store缓存的地址状态不能覆盖之后批次的修复结果
+func TestVerifyRepairWithCache(t *testing.T) {
+	mdb := newMemDB(t)
+	mdb.SetCache(1000000, 0)
+
+	// 1000个排在前面的地址占满第一批，地址1、2在第二批
+	var fillers []model.Out
+	for i := 0; i < 1000; i++ {
+		fillers = append(fillers, testOut("ff", i, fmt.Sprintf("0filler%04d", i), 1, 1))
+	}
+	if err := mdb.Store(nil, append(fillers,
+		testOut("aa", 0, testAddress, 1, 1),
+		testOut("aa", 1, testAddress, 2, 1),
+	), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := mdb.Store(nil, []model.Out{testOut("aa", 0, testAddress2, 5, 2)}, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored bool
+	report, err := mdb.Verify(context.Background(), true, func(processed, total int64) {
+		if processed == 1000 && !stored {
+			stored = true
+			if err := mdb.Store(nil, []model.Out{testOut("bb", 0, testAddress, 4, 3)}, 3); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	if err != nil || !stored || report.Repaired != 1 {
+		t.Fatalf("repair %+v %v stored=%v", report, err, stored)
+	}
+	if err := mdb.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if bal, n := balanceOf(t, mdb, testAddress); bal != "6.00000000" || n != 2 {
+		t.Fatalf("address 1 after repair %s %d", bal, n)
+	}
+	if report, err = mdb.Verify(context.Background(), false, nil); err != nil || report.IssueCount != 0 {
+		t.Fatalf("verify after repair %+v %v", report, err)
+	}
+}